@@ -3,16 +3,25 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/rancher/support-bundle-kit/pkg/manager"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
 )
 
 var (
 	sbm = &manager.SupportBundleManager{}
+
+	serviceMode          bool
+	maxConcurrentBundles int
+	schedule             string
+	retentionCount       int
+	retentionDays        int
+	triggers             string
 )
 
 // managerCmd represents the manager command
@@ -28,7 +37,21 @@ The manager collects following items:
 And it also waits for reports from support bundle agents. The reports contain:
 - Logs of each node.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := sbm.Run(); err != nil {
+		run := sbm.Run
+		// schedules and triggers need a service to run the collections
+		if serviceMode || schedule != "" || triggers != "" {
+			s := manager.NewService(sbm, maxConcurrentBundles)
+			s.Schedule = schedule
+			s.RetentionCount = retentionCount
+			s.RetentionDays = retentionDays
+			var err error
+			if s.Triggers, err = manager.ParseTriggers(triggers); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				os.Exit(1)
+			}
+			run = s.Run
+		}
+		if err := run(); err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 			os.Exit(1)
 		}
@@ -43,6 +66,36 @@ func getEnvStringSlice(key string) []string {
 	return strings.Split(value, ",")
 }
 
+func getEnvStringWithDefault(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return false
+	}
+	return value
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if d := parseDurationString(os.Getenv(key)); d != 0 {
+		return d
+	}
+	return defaultValue
+}
+
 func init() {
 	rootCmd.AddCommand(managerCmd)
 	managerCmd.PersistentFlags().StringSliceVar(&sbm.Namespaces, "namespaces", getEnvStringSlice("SUPPORT_BUNDLE_TARGET_NAMESPACES"), "List of namespaces delimited by ,")
@@ -55,11 +108,40 @@ func init() {
 	managerCmd.PersistentFlags().StringVar(&sbm.NodeSelector, "node-selector", os.Getenv("SUPPORT_BUNDLE_NODE_SELECTOR"), "NodeSelector of agent DaemonSet. e.g., key1=value1,key2=value2")
 	managerCmd.PersistentFlags().StringVar(&sbm.TaintToleration, "taint-toleration", os.Getenv("SUPPORT_BUNDLE_TAINT_TOLERATION"), "Toleration of agent DaemonSet. e.g., key1=value1:NoSchedule,key2=value2:NoSchedule")
 	managerCmd.PersistentFlags().StringVar(&sbm.RegistrySecret, "registry-secret", os.Getenv("SUPPORT_BUNDLE_REGISTRY_SECRET"), "The registry secret for image pull")
+	managerCmd.PersistentFlags().StringVar(&sbm.AgentTemplate, "agent-template", os.Getenv("SUPPORT_BUNDLE_AGENT_TEMPLATE"), "Path to a DaemonSet or PodTemplate file applied to the agent DaemonSet as a strategic merge patch")
+	managerCmd.PersistentFlags().StringVar(&sbm.AgentSecurityProfile, "agent-security-profile", getEnvStringWithDefault("SUPPORT_BUNDLE_AGENT_SECURITY_PROFILE", manager.AgentSecurityProfileFull), "Security profile of the agent DaemonSet: full, readonly or minimal")
 	managerCmd.PersistentFlags().StringVar(&sbm.SpecifyCollector, "specify-collector", os.Getenv("SUPPORT_BUNDLE_COLLECTOR"), "Execute specify collector script. e.g., longhorn")
 	managerCmd.PersistentFlags().StringSliceVar(&sbm.ExcludeResourceList, "exclude-resources", getEnvStringSlice("SUPPORT_BUNDLE_EXCLUDE_RESOURCES"), "List of resources to exclude. e.g., settings.harvesterhci.io,secrets")
-	managerCmd.PersistentFlags().StringSliceVar(&sbm.BundleCollectors, "extra-collectors", getEnvStringSlice("SUPPORT_BUNDLE_EXTRA_COLLECTORS"), "Get extra resource for the specific components e.g., harvester")
+	managerCmd.PersistentFlags().StringSliceVar(&sbm.BundleCollectors, "extra-collectors", getEnvStringSlice("SUPPORT_BUNDLE_EXTRA_COLLECTORS"), "Collectors of extra resources: harvester, longhorn, kubevirt, rancher or the path of a collector spec file")
 	managerCmd.PersistentFlags().StringVar(&sbm.Description, "description", os.Getenv("SUPPORT_BUNDLE_DESCRIPTION"), "The support bundle description")
 	managerCmd.PersistentFlags().StringVar(&sbm.IssueURL, "issue-url", os.Getenv("SUPPORT_BUNDLE_ISSUE_URL"), "The support bundle issue url")
+	managerCmd.PersistentFlags().StringVar(&sbm.NodeCollectionMode, "node-collection-mode", getEnvStringWithDefault("SUPPORT_BUNDLE_NODE_COLLECTION_MODE", manager.NodeCollectionModeAgent), "How node bundles are collected: agent (privileged DaemonSet) or apiserver (kubelet proxy through the API server)")
+	managerCmd.PersistentFlags().BoolVar(&sbm.SecureAPI, "secure-api", getEnvBool("SUPPORT_BUNDLE_SECURE_API"), "Serve the manager API over TLS with bearer-token authentication")
+	managerCmd.PersistentFlags().StringVar(&sbm.StateDir, "state-dir", os.Getenv("SUPPORT_BUNDLE_STATE_DIR"), "A persistent directory, e.g. on a PVC, to keep the collection state and bundle so a restarted manager resumes the collection")
+	managerCmd.PersistentFlags().BoolVar(&serviceMode, "service", getEnvBool("SUPPORT_BUNDLE_SERVICE"), "Keep running and collect bundles requested over the API, --bundlename names the service")
+	managerCmd.PersistentFlags().IntVar(&maxConcurrentBundles, "max-concurrent-bundles", getEnvInt("SUPPORT_BUNDLE_MAX_CONCURRENT", 1), "In service mode, the number of bundles collected at a time, others are queued")
+	managerCmd.PersistentFlags().StringVar(&schedule, "schedule", os.Getenv("SUPPORT_BUNDLE_SCHEDULE"), "Cron expression to collect bundles periodically in service mode, implies --service. e.g., \"0 2 * * *\" or @daily")
+	managerCmd.PersistentFlags().IntVar(&retentionCount, "retention-count", getEnvInt("SUPPORT_BUNDLE_RETENTION_COUNT", 0), "In service mode, the number of finished bundles kept, 0 keeps all")
+	managerCmd.PersistentFlags().IntVar(&retentionDays, "retention-days", getEnvInt("SUPPORT_BUNDLE_RETENTION_DAYS", 0), "In service mode, the days finished bundles are kept, 0 keeps them forever")
+	managerCmd.PersistentFlags().StringVar(&triggers, "triggers", os.Getenv("SUPPORT_BUNDLE_TRIGGERS"), "Conditions collecting a bundle in service mode, implies --service. e.g., pod-restarts:threshold=5;node-not-ready:duration=5m;warning-event:pattern=OOMKilling,cooldown=1h")
+	managerCmd.PersistentFlags().StringSliceVar(&sbm.Phases, "phases", getEnvStringSlice("SUPPORT_BUNDLE_PHASES"), "Phases to run, their dependencies are included. e.g., cluster-bundle,node-bundle")
+	managerCmd.PersistentFlags().StringSliceVar(&sbm.SkipPhases, "skip-phases", getEnvStringSlice("SUPPORT_BUNDLE_SKIP_PHASES"), "Phases to skip. e.g., prometheus-bundle")
+	managerCmd.PersistentFlags().StringVar(&sbm.PhaseOptions, "phase-options", os.Getenv("SUPPORT_BUNDLE_PHASE_OPTIONS"), "Timeout, retries and failure handling per phase. e.g., node-bundle:timeout=20m,retries=1;cluster-bundle:failure=soft")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.URL, "prometheus-url", os.Getenv("SUPPORT_BUNDLE_PROMETHEUS_URL"), "URL of the Prometheus, instead of finding it in the cluster")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.Namespace, "prometheus-namespace", getEnvStringWithDefault("SUPPORT_BUNDLE_PROMETHEUS_NAMESPACE", manager.DefaultPrometheusNamespace), "Namespace of the Prometheus")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.Service, "prometheus-service", os.Getenv("SUPPORT_BUNDLE_PROMETHEUS_SERVICE"), "Service of the Prometheus to use instead of its pods. e.g., rancher-monitoring-prometheus:9090")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.Selector, "prometheus-selector", getEnvStringWithDefault("SUPPORT_BUNDLE_PROMETHEUS_SELECTOR", manager.DefaultPrometheusSelector), "Label selector of the Prometheus pods")
+	managerCmd.PersistentFlags().IntVar(&sbm.Prometheus.Port, "prometheus-port", getEnvInt("SUPPORT_BUNDLE_PROMETHEUS_PORT", utils.PrometheusPort), "Port of the Prometheus pods and service")
+	managerCmd.PersistentFlags().BoolVar(&sbm.Prometheus.TLS, "prometheus-tls", getEnvBool("SUPPORT_BUNDLE_PROMETHEUS_TLS"), "Connect to the Prometheus over TLS")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.CAFile, "prometheus-ca", os.Getenv("SUPPORT_BUNDLE_PROMETHEUS_CA"), "CA certificate file to verify the Prometheus")
+	managerCmd.PersistentFlags().BoolVar(&sbm.Prometheus.InsecureSkipVerify, "prometheus-insecure-skip-verify", getEnvBool("SUPPORT_BUNDLE_PROMETHEUS_INSECURE_SKIP_VERIFY"), "Don't verify the certificate of the Prometheus")
+	managerCmd.PersistentFlags().DurationVar(&sbm.Prometheus.Range, "prometheus-range", getEnvDuration("SUPPORT_BUNDLE_PROMETHEUS_RANGE", manager.DefaultPrometheusRange), "How far back the Prometheus range queries go")
+	managerCmd.PersistentFlags().StringVar(&sbm.Prometheus.QueriesFile, "prometheus-queries", os.Getenv("SUPPORT_BUNDLE_PROMETHEUS_QUERIES"), "YAML file of names and PromQL range queries replacing the default queries")
+	managerCmd.PersistentFlags().IntVar(&sbm.MetricsSamples, "metrics-samples", getEnvInt("SUPPORT_BUNDLE_METRICS_SAMPLES", manager.DefaultMetricsSamples), "Number of metrics-server samples of node and pod usage")
+	managerCmd.PersistentFlags().DurationVar(&sbm.MetricsInterval, "metrics-interval", getEnvDuration("SUPPORT_BUNDLE_METRICS_INTERVAL", manager.DefaultMetricsInterval), "Time between metrics-server samples")
+	managerCmd.PersistentFlags().BoolVar(&sbm.ExternalBundles, "external-bundles", getEnvBool("SUPPORT_BUNDLE_EXTERNAL_BUNDLES"), "Collect the bundles of other products, e.g. Longhorn, without selecting the external bundle phase")
+	managerCmd.PersistentFlags().DurationVar(&sbm.ExternalBundleTimeout, "external-bundle-timeout", getEnvDuration("SUPPORT_BUNDLE_EXTERNAL_BUNDLE_TIMEOUT", manager.DefaultExternalBundleTimeout), "Time to wait for the bundles of other products, e.g. Longhorn")
+	managerCmd.PersistentFlags().StringVar(&sbm.ExternalBundleProviders, "external-bundle-providers", os.Getenv("SUPPORT_BUNDLE_EXTERNAL_BUNDLE_PROVIDERS"), "YAML file of HTTP providers of external bundles, added to the built-in ones")
 	managerCmd.PersistentFlags().DurationVar(&sbm.NodeTimeout, "node-timeout", parseDurationString(os.Getenv("SUPPORT_BUNDLE_NODE_TIMEOUT")), "The support bundle node collection time out")
 }
 
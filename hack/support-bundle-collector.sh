@@ -50,6 +50,17 @@ zip -r node_bundle.zip $(basename ${BUNDLE_DIR})
 rm -rf bundle
 
 set -o errexit
-curl -v -i -H "Content-Type: application/zip" --data-binary @node_bundle.zip "${SUPPORT_BUNDLE_MANAGER_URL}/nodes/${NODE_NAME}"
+# collections of a manager service have their own upload path
+UPLOAD_URL="${SUPPORT_BUNDLE_MANAGER_URL}${SUPPORT_BUNDLE_UPLOAD_PATH:-/v1/nodes}/${NODE_NAME}"
+if [ -n "$SUPPORT_BUNDLE_MANAGER_TOKEN" ]; then
+    # don't leak the token into the agent log
+    set +x
+    curl -sS -i --fail --cacert "${SUPPORT_BUNDLE_MANAGER_CA}" \
+        -H "Authorization: Bearer ${SUPPORT_BUNDLE_MANAGER_TOKEN}" \
+        -H "Content-Type: application/zip" --data-binary @node_bundle.zip "${UPLOAD_URL}"
+    set -x
+else
+    curl -v -i -H "Content-Type: application/zip" --data-binary @node_bundle.zip "${UPLOAD_URL}"
+fi
 
 sleep infinity
@@ -0,0 +1,412 @@
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+// APIError is returned when the manager answers with an error status
+type APIError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("support bundle manager returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("support bundle manager returned %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Client talks to the v1 API of a support bundle manager
+type Client struct {
+	baseURL string
+	// prefix replaces the v1 prefix of paths, for collections of a service
+	prefix     string
+	token      string
+	caPEM      []byte
+	httpClient *http.Client
+}
+
+type ClientOption func(*Client)
+
+// WithToken authenticates requests with a bearer token, the consumer token for
+// status, download and cancel, the agent token for node uploads
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithCA verifies the manager certificate with a PEM encoded CA
+func WithCA(caPEM []byte) ClientOption {
+	return func(c *Client) {
+		c.caPEM = caPEM
+	}
+}
+
+// WithHTTPClient replaces the default HTTP client. WithCA is ignored then.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// NewClient returns a client for the manager at baseURL, e.g.
+// https://10.52.0.10:8080
+func NewClient(baseURL string, opts ...ClientOption) (*Client, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, errors.Wrap(err, "invalid manager URL")
+	}
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		prefix:  PathPrefix,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if c.caPEM != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(c.caPEM) {
+				return nil, errors.New("invalid manager CA")
+			}
+			transport.TLSClientConfig = &tls.Config{
+				RootCAs:    pool,
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		c.httpClient = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	if c.prefix != PathPrefix && strings.HasPrefix(path, PathPrefix+"/") {
+		path = c.prefix + strings.TrimPrefix(path, PathPrefix)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request and turns error statuses into an APIError. The caller
+// closes the body of a successful response.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	errResp := &ErrorResponse{}
+	if b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err == nil && json.Unmarshal(b, errResp) == nil {
+		apiErr.Errors = errResp.Errors
+	}
+	return nil, apiErr
+}
+
+// Bundle returns a client for a collection of a manager service. Status,
+// Download, Events, ListFiles, DownloadFile, Cancel and UploadNode then act
+// on that collection.
+func (c *Client) Bundle(id string) *Client {
+	bundleClient := *c
+	bundleClient.prefix = BundlePrefix(url.PathEscape(id))
+	return &bundleClient
+}
+
+// CreateBundle requests a collection from a manager service
+func (c *Client) CreateBundle(ctx context.Context, bundleReq BundleRequest) (*Bundle, error) {
+	b, err := json.Marshal(bundleReq)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, BundlesPath, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	bundle := &Bundle{}
+	if err := c.doJSON(req, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// ListBundles lists the collections of a manager service
+func (c *Client) ListBundles(ctx context.Context) (*BundleList, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, BundlesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	bundles := &BundleList{}
+	if err := c.doJSON(req, bundles); err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// GetBundle returns a collection of a manager service
+func (c *Client) GetBundle(ctx context.Context, id string) (*Bundle, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, BundlePrefix(url.PathEscape(id)), nil)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{}
+	if err := c.doJSON(req, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// DeleteBundle removes a finished or queued collection of a manager service
+// and its files
+func (c *Client) DeleteBundle(ctx context.Context, id string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, BundlePrefix(url.PathEscape(id)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetSchedule returns the schedule and retention of a manager service
+func (c *Client) GetSchedule(ctx context.Context) (*Schedule, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, SchedulePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &Schedule{}
+	if err := c.doJSON(req, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (c *Client) doJSON(req *http.Request, obj interface{}) error {
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if err := json.NewDecoder(resp.Body).Decode(obj); err != nil {
+		return errors.Wrap(err, "fail to decode response")
+	}
+	return nil
+}
+
+// Status returns the status of the collection
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, StatusPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	status := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, errors.Wrap(err, "fail to decode status")
+	}
+	return status, nil
+}
+
+// Download writes the bundle to w and returns its file name and size
+func (c *Client) Download(ctx context.Context, w io.Writer) (string, int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, BundlePath, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	fileName, err := utils.HttpGetDispositionFilename(resp.Header.Get("Content-Disposition"))
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "fail to download bundle")
+	}
+	return fileName, size, nil
+}
+
+// ListFiles lists the bundle tree under a path, the root with an empty path
+func (c *Client) ListFiles(ctx context.Context, path string, recursive bool) (*FileList, error) {
+	reqPath := TreePath
+	if path != "" {
+		reqPath += "/" + escapePath(path)
+	}
+	if recursive {
+		reqPath += "?recursive=true"
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, reqPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	files := &FileList{}
+	if err := json.NewDecoder(resp.Body).Decode(files); err != nil {
+		return nil, errors.Wrap(err, "fail to decode file list")
+	}
+	return files, nil
+}
+
+// DownloadFile writes a file of the bundle tree to w, starting at offset to
+// resume an interrupted download. Directories are downloaded as zip.
+func (c *Client) DownloadFile(ctx context.Context, path string, w io.Writer, offset int64) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, FilesPath+"/"+escapePath(path), nil)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%s can't be resumed", path)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, errors.Wrapf(err, "fail to download %s", path)
+	}
+	return n, nil
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// Cancel aborts the collection, with partial set what was collected so far is
+// still packaged
+func (c *Client) Cancel(ctx context.Context, partial bool) error {
+	b, err := json.Marshal(CancelRequest{Partial: partial})
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, CancelPath, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// UploadNode uploads the zipped bundle of a node
+func (c *Client) UploadNode(ctx context.Context, nodeName string, bundle io.Reader) error {
+	req, err := c.newRequest(ctx, http.MethodPost, NodesPath+"/"+url.PathEscape(nodeName), bundle)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Events streams progress events after lastID to fn until the context is
+// done, the stream ends, or fn returns an error
+func (c *Client) Events(ctx context.Context, lastID int64, fn func(Event) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, EventsPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastID, 10))
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// ids and types are part of the data, comments are keepalives
+			continue
+		}
+		event := Event{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return errors.Wrap(err, "fail to decode event")
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package v1
+
+import (
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI description of the v1 API
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
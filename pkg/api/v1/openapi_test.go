@@ -0,0 +1,22 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestOpenAPIPaths(t *testing.T) {
+	doc := struct {
+		Paths map[string]interface{} `json:"paths"`
+	}{}
+	assert.Nil(t, yaml.Unmarshal(OpenAPI, &doc))
+
+	for _, path := range []string{StatusPath, BundlePath, CancelPath, EventsPath, NodeBundlePath, OpenAPIPath} {
+		assert.Contains(t, doc.Paths, path)
+	}
+	for _, path := range []string{TreePath, TreePath + "/{path}", FilesPath + "/{path}", BundlesPath, BundlePrefix("{bundleID}"), SchedulePath} {
+		assert.Contains(t, doc.Paths, path)
+	}
+}
@@ -0,0 +1,145 @@
+// Package v1 is the versioned REST API of the support bundle manager and a
+// client for it.
+package v1
+
+import (
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+const (
+	// PathPrefix is the prefix of all v1 endpoints
+	PathPrefix = "/v1"
+
+	StatusPath     = PathPrefix + "/status"
+	BundlePath     = PathPrefix + "/bundle"
+	EventsPath     = PathPrefix + "/events"
+	CancelPath     = PathPrefix + "/cancel"
+	NodesPath      = PathPrefix + "/nodes"
+	TreePath       = PathPrefix + "/tree"
+	FilesPath      = PathPrefix + "/files"
+	OpenAPIPath    = PathPrefix + "/openapi.yaml"
+	NodeBundlePath = NodesPath + "/{nodeName}"
+
+	// BundlesPath lists the collections of a manager service, the endpoints
+	// of a collection are served under BundlePrefix
+	BundlesPath = PathPrefix + "/bundles"
+	// SchedulePath describes the schedule and retention of a manager service
+	SchedulePath = PathPrefix + "/schedule"
+)
+
+// BundlePrefix returns the prefix of the endpoints of a collection of a
+// manager service, e.g. /v1/bundles/<id>/status
+func BundlePrefix(id string) string {
+	return BundlesPath + "/" + id
+}
+
+// Status is the status of a bundle collection
+type Status struct {
+	Phase        types.ManagerPhase `json:"phase"`
+	Error        bool               `json:"error"`
+	ErrorMessage string             `json:"errorMessage,omitempty"`
+	Progress     int                `json:"progress"`
+	FileName     string             `json:"fileName,omitempty"`
+	FileSize     int64              `json:"fileSize,omitempty"`
+	Cancelled    bool               `json:"cancelled,omitempty"`
+	Partial      bool               `json:"partial,omitempty"`
+}
+
+// CancelRequest cancels the collection
+type CancelRequest struct {
+	// package what was collected so far
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Event is an event of the progress stream
+type Event = types.ManagerEvent
+
+// FileInfo is a file or directory of the bundle tree. Node bundle zips are
+// archives that can be browsed like directories.
+type FileInfo struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime,omitempty"`
+	Dir     bool   `json:"dir,omitempty"`
+	Archive bool   `json:"archive,omitempty"`
+}
+
+// FileList lists the bundle tree under a path
+type FileList struct {
+	Path  string     `json:"path"`
+	Files []FileInfo `json:"files"`
+}
+
+// BundleRequest requests a collection from a manager service. Empty fields
+// take the settings of the service.
+type BundleRequest struct {
+	Description        string   `json:"description,omitempty"`
+	IssueURL           string   `json:"issueURL,omitempty"`
+	Namespaces         []string `json:"namespaces,omitempty"`
+	ExtraCollectors    []string `json:"extraCollectors,omitempty"`
+	ExcludeResources   []string `json:"excludeResources,omitempty"`
+	NodeCollectionMode string   `json:"nodeCollectionMode,omitempty"`
+	Phases             []string `json:"phases,omitempty"`
+	SkipPhases         []string `json:"skipPhases,omitempty"`
+}
+
+// Bundle is a collection of a manager service
+type Bundle struct {
+	ID        string                   `json:"id"`
+	CreatedAt string                   `json:"createdAt"`
+	State     types.SupportBundleState `json:"state"`
+	// waiting for a free collection slot
+	Queued bool `json:"queued,omitempty"`
+	// requested by the schedule of the service
+	Scheduled bool `json:"scheduled,omitempty"`
+	// the condition that requested the collection, if any
+	Trigger *Trigger      `json:"trigger,omitempty"`
+	Request BundleRequest `json:"request"`
+	Status  Status        `json:"status"`
+}
+
+// Trigger is a condition in the cluster that requested a collection of a
+// manager service, it's also written to metadata.yaml of the bundle
+type Trigger struct {
+	// kind of the trigger, e.g. pod-restarts
+	Name string `json:"name" yaml:"name"`
+	// the triggering object, e.g. Pod harvester-system/virt-api-6d8f9
+	Object string `json:"object" yaml:"object"`
+	Reason string `json:"reason" yaml:"reason"`
+	Time   string `json:"time" yaml:"time"`
+}
+
+// BundleList lists the collections of a manager service, oldest first
+type BundleList struct {
+	Bundles []Bundle `json:"bundles"`
+}
+
+// Schedule is the schedule of a manager service and the retention of its
+// collections
+type Schedule struct {
+	// cron expression, empty when bundles are only collected on request
+	Schedule string `json:"schedule,omitempty"`
+	NextRun  string `json:"nextRun,omitempty"`
+	// number of finished collections kept, zero keeps all
+	RetentionCount int `json:"retentionCount,omitempty"`
+	// days finished collections are kept, zero keeps them forever
+	RetentionDays int `json:"retentionDays,omitempty"`
+}
+
+// ErrorResponse is returned by the manager on failed requests
+type ErrorResponse struct {
+	Errors []string `json:"errors,omitempty"`
+}
+
+func NewStatus(status types.ManagerStatus) Status {
+	return Status{
+		Phase:        status.Phase,
+		Error:        status.Error,
+		ErrorMessage: status.ErrorMessage,
+		Progress:     status.Progress,
+		FileName:     status.FileName,
+		FileSize:     status.FileSize,
+		Cancelled:    status.Cancelled,
+		Partial:      status.Partial,
+	}
+}
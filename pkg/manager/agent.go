@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -28,21 +29,15 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) (*appsv1.Daemon
 	logrus.Debugf("Creating daemonset %s with image %s", dsName, image)
 
 	// get manager pod for owner reference
-	labels := fmt.Sprintf("app=%s,%s=%s", types.SupportBundleManager, types.SupportBundleLabelKey, a.sbm.BundleName)
-
-	pods, err := a.sbm.k8s.GetPodsListByLabels(a.sbm.PodNamespace, labels)
+	managerPod, err := a.sbm.getManagerPod(a.sbm.getCollectionContext())
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pods.Items) == 0 {
-		return nil, errors.New("no support bundle manager pod found")
-	}
-
-	if len(pods.Items) != 1 {
-		return nil, errors.New("more than one support bundle manager pods are found")
+	security, err := getAgentSecuritySettings(a.sbm.AgentSecurityProfile, getCollectorRequirement(a.sbm.SpecifyCollector))
+	if err != nil {
+		return nil, err
 	}
-	managerPod := pods.Items[0]
 
 	daemonSet := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -75,21 +70,22 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) (*appsv1.Daemon
 				Spec: corev1.PodSpec{
 					NodeSelector: a.sbm.getNodeSelector(),
 					Tolerations:  a.sbm.getTaintToleration(),
+					HostPID:      security.HostPID,
 					Containers: []corev1.Container{
 						{
-							Name:            "agent",
+							Name:            agentContainerName,
 							Image:           image,
 							Args:            []string{"/usr/bin/support-bundle-collector.sh"},
 							ImagePullPolicy: corev1.PullPolicy(a.sbm.ImagePullPolicy),
-							SecurityContext: &corev1.SecurityContext{
-								Capabilities: &corev1.Capabilities{
-									Add: []corev1.Capability{"SYSLOG"},
-								},
-							},
+							SecurityContext: security.SecurityContext,
 							Env: []corev1.EnvVar{
 								{
 									Name:  "SUPPORT_BUNDLE_HOST_PATH",
-									Value: "/host",
+									Value: agentHostPath,
+								},
+								{
+									Name:  "SUPPORT_BUNDLE_SECURITY_PROFILE",
+									Value: a.sbm.AgentSecurityProfile,
 								},
 								{
 									Name: "SUPPORT_BUNDLE_NODE_NAME",
@@ -104,28 +100,18 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) (*appsv1.Daemon
 									Value: managerURL,
 								},
 								{
-									Name:  "SUPPORT_BUNDLE_COLLECTOR",
-									Value: a.sbm.SpecifyCollector,
+									Name:  "SUPPORT_BUNDLE_UPLOAD_PATH",
+									Value: a.sbm.getNodeUploadPath(),
 								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
 								{
-									Name:      "host",
-									MountPath: "/host",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "host",
-							VolumeSource: corev1.VolumeSource{
-								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/",
+									Name:  "SUPPORT_BUNDLE_COLLECTOR",
+									Value: a.sbm.SpecifyCollector,
 								},
 							},
+							VolumeMounts: security.VolumeMounts,
 						},
 					},
+					Volumes: security.Volumes,
 				},
 			},
 		},
@@ -144,20 +130,65 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) (*appsv1.Daemon
 		a.prepareDaemonSetForLonghorn(daemonSet)
 	}
 
+	if a.sbm.auth != nil {
+		a.prepareDaemonSetForAuth(daemonSet)
+	}
+
+	if a.sbm.agentTemplatePatch != nil {
+		daemonSet, err = applyAgentTemplate(daemonSet, a.sbm.agentTemplatePatch)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateAgentDaemonSet(daemonSet); err != nil {
+			return nil, errors.Wrap(err, "invalid agent template")
+		}
+		if _, err := a.sbm.k8s.DryRunCreateDaemonSets(a.sbm.PodNamespace, daemonSet); err != nil {
+			return nil, errors.Wrap(err, "invalid agent template")
+		}
+	}
+
 	return a.sbm.k8s.CreateDaemonSets(a.sbm.PodNamespace, daemonSet)
 }
 
 func (a *AgentDaemonSet) prepareDaemonSetForLonghorn(daemonset *appsv1.DaemonSet) {
-	daemonset.Spec.Template.Spec.HostPID = true
 	daemonset.Spec.Template.Spec.Containers[0].Env = append(daemonset.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
 		Name:  "LONGHORN_LOG_PATH",
 		Value: os.Getenv("LONGHORN_LOG_PATH"),
 	})
 }
 
+// getManagerPod returns the pod running this manager
+func (m *SupportBundleManager) getManagerPod(ctx context.Context) (*corev1.Pod, error) {
+	labels := fmt.Sprintf("app=%s,%s=%s", types.SupportBundleManager, types.SupportBundleLabelKey, m.getManagerBundleName())
+
+	pods, err := m.k8s.WithContext(ctx).GetPodsListByLabels(m.PodNamespace, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods.Items) == 0 {
+		return nil, errors.New("no support bundle manager pod found")
+	}
+
+	if len(pods.Items) != 1 {
+		return nil, errors.New("more than one support bundle manager pods are found")
+	}
+	return &pods.Items[0], nil
+}
+
+// getManagerBundleName returns the bundle name the manager pod is labeled
+// with
+func (m *SupportBundleManager) getManagerBundleName() string {
+	if m.managerBundleName != "" {
+		return m.managerBundleName
+	}
+	return m.BundleName
+}
+
 func (a *AgentDaemonSet) Cleanup() error {
 	dsName := a.getDaemonSetName()
-	err := a.sbm.k8s.DeleteDaemonSets(a.sbm.PodNamespace, dsName)
+	// agents are also cleaned up after the collection is cancelled
+	err := a.sbm.k8s.WithContext(a.sbm.context).DeleteDaemonSets(a.sbm.PodNamespace, dsName)
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
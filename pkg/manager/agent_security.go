@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AgentSecurityProfileFull mounts the host root read-write, as required by collectors writing to the host
+	AgentSecurityProfileFull = "full"
+	// AgentSecurityProfileReadOnly mounts the host root read-only
+	AgentSecurityProfileReadOnly = "readonly"
+	// AgentSecurityProfileMinimal grants only what the collector needs: the host paths it reads, read-only, or
+	// the host root when it chroots into the host, and the capabilities of the commands it runs
+	AgentSecurityProfileMinimal = "minimal"
+
+	agentHostPath = "/host"
+)
+
+// hostPath is a host path read by a collector script
+type hostPath struct {
+	Path string
+	// Type is checked by the kubelet, an untyped path missing on the host
+	// would be created
+	Type corev1.HostPathType
+	// Optional paths are read only when they exist, e.g. multipath.conf.
+	// They're left out of the minimal profile since a typed path missing
+	// on the host keeps the agent from starting.
+	Optional bool
+}
+
+// collectorRequirement describes what a node collector script needs from the host
+type collectorRequirement struct {
+	// host paths read by the collector
+	Paths []hostPath
+	// commands are run chrooted into the host, e.g. journalctl
+	Chroot bool
+	// dmesg needs CAP_SYSLOG
+	Syslog bool
+	// the collector inspects host processes
+	HostPID bool
+}
+
+// hostPaths of the OS detection of support-bundle-collector.sh
+var osDetectionHostPaths = []hostPath{
+	{Path: "/etc/hostname", Type: corev1.HostPathFile},
+	{Path: "/etc/os-release", Type: corev1.HostPathFile},
+}
+
+var harvesterCollectorRequirement = collectorRequirement{
+	Paths: append([]hostPath{
+		{Path: "/etc/harvester-release.yaml", Type: corev1.HostPathFile},
+		{Path: "/etc/rancher", Type: corev1.HostPathDirectory},
+		{Path: "/oem", Type: corev1.HostPathDirectory},
+		{Path: "/var/log", Type: corev1.HostPathDirectory},
+		{Path: "/var/lib/rancher/rke2/agent/logs", Type: corev1.HostPathDirectory},
+		{Path: "/var/lib/rancher/rke2/agent/containerd/containerd.log", Type: corev1.HostPathFile},
+		// ctr lists the images through the containerd socket
+		{Path: "/var/lib/rancher/rke2/bin", Type: corev1.HostPathDirectory},
+		{Path: "/run/k3s/containerd/containerd.sock", Type: corev1.HostPathSocket},
+	}, osDetectionHostPaths...),
+	Chroot: true,
+}
+
+// collectorRequirements are keyed by the collector names of hack/collector-*
+var collectorRequirements = map[string]collectorRequirement{
+	"harvester":         harvesterCollectorRequirement,
+	"sle-micro-rancher": harvesterCollectorRequirement,
+	"k3os": {
+		Paths: []hostPath{
+			{Path: "/etc/hostname", Type: corev1.HostPathFile},
+			{Path: "/var/log", Type: corev1.HostPathDirectory},
+		},
+		Syslog: true,
+	},
+	"longhorn": {
+		Paths: append([]hostPath{
+			{Path: "/boot", Type: corev1.HostPathDirectory},
+			{Path: "/proc/mounts", Type: corev1.HostPathFile},
+			{Path: "/etc/multipath.conf", Type: corev1.HostPathFile, Optional: true},
+			{Path: "/var/log", Type: corev1.HostPathDirectory},
+			{Path: "/var/lib/rancher/rke2/agent/logs/kubelet.log", Type: corev1.HostPathFile, Optional: true},
+		}, osDetectionHostPaths...),
+		Chroot:  true,
+		Syslog:  true,
+		HostPID: true,
+	},
+}
+
+// getCollectorRequirement returns the requirement of the collector the agent
+// runs. Without a specified collector the agent picks one by the host's OS,
+// so only the paths needed for the detection are known for sure.
+func getCollectorRequirement(collector string) collectorRequirement {
+	if req, ok := collectorRequirements[collector]; ok {
+		if collector == "longhorn" && os.Getenv("LONGHORN_LOG_PATH") != "" {
+			req.Paths = append(append([]hostPath{}, req.Paths...), hostPath{Path: os.Getenv("LONGHORN_LOG_PATH"), Type: corev1.HostPathDirectory})
+		}
+		return req
+	}
+
+	req := collectorRequirement{
+		Paths: append([]hostPath{{Path: "/var/log", Type: corev1.HostPathDirectory}}, osDetectionHostPaths...),
+	}
+	for _, r := range collectorRequirements {
+		req.Chroot = req.Chroot || r.Chroot
+		req.Syslog = req.Syslog || r.Syslog
+	}
+	return req
+}
+
+// agentSecuritySettings are the parts of the agent pod derived from a security profile
+type agentSecuritySettings struct {
+	Volumes         []corev1.Volume
+	VolumeMounts    []corev1.VolumeMount
+	SecurityContext *corev1.SecurityContext
+	HostPID         bool
+}
+
+func isValidAgentSecurityProfile(profile string) bool {
+	switch profile {
+	case AgentSecurityProfileFull, AgentSecurityProfileReadOnly, AgentSecurityProfileMinimal:
+		return true
+	}
+	return false
+}
+
+func getAgentSecuritySettings(profile string, req collectorRequirement) (*agentSecuritySettings, error) {
+	hostRoot := func(readOnly bool) *agentSecuritySettings {
+		return &agentSecuritySettings{
+			Volumes: []corev1.Volume{
+				{
+					Name: "host",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{
+							Path: "/",
+						},
+					},
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "host",
+					MountPath: agentHostPath,
+					ReadOnly:  readOnly,
+				},
+			},
+		}
+	}
+
+	switch profile {
+	case AgentSecurityProfileFull:
+		// same as before profiles were introduced
+		settings := hostRoot(false)
+		settings.SecurityContext = &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{"SYSLOG"},
+			},
+		}
+		settings.HostPID = req.HostPID
+		return settings, nil
+	case AgentSecurityProfileReadOnly:
+		// default capabilities are kept, chroot needs CAP_SYS_CHROOT
+		settings := hostRoot(true)
+		settings.SecurityContext = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: boolPtr(false),
+		}
+		if req.Syslog {
+			settings.SecurityContext.Capabilities = &corev1.Capabilities{
+				Add: []corev1.Capability{"SYSLOG"},
+			}
+		}
+		settings.HostPID = req.HostPID
+		return settings, nil
+	case AgentSecurityProfileMinimal:
+		// root in the container still needs to read files it doesn't own
+		capabilities := []corev1.Capability{"DAC_READ_SEARCH"}
+		if req.Chroot {
+			capabilities = append(capabilities, "SYS_CHROOT")
+		}
+		if req.Syslog {
+			capabilities = append(capabilities, "SYSLOG")
+		}
+		securityContext := &corev1.SecurityContext{
+			AllowPrivilegeEscalation: boolPtr(false),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  capabilities,
+			},
+		}
+
+		// commands chrooted into the host need its binaries and libraries
+		if req.Chroot {
+			settings := hostRoot(true)
+			settings.SecurityContext = securityContext
+			settings.HostPID = req.HostPID
+			return settings, nil
+		}
+
+		settings := &agentSecuritySettings{
+			SecurityContext: securityContext,
+			HostPID:         req.HostPID,
+		}
+		var paths []hostPath
+		for _, path := range req.Paths {
+			if !path.Optional {
+				paths = append(paths, path)
+			}
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return paths[i].Path < paths[j].Path
+		})
+		for i, path := range paths {
+			name := fmt.Sprintf("host-%d", i)
+			settings.Volumes = append(settings.Volumes, corev1.Volume{
+				Name: name,
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: path.Path,
+						Type: &path.Type,
+					},
+				},
+			})
+			settings.VolumeMounts = append(settings.VolumeMounts, corev1.VolumeMount{
+				Name:      name,
+				MountPath: filepath.Join(agentHostPath, path.Path),
+				ReadOnly:  true,
+			})
+		}
+		return settings, nil
+	default:
+		return nil, fmt.Errorf("invalid agent security profile %s, expect one of %s", profile,
+			strings.Join([]string{AgentSecurityProfileFull, AgentSecurityProfileReadOnly, AgentSecurityProfileMinimal}, ", "))
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetAgentSecuritySettings(t *testing.T) {
+	longhorn := getCollectorRequirement("longhorn")
+
+	full, err := getAgentSecuritySettings(AgentSecurityProfileFull, longhorn)
+	assert.Nil(t, err)
+	assert.True(t, full.HostPID)
+	assert.Equal(t, "/", full.Volumes[0].HostPath.Path)
+	assert.False(t, full.VolumeMounts[0].ReadOnly)
+	assert.Equal(t, []corev1.Capability{"SYSLOG"}, full.SecurityContext.Capabilities.Add)
+
+	readOnly, err := getAgentSecuritySettings(AgentSecurityProfileReadOnly, longhorn)
+	assert.Nil(t, err)
+	assert.True(t, readOnly.HostPID)
+	assert.Equal(t, "/", readOnly.Volumes[0].HostPath.Path)
+	assert.True(t, readOnly.VolumeMounts[0].ReadOnly)
+	assert.Equal(t, []corev1.Capability{"SYSLOG"}, readOnly.SecurityContext.Capabilities.Add)
+
+	readOnly, err = getAgentSecuritySettings(AgentSecurityProfileReadOnly, getCollectorRequirement("harvester"))
+	assert.Nil(t, err)
+	assert.False(t, readOnly.HostPID)
+	assert.Nil(t, readOnly.SecurityContext.Capabilities)
+
+	// longhorn reads the journal chrooted into the host, runs dmesg and ps
+	minimal, err := getAgentSecuritySettings(AgentSecurityProfileMinimal, longhorn)
+	assert.Nil(t, err)
+	assert.True(t, minimal.HostPID)
+	assert.Equal(t, "/", minimal.Volumes[0].HostPath.Path)
+	assert.True(t, minimal.VolumeMounts[0].ReadOnly)
+	assert.Equal(t, []corev1.Capability{"ALL"}, minimal.SecurityContext.Capabilities.Drop)
+	assert.Equal(t, []corev1.Capability{"DAC_READ_SEARCH", "SYS_CHROOT", "SYSLOG"}, minimal.SecurityContext.Capabilities.Add)
+
+	minimal, err = getAgentSecuritySettings(AgentSecurityProfileMinimal, getCollectorRequirement("k3os"))
+	assert.Nil(t, err)
+	assert.False(t, minimal.HostPID)
+	assert.Equal(t, []corev1.Capability{"ALL"}, minimal.SecurityContext.Capabilities.Drop)
+	assert.Equal(t, []corev1.Capability{"DAC_READ_SEARCH", "SYSLOG"}, minimal.SecurityContext.Capabilities.Add)
+	var mounted []string
+	for i, mount := range minimal.VolumeMounts {
+		volume := minimal.Volumes[i].HostPath
+		assert.True(t, mount.ReadOnly)
+		assert.Equal(t, agentHostPath+volume.Path, mount.MountPath)
+		assert.NotEqual(t, "/", volume.Path)
+		// the kubelet mustn't create what's missing on the host
+		assert.NotNil(t, volume.Type)
+		assert.NotEqual(t, corev1.HostPathUnset, *volume.Type)
+		mounted = append(mounted, volume.Path)
+	}
+	assert.Equal(t, []string{"/etc/hostname", "/var/log"}, mounted)
+
+	// optional files are only read through the host root
+	withoutChroot := getCollectorRequirement("longhorn")
+	withoutChroot.Chroot = false
+	minimal, err = getAgentSecuritySettings(AgentSecurityProfileMinimal, withoutChroot)
+	assert.Nil(t, err)
+	mounted = nil
+	for _, volume := range minimal.Volumes {
+		mounted = append(mounted, volume.HostPath.Path)
+	}
+	assert.Contains(t, mounted, "/proc/mounts")
+	assert.NotContains(t, mounted, "/etc/multipath.conf")
+
+	withoutChroot = getCollectorRequirement("harvester")
+	withoutChroot.Chroot = false
+	minimal, err = getAgentSecuritySettings(AgentSecurityProfileMinimal, withoutChroot)
+	assert.Nil(t, err)
+	types := map[string]corev1.HostPathType{}
+	for _, volume := range minimal.Volumes {
+		types[volume.HostPath.Path] = *volume.HostPath.Type
+	}
+	assert.Equal(t, corev1.HostPathDirectory, types["/var/lib/rancher/rke2/bin"])
+	assert.Equal(t, corev1.HostPathSocket, types["/run/k3s/containerd/containerd.sock"])
+	assert.Equal(t, corev1.HostPathFile, types["/etc/hostname"])
+
+	_, err = getAgentSecuritySettings("privileged", longhorn)
+	assert.NotNil(t, err)
+}
+
+// The harvester collector runs `chroot $HOST_PATH journalctl`, the host root
+// must be mounted and chroot allowed with the minimal profile as well
+func TestMinimalAgentSecurityForHarvester(t *testing.T) {
+	minimal, err := getAgentSecuritySettings(AgentSecurityProfileMinimal, getCollectorRequirement("harvester"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/", minimal.Volumes[0].HostPath.Path)
+	assert.Equal(t, agentHostPath, minimal.VolumeMounts[0].MountPath)
+	assert.True(t, minimal.VolumeMounts[0].ReadOnly)
+	assert.Contains(t, minimal.SecurityContext.Capabilities.Add, corev1.Capability("SYS_CHROOT"))
+	assert.Equal(t, []corev1.Capability{"ALL"}, minimal.SecurityContext.Capabilities.Drop)
+	assert.False(t, *minimal.SecurityContext.AllowPrivilegeEscalation)
+}
+
+func TestGetCollectorRequirementWithOSDetection(t *testing.T) {
+	req := getCollectorRequirement("")
+	assert.Contains(t, req.Paths, hostPath{Path: "/etc/os-release", Type: corev1.HostPathFile})
+	assert.True(t, req.Chroot)
+	assert.False(t, req.HostPID)
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const agentContainerName = "agent"
+
+// loadAgentTemplate reads an agent template overlay and turns it into a
+// strategic merge patch for the agent DaemonSet. The file is either a
+// (partial) DaemonSet or a PodTemplate whose template is applied to the
+// DaemonSet's pod template.
+func loadAgentTemplate(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read agent template")
+	}
+	return parseAgentTemplate(b)
+}
+
+func parseAgentTemplate(b []byte) ([]byte, error) {
+	b, err := yaml.ToJSON(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to parse agent template")
+	}
+
+	overlay := map[string]interface{}{}
+	if err := json.Unmarshal(b, &overlay); err != nil {
+		return nil, errors.Wrap(err, "fail to parse agent template")
+	}
+
+	kind, _ := overlay["kind"].(string)
+	switch kind {
+	case "PodTemplate":
+		overlay = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": overlay["template"],
+			},
+		}
+	case "", "DaemonSet":
+		// identity of the DaemonSet is always generated by the manager
+		delete(overlay, "apiVersion")
+		delete(overlay, "kind")
+		delete(overlay, "metadata")
+	default:
+		return nil, fmt.Errorf("unsupported agent template kind %s, expect DaemonSet or PodTemplate", kind)
+	}
+	return json.Marshal(overlay)
+}
+
+// applyAgentTemplate applies an agent template overlay on top of the generated
+// DaemonSet. Fields the manager relies on are restored afterwards.
+func applyAgentTemplate(daemonSet *appsv1.DaemonSet, patch []byte) (*appsv1.DaemonSet, error) {
+	original, err := json.Marshal(daemonSet)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, appsv1.DaemonSet{})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to apply agent template")
+	}
+
+	result := &appsv1.DaemonSet{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, errors.Wrap(err, "fail to apply agent template")
+	}
+	result.ObjectMeta = daemonSet.ObjectMeta
+	result.Spec.Selector = daemonSet.Spec.Selector
+	return result, nil
+}
+
+// validateAgentDaemonSet checks the agent DaemonSet is still usable after an
+// overlay has been applied
+func validateAgentDaemonSet(daemonSet *appsv1.DaemonSet) error {
+	selector, err := metav1.LabelSelectorAsSelector(daemonSet.Spec.Selector)
+	if err != nil {
+		return err
+	}
+	if !selector.Matches(labels.Set(daemonSet.Spec.Template.Labels)) {
+		return errors.New("agent template labels don't match the DaemonSet selector")
+	}
+
+	for _, container := range daemonSet.Spec.Template.Spec.Containers {
+		if container.Name != agentContainerName {
+			continue
+		}
+		if container.Image == "" {
+			return errors.New("agent container has no image")
+		}
+		for _, mount := range container.VolumeMounts {
+			if !hasVolume(daemonSet, mount.Name) {
+				return fmt.Errorf("agent container mounts unknown volume %s", mount.Name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("agent container %s is missing", agentContainerName)
+}
+
+func hasVolume(daemonSet *appsv1.DaemonSet, name string) bool {
+	for _, volume := range daemonSet.Spec.Template.Spec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestAgentDaemonSet() *appsv1.DaemonSet {
+	labels := map[string]string{"app": "support-bundle-agent"}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "supportbundle-agent-sample",
+			Namespace: "harvester-system",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:         agentContainerName,
+							Image:        "rancher/support-bundle-kit:master-head",
+							Env:          []corev1.EnvVar{{Name: "SUPPORT_BUNDLE_HOST_PATH", Value: "/host"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "host", MountPath: "/host"}},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyPodTemplate(t *testing.T) {
+	patch, err := parseAgentTemplate([]byte(`
+apiVersion: v1
+kind: PodTemplate
+template:
+  spec:
+    priorityClassName: system-node-critical
+    serviceAccountName: support-bundle-agent
+    containers:
+    - name: agent
+      resources:
+        limits:
+          memory: 512Mi
+      volumeMounts:
+      - name: journal
+        mountPath: /host/run/log/journal
+    volumes:
+    - name: journal
+      hostPath:
+        path: /run/log/journal
+`))
+	assert.Nil(t, err)
+
+	ds, err := applyAgentTemplate(newTestAgentDaemonSet(), patch)
+	assert.Nil(t, err)
+	assert.Nil(t, validateAgentDaemonSet(ds))
+
+	spec := ds.Spec.Template.Spec
+	assert.Equal(t, "system-node-critical", spec.PriorityClassName)
+	assert.Equal(t, "support-bundle-agent", spec.ServiceAccountName)
+	assert.Len(t, spec.Volumes, 2)
+	assert.Len(t, spec.Containers, 1)
+	assert.Len(t, spec.Containers[0].VolumeMounts, 2)
+	assert.Len(t, spec.Containers[0].Env, 1)
+	assert.Equal(t, resource.MustParse("512Mi"), spec.Containers[0].Resources.Limits[corev1.ResourceMemory])
+}
+
+func TestApplyDaemonSetTemplate(t *testing.T) {
+	patch, err := parseAgentTemplate([]byte(`
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: renamed
+spec:
+  template:
+    metadata:
+      labels:
+        team: support
+    spec:
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+            - matchExpressions:
+              - key: kubernetes.io/os
+                operator: In
+                values: ["linux"]
+`))
+	assert.Nil(t, err)
+
+	ds, err := applyAgentTemplate(newTestAgentDaemonSet(), patch)
+	assert.Nil(t, err)
+	assert.Nil(t, validateAgentDaemonSet(ds))
+	assert.Equal(t, "supportbundle-agent-sample", ds.Name)
+	assert.Equal(t, "support", ds.Spec.Template.Labels["team"])
+	assert.NotNil(t, ds.Spec.Template.Spec.Affinity)
+}
+
+func TestInvalidAgentTemplate(t *testing.T) {
+	_, err := parseAgentTemplate([]byte(`
+apiVersion: v1
+kind: Pod
+`))
+	assert.NotNil(t, err)
+
+	patch, err := parseAgentTemplate([]byte(`
+kind: PodTemplate
+template:
+  spec:
+    containers:
+    - name: agent
+      volumeMounts:
+      - name: missing
+        mountPath: /missing
+`))
+	assert.Nil(t, err)
+	ds, err := applyAgentTemplate(newTestAgentDaemonSet(), patch)
+	assert.Nil(t, err)
+	assert.NotNil(t, validateAgentDaemonSet(ds))
+
+	patch, err = parseAgentTemplate([]byte(`
+kind: PodTemplate
+template:
+  spec:
+    containers:
+    - $patch: replace
+    - name: other
+      image: busybox
+`))
+	assert.Nil(t, err)
+	ds, err = applyAgentTemplate(newTestAgentDaemonSet(), patch)
+	assert.Nil(t, err)
+	assert.NotNil(t, validateAgentDaemonSet(ds))
+}
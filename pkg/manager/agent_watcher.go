@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	agentLogTailLines = 500
+
+	agentWatchRetryInterval = 5 * time.Second
+)
+
+// agentWaitingFailureReasons are container waiting reasons that will not go
+// away without user intervention. Transient reasons like ErrImagePull are left
+// out on purpose, the kubelet turns them into a back-off state when they persist.
+var agentWaitingFailureReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+type agentPodFailure struct {
+	Reason  string
+	Message string
+}
+
+// getAgentPodFailure checks if an agent pod is stuck in a state it can't
+// recover from. Returns nil if the pod is healthy or still progressing.
+func getAgentPodFailure(pod *corev1.Pod) *agentPodFailure {
+	if pod.Status.Phase == corev1.PodFailed {
+		return &agentPodFailure{
+			Reason:  fmt.Sprintf("PodFailed: %s", pod.Status.Reason),
+			Message: pod.Status.Message,
+		}
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable {
+			return &agentPodFailure{
+				Reason:  cond.Reason,
+				Message: cond.Message,
+			}
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		if agentWaitingFailureReasons[status.State.Waiting.Reason] {
+			return &agentPodFailure{
+				Reason:  status.State.Waiting.Reason,
+				Message: status.State.Waiting.Message,
+			}
+		}
+	}
+	return nil
+}
+
+// getAgentPodNodeName returns the node an agent pod runs on. Pods that can't be
+// scheduled have no node name yet, the DaemonSet controller pins them to their
+// node with a metadata.name node affinity.
+func getAgentPodNodeName(pod *corev1.Pod) string {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return ""
+	}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		return ""
+	}
+	for _, term := range required.NodeSelectorTerms {
+		for _, field := range term.MatchFields {
+			if field.Key == "metadata.name" && field.Operator == corev1.NodeSelectorOpIn && len(field.Values) == 1 {
+				return field.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// watchAgentPods watches the pods of the agent DaemonSet until ctx is done and
+// marks a node as failed as soon as its agent pod gets stuck.
+func (m *SupportBundleManager) watchAgentPods(ctx context.Context, daemonSet *appsv1.DaemonSet) {
+	labels := fmt.Sprintf("app=%s,%s=%s", types.SupportBundleAgent, types.SupportBundleLabelKey, m.BundleName)
+
+	for {
+		watcher, err := m.k8s.WatchPodsByLabels(m.PodNamespace, labels)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to watch agent pods")
+		} else {
+			m.handleAgentPodEvents(ctx, watcher, daemonSet)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(agentWatchRetryInterval):
+		}
+	}
+}
+
+func (m *SupportBundleManager) handleAgentPodEvents(ctx context.Context, watcher watch.Interface, daemonSet *appsv1.DaemonSet) {
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				logrus.Debug("Agent pod watch channel is closed, restarting")
+				return
+			}
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			node := getAgentPodNodeName(pod)
+			if node == "" {
+				continue
+			}
+			if len(pod.OwnerReferences) != 1 || pod.OwnerReferences[0].Name != daemonSet.Name {
+				continue
+			}
+
+			failure := getAgentPodFailure(pod)
+			// nodes collected through the API server don't depend on their agents
+			if failure == nil || m.getExpectedNodeCollector(node) != NodeCollectionModeAgent {
+				continue
+			}
+			m.failNode(node, pod, failure)
+		}
+	}
+}
+
+// getExpectedNodeCollector returns how a node bundle is being collected, or an
+// empty string if the node is not expected anymore
+func (m *SupportBundleManager) getExpectedNodeCollector(node string) string {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	return m.expectedNodes[node]
+}
+
+// failNode records why the agent on a node failed, including the agent pod's
+// events and logs, and stops waiting for the node bundle.
+func (m *SupportBundleManager) failNode(node string, pod *corev1.Pod, failure *agentPodFailure) {
+	logrus.Warnf("Agent pod %s on node %s failed: %s %s", pod.Name, node, failure.Reason, failure.Message)
+
+	nodeErr := &NodeBundleError{
+		NodeName: node,
+		PodName:  pod.Name,
+		Reason:   failure.Reason,
+		Message:  failure.Message,
+		FailedAt: utils.Now(),
+	}
+
+	events, err := m.k8s.GetEventsByInvolvedObject(pod.Namespace, "Pod", pod.Name)
+	if err != nil {
+		nodeErr.EventsError = err.Error()
+	} else {
+		for _, event := range events.Items {
+			nodeErr.Events = append(nodeErr.Events, NodeBundleErrorEvent{
+				Type:           event.Type,
+				Reason:         event.Reason,
+				Message:        event.Message,
+				Count:          event.Count,
+				FirstTimestamp: event.FirstTimestamp.UTC().Format(time.RFC3339),
+				LastTimestamp:  event.LastTimestamp.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	for _, container := range pod.Spec.Containers {
+		nodeErr.Logs = append(nodeErr.Logs, m.getAgentContainerLog(pod, container.Name, false))
+		if getContainerRestartCount(pod, container.Name) > 0 {
+			nodeErr.Logs = append(nodeErr.Logs, m.getAgentContainerLog(pod, container.Name, true))
+		}
+	}
+
+	m.writeNodeError(nodeErr)
+
+	m.removeExpectedNode(node, failure.Reason)
+}
+
+func (m *SupportBundleManager) getAgentContainerLog(pod *corev1.Pod, container string, previous bool) NodeBundleErrorLog {
+	log := NodeBundleErrorLog{
+		Container: container,
+		Previous:  previous,
+	}
+
+	req := m.k8s.GetPodContainerLogTailRequest(pod.Namespace, pod.Name, container, previous, agentLogTailLines)
+	b, err := req.DoRaw(m.collectionContext)
+	if err != nil {
+		log.Error = err.Error()
+		return log
+	}
+	log.Content = strings.TrimRight(string(b), "\n")
+	return log
+}
+
+func getContainerRestartCount(pod *corev1.Pod, container string) int32 {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.RestartCount
+		}
+	}
+	return 0
+}
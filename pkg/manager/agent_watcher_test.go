@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetAgentPodFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         corev1.PodStatus
+		expectedReason string
+	}{
+		{
+			name: "running pod",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "agent", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				},
+			},
+		},
+		{
+			name: "transient image pull error",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "agent", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}}},
+				},
+			},
+		},
+		{
+			name: "image pull back-off",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "agent", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}}},
+				},
+			},
+			expectedReason: "ImagePullBackOff",
+		},
+		{
+			name: "crash loop back-off",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "agent", RestartCount: 3, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+				},
+			},
+			expectedReason: "CrashLoopBackOff",
+		},
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, Message: "node(s) had untolerated taint"},
+				},
+			},
+			expectedReason: corev1.PodReasonUnschedulable,
+		},
+		{
+			name: "evicted",
+			status: corev1.PodStatus{
+				Phase:  corev1.PodFailed,
+				Reason: "Evicted",
+			},
+			expectedReason: "PodFailed: Evicted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := getAgentPodFailure(&corev1.Pod{Status: tt.status})
+			if tt.expectedReason == "" {
+				assert.Nil(t, failure)
+				return
+			}
+			if assert.NotNil(t, failure) {
+				assert.Equal(t, tt.expectedReason, failure.Reason)
+			}
+		})
+	}
+}
+
+func TestGetAgentPodNodeName(t *testing.T) {
+	scheduled := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}}
+	assert.Equal(t, "node1", getAgentPodNodeName(scheduled))
+
+	pending := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchFields: []corev1.NodeSelectorRequirement{
+									{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node2"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, "node2", getAgentPodNodeName(pending))
+
+	assert.Equal(t, "", getAgentPodNodeName(&corev1.Pod{}))
+}
@@ -0,0 +1,232 @@
+package manager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+type apiRole string
+
+const (
+	// agents upload node bundles
+	apiRoleAgent = apiRole("agent")
+	// consumers read the status and download the bundle
+	apiRoleConsumer = apiRole("consumer")
+
+	// path the CA is mounted at in agent pods
+	agentCAPath = "/etc/support-bundle-manager"
+
+	managerCertValidity = 7 * 24 * time.Hour
+)
+
+// apiAuth holds the credentials of the manager HTTP API
+type apiAuth struct {
+	caPEM         []byte
+	cert          *tls.Certificate
+	agentToken    string
+	consumerToken string
+}
+
+func newAPIAuth(managerIP string) (*apiAuth, error) {
+	ips := []net.IP{net.ParseIP("127.0.0.1")}
+	if ip := net.ParseIP(managerIP); ip != nil {
+		ips = append(ips, ip)
+	}
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	caPEM, cert, err := utils.GenerateSelfSignedServerCert(types.SupportBundleManager, ips, dnsNames, managerCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	agentToken, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	consumerToken, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	return &apiAuth{
+		caPEM:         caPEM,
+		cert:          cert,
+		agentToken:    agentToken,
+		consumerToken: consumerToken,
+	}, nil
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (a *apiAuth) token(role apiRole) string {
+	if role == apiRoleAgent {
+		return a.agentToken
+	}
+	return a.consumerToken
+}
+
+// authorize checks the bearer token of a request against the token of a role.
+// Tokens are not interchangeable, an agent can't download the bundle.
+func (a *apiAuth) authorize(req *http.Request, role apiRole) error {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return errors.New("missing bearer token")
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token(role))) != 1 {
+		return errors.New("invalid bearer token")
+	}
+	return nil
+}
+
+// require wraps a handler so it's only served to requests with the token of the
+// role. Without auth the handler is returned as is.
+func (a *apiAuth) require(role apiRole, handler http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := a.authorize(req, role); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="support-bundle-manager"`)
+			utils.HttpResponseErrorMsg(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// createAuthSecret publishes the CA and tokens for agents and consumers. The
+// Secret is owned by the manager pod and goes away with it.
+func (m *SupportBundleManager) createAuthSecret(ctx context.Context) error {
+	managerPod, err := m.getManagerPod(ctx)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.getAuthSecretName(),
+			Namespace: m.PodNamespace,
+			Labels: map[string]string{
+				"app":                       types.SupportBundleManager,
+				types.SupportBundleLabelKey: m.BundleName,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Name:       managerPod.Name,
+					Kind:       "Pod",
+					UID:        managerPod.UID,
+					APIVersion: "v1",
+				},
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			types.SupportBundleAuthCAKey:            m.auth.caPEM,
+			types.SupportBundleAuthAgentTokenKey:    []byte(m.auth.agentToken),
+			types.SupportBundleAuthConsumerTokenKey: []byte(m.auth.consumerToken),
+		},
+	}
+	k8s := m.k8s.WithContext(ctx)
+	_, err = k8s.CreateSecret(m.PodNamespace, secret)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	// a restarted manager finds the Secret with the credentials it had
+	// before, which are replaced
+	if err := k8s.DeleteSecret(m.PodNamespace, secret.Name); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = k8s.CreateSecret(m.PodNamespace, secret)
+	return err
+}
+
+// getAuthSecretName returns the Secret with the API credentials, collections
+// of a service share the Secret of the service
+func (m *SupportBundleManager) getAuthSecretName() string {
+	if m.authSecretName != "" {
+		return m.authSecretName
+	}
+	return types.SupportBundleAuthSecretName(m.BundleName)
+}
+
+// getNodeUploadPath returns the API path agents upload node bundles to
+func (m *SupportBundleManager) getNodeUploadPath() string {
+	if m.nodeUploadPath != "" {
+		return m.nodeUploadPath
+	}
+	return apiv1.NodesPath
+}
+
+// getManagerURL returns the URL agents push node bundles to
+func (m *SupportBundleManager) getManagerURL() string {
+	scheme := "http"
+	if m.auth != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(m.ManagerPodIP, ManagerPort)
+}
+
+// prepareDaemonSetForAuth hands the CA and the agent token to agents
+func (a *AgentDaemonSet) prepareDaemonSetForAuth(daemonSet *appsv1.DaemonSet) {
+	secretName := a.sbm.getAuthSecretName()
+	spec := &daemonSet.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "manager-ca",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+				Items: []corev1.KeyToPath{
+					{Key: types.SupportBundleAuthCAKey, Path: types.SupportBundleAuthCAKey},
+				},
+			},
+		},
+	})
+
+	container := &spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "manager-ca",
+		MountPath: agentCAPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env,
+		corev1.EnvVar{
+			Name:  "SUPPORT_BUNDLE_MANAGER_CA",
+			Value: agentCAPath + "/" + types.SupportBundleAuthCAKey,
+		},
+		corev1.EnvVar{
+			Name: "SUPPORT_BUNDLE_MANAGER_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Key:                  types.SupportBundleAuthAgentTokenKey,
+				},
+			},
+		},
+	)
+}
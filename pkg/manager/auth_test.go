@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIAuthRequire(t *testing.T) {
+	auth, err := newAPIAuth("10.52.0.10")
+	assert.Nil(t, err)
+	assert.NotEqual(t, auth.agentToken, auth.consumerToken)
+
+	handler := auth.require(apiRoleConsumer, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{name: "no token", header: "", code: http.StatusUnauthorized},
+		{name: "agent token", header: "Bearer " + auth.agentToken, code: http.StatusUnauthorized},
+		{name: "not bearer", header: auth.consumerToken, code: http.StatusUnauthorized},
+		{name: "consumer token", header: "Bearer " + auth.consumerToken, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/status", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		assert.Equal(t, tt.code, w.Code, tt.name)
+	}
+}
+
+func TestAPIAuthNil(t *testing.T) {
+	var auth *apiAuth
+	handler := auth.require(apiRoleAgent, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/nodes/node1", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestAPIAuthCertificate(t *testing.T) {
+	auth, err := newAPIAuth("10.52.0.10")
+	assert.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(auth.caPEM))
+
+	leaf, err := x509.ParseCertificate(auth.cert.Certificate[0])
+	assert.Nil(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "10.52.0.10"})
+	assert.Nil(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "10.52.0.11"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+var (
+	errCollectionCancelled = errors.New("collection is cancelled")
+	errCancelNotAllowed    = errors.New("collection can't be cancelled after packaging started")
+)
+
+// Cancel aborts the collection. The in-flight phase is interrupted through
+// the collection context, and with partial set what was collected so far is
+// packaged.
+func (m *SupportBundleManager) Cancel(partial bool) error {
+	m.cancelLock.Lock()
+	defer m.cancelLock.Unlock()
+
+	if m.cancelled {
+		return nil
+	}
+	m.status.RLock()
+	phase, failed := m.status.Phase, m.status.Error
+	m.status.RUnlock()
+	if failed || phase == types.ManagerPhasePackaging || phase == types.ManagerPhaseDone {
+		return errCancelNotAllowed
+	}
+
+	logrus.Infof("Cancelling collection of support bundle %s (partial: %v)", m.BundleName, partial)
+	m.cancelled = true
+	m.cancelPartial = partial
+	if m.cancelCollection != nil {
+		m.cancelCollection()
+	}
+	return nil
+}
+
+func (m *SupportBundleManager) isCancelled() bool {
+	m.cancelLock.Lock()
+	defer m.cancelLock.Unlock()
+	return m.cancelled
+}
+
+// finishCancelled cleans up after the collection is cancelled: agents are
+// removed, nodes are no longer waited for, and a partial bundle is packaged
+// if requested
+func (m *SupportBundleManager) finishCancelled() {
+	m.cancelLock.Lock()
+	partial := m.cancelPartial
+	m.cancelLock.Unlock()
+
+	// the collection was cancelled before the manager restarted, what's left
+	// is to report it
+	if cancelled, _ := m.checkpoint.getCancelled(); cancelled {
+		m.finishCancelledState(partial)
+		return
+	}
+
+	// agents are only deployed in agent mode
+	if m.NodeCollectionMode == NodeCollectionModeAgent && m.k8s != nil {
+		agents := &AgentDaemonSet{sbm: m}
+		if err := agents.Cleanup(); err != nil {
+			logrus.WithError(err).Error("Failed to cleanup agent daemonset")
+		}
+	}
+	m.abandonNodes("Cancelled")
+
+	if partial {
+		m.status.SetPhase(types.ManagerPhasePackaging)
+		m.progress.PhaseStarted(types.ManagerPhasePackaging)
+		m.bundleFileName = m.getPartialBundleFileName()
+		m.writeMetadata()
+		if err := m.compressBundle(context.Background()); err != nil {
+			logrus.WithError(err).Error("Failed to package partial bundle")
+			m.progress.PhaseFailed(types.ManagerPhasePackaging, err.Error())
+			partial = false
+		} else {
+			m.progress.PhaseSucceeded(types.ManagerPhasePackaging, 100)
+		}
+	}
+
+	m.checkpoint.setCancelled(partial, m.bundleFileName)
+	m.finishCancelledState(partial)
+}
+
+func (m *SupportBundleManager) finishCancelledState(partial bool) {
+	m.status.SetCancelled(partial)
+	m.progress.publish(types.ManagerEvent{Type: types.ManagerEventPhase, State: types.ManagerPhaseStateCancelled})
+	m.setState(types.SupportBundleStateCancelled)
+	logrus.Infof("Collection of support bundle %s is cancelled", m.BundleName)
+}
+
+// abandonNodes stops waiting for node bundles, the nodes still expected fail
+// with the reason. Late bundles of in-flight API server collections are
+// discarded.
+func (m *SupportBundleManager) abandonNodes(reason string) {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	for name := range m.expectedNodes {
+		m.failedNodes[name] = reason
+		delete(m.expectedNodes, name)
+	}
+	if !m.done && m.ch != nil {
+		close(m.ch)
+	}
+	m.done = true
+}
+
+// getPartialBundleFileName marks the bundle file as partial. The name is only
+// known once the init phase completed.
+func (m *SupportBundleManager) getPartialBundleFileName() string {
+	if m.bundleFileName == "" {
+		return fmt.Sprintf("supportbundle_%s_%s_partial.zip", m.BundleName, strings.ReplaceAll(utils.Now(), ":", "-"))
+	}
+	return strings.TrimSuffix(m.bundleFileName, ".zip") + "_partial.zip"
+}
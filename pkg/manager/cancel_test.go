@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func newTestCancelManager(t *testing.T) *SupportBundleManager {
+	m := &SupportBundleManager{
+		BundleName:         "sample",
+		PodNamespace:       "harvester-system",
+		OutputDir:          t.TempDir(),
+		NodeCollectionMode: NodeCollectionModeAPIServer,
+		state:              NewLocalStore("harvester-system", "sample"),
+	}
+	m.collectionContext, m.cancelCollection = context.WithCancel(context.Background())
+	m.initNodeMaps()
+	assert.Nil(t, os.MkdirAll(m.getWorkingDir(), 0755))
+	return m
+}
+
+func runCancelledPhases(t *testing.T, m *SupportBundleManager, partial bool) []types.ManagerPhase {
+	var ran []types.ManagerPhase
+	phase := func(name types.ManagerPhase, run func(ctx context.Context) error) RunPhase {
+		return RunPhase{Name: name, Run: func(ctx context.Context) error {
+			ran = append(ran, name)
+			return run(ctx)
+		}}
+	}
+	ok := func(context.Context) error { return nil }
+
+	m.runPhases([]RunPhase{
+		phase(types.ManagerPhaseInit, ok),
+		phase(types.ManagerPhaseClusterBundle, func(ctx context.Context) error {
+			assert.Nil(t, os.WriteFile(filepath.Join(m.getWorkingDir(), "metadata.yaml"), []byte("bundleName: sample\n"), 0644))
+			assert.Nil(t, m.Cancel(partial))
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		phase(types.ManagerPhaseNodeBundle, ok),
+		phase(types.ManagerPhasePrometheusBundle, ok),
+		phase(types.ManagerPhasePackaging, ok),
+		phase(types.ManagerPhaseDone, ok),
+	})
+	return ran
+}
+
+func TestCancel(t *testing.T) {
+	m := newTestCancelManager(t)
+	m.expectedNodes["node1"] = NodeCollectionModeAPIServer
+
+	ran := runCancelledPhases(t, m, false)
+	assert.Equal(t, []types.ManagerPhase{types.ManagerPhaseInit, types.ManagerPhaseClusterBundle}, ran)
+	assert.True(t, m.status.Cancelled)
+	assert.False(t, m.status.Partial)
+	assert.False(t, m.status.Error)
+	assert.Empty(t, m.expectedNodes)
+	assert.Equal(t, "Cancelled", m.failedNodes["node1"])
+
+	state, err := m.state.GetState(m.PodNamespace, m.BundleName)
+	assert.Nil(t, err)
+	assert.Equal(t, types.SupportBundleStateCancelled, state)
+
+	// cancelling twice is fine
+	assert.Nil(t, m.Cancel(false))
+}
+
+func TestCancelPartial(t *testing.T) {
+	if _, err := exec.LookPath("zip"); err != nil {
+		t.Skip("zip is not installed")
+	}
+	m := newTestCancelManager(t)
+	m.bundleFileName = "supportbundle_uuid_2024-01-01T00-00-00Z.zip"
+
+	runCancelledPhases(t, m, true)
+	assert.True(t, m.status.Cancelled)
+	assert.True(t, m.status.Partial)
+	assert.Equal(t, "supportbundle_uuid_2024-01-01T00-00-00Z_partial.zip", m.status.FileName)
+	assert.FileExists(t, filepath.Join(m.OutputDir, m.status.FileName))
+}
+
+func TestCancelNotAllowed(t *testing.T) {
+	m := newTestCancelManager(t)
+	m.status.SetPhase(types.ManagerPhasePackaging)
+	assert.Equal(t, errCancelNotAllowed, m.Cancel(true))
+	assert.False(t, m.isCancelled())
+}
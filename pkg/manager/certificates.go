@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	certificatesDir = "certificates"
+	// certificates expiring sooner are reported as expiring
+	certificateExpiringWithin = 30 * 24 * time.Hour
+	// every namespace has a copy of the cluster CA, only the one of
+	// kube-system is reported
+	rootCAConfigMap = "kube-root-ca.crt"
+
+	CertificateStatusValid       = "valid"
+	CertificateStatusExpiring    = "expiring"
+	CertificateStatusExpired     = "expired"
+	CertificateStatusNotYetValid = "notYetValid"
+)
+
+// CertificateReport is written to certificates/report.yaml
+type CertificateReport struct {
+	Time    string              `yaml:"time"`
+	Sources []CertificateSource `yaml:"sources"`
+}
+
+// CertificateSource is where certificates were found, e.g. the tls.crt of
+// a Secret. Private keys are never read.
+type CertificateSource struct {
+	Kind      string `yaml:"kind"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Name      string `yaml:"name"`
+	// Key of the data, or path of the field holding the certificates
+	Key string `yaml:"key"`
+	// ChainValid tells if the chain of a TLS Secret verifies with its CA,
+	// the roots of the manager and the self-signed roots of the chain. It's
+	// left unset for a self-signed leaf, which has no chain to verify.
+	ChainValid   *bool             `yaml:"chainValid,omitempty"`
+	ChainError   string            `yaml:"chainError,omitempty"`
+	SelfSigned   bool              `yaml:"selfSigned,omitempty"`
+	Error        string            `yaml:"error,omitempty"`
+	Certificates []CertificateInfo `yaml:"certificates"`
+}
+
+type CertificateInfo struct {
+	Subject           string   `yaml:"subject"`
+	Issuer            string   `yaml:"issuer"`
+	SerialNumber      string   `yaml:"serialNumber"`
+	DNSNames          []string `yaml:"dnsNames,omitempty"`
+	IPAddresses       []string `yaml:"ipAddresses,omitempty"`
+	IsCA              bool     `yaml:"isCA"`
+	NotBefore         string   `yaml:"notBefore"`
+	NotAfter          string   `yaml:"notAfter"`
+	DaysLeft          int      `yaml:"daysLeft"`
+	Status            string   `yaml:"status"`
+	FingerprintSHA256 string   `yaml:"fingerprintSHA256"`
+}
+
+func (m *SupportBundleManager) phaseCollectCertificatesBundle(ctx context.Context) error {
+	k8s := m.k8s.WithContext(ctx)
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	now := time.Now()
+	report := &CertificateReport{Time: now.UTC().Format(time.RFC3339), Sources: []CertificateSource{}}
+	add := func(source CertificateSource, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			source.Error = err.Error()
+		}
+		source.Certificates = newCertificateInfos(certs, now)
+		report.Sources = append(report.Sources, source)
+	}
+
+	if secrets, err := k8s.GetSecretsListByType(corev1.NamespaceAll, corev1.SecretTypeTLS); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list TLS secrets: %v\n", err)
+	} else {
+		for _, secret := range secrets.Items {
+			report.Sources = append(report.Sources, newTLSSecretSource(&secret, now))
+		}
+	}
+
+	if configMaps, err := k8s.GetAllConfigMaps(corev1.NamespaceAll); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list config maps: %v\n", err)
+	} else if list, ok := configMaps.(*corev1.ConfigMapList); ok {
+		for _, configMap := range list.Items {
+			if configMap.Name == rootCAConfigMap && configMap.Namespace != "kube-system" {
+				continue
+			}
+			for _, key := range sortedKeys(configMap.Data) {
+				if !strings.Contains(configMap.Data[key], "-----BEGIN CERTIFICATE-----") {
+					continue
+				}
+				add(CertificateSource{Kind: "ConfigMap", Namespace: configMap.Namespace, Name: configMap.Name, Key: key}, []byte(configMap.Data[key]))
+			}
+		}
+	}
+
+	if webhooks, err := k8s.GetValidatingWebhookConfigurations(); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list validating webhook configurations: %v\n", err)
+	} else {
+		for _, configuration := range webhooks.Items {
+			for i, webhook := range configuration.Webhooks {
+				add(CertificateSource{Kind: "ValidatingWebhookConfiguration", Name: configuration.Name, Key: fmt.Sprintf("webhooks[%d].clientConfig.caBundle", i)}, webhook.ClientConfig.CABundle)
+			}
+		}
+	}
+	if webhooks, err := k8s.GetMutatingWebhookConfigurations(); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list mutating webhook configurations: %v\n", err)
+	} else {
+		for _, configuration := range webhooks.Items {
+			for i, webhook := range configuration.Webhooks {
+				add(CertificateSource{Kind: "MutatingWebhookConfiguration", Name: configuration.Name, Key: fmt.Sprintf("webhooks[%d].clientConfig.caBundle", i)}, webhook.ClientConfig.CABundle)
+			}
+		}
+	}
+
+	if apiServices, err := getAPIServices(k8s); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get API services: %v\n", err)
+	} else {
+		for _, apiService := range apiServices.Items {
+			add(CertificateSource{Kind: "APIService", Name: apiService.Name, Key: "spec.caBundle"}, apiService.Spec.CABundle)
+		}
+	}
+
+	logrus.Infof("Writing certificates of %d sources", len(report.Sources))
+	encodeToYAMLFile(report, filepath.Join(m.getWorkingDir(), certificatesDir, "report.yaml"), errLog)
+	return nil
+}
+
+// newTLSSecretSource reports the certificate chain of a TLS Secret, tls.key
+// is left alone
+func newTLSSecretSource(secret *corev1.Secret, now time.Time) CertificateSource {
+	source := CertificateSource{Kind: "Secret", Namespace: secret.Namespace, Name: secret.Name, Key: corev1.TLSCertKey}
+	chain, err := parseCertificates(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		source.Error = err.Error()
+	}
+	source.Certificates = newCertificateInfos(chain, now)
+	if len(chain) == 0 {
+		return source
+	}
+	if isSelfSigned(chain[0]) {
+		source.SelfSigned = true
+		return source
+	}
+
+	var cas []*x509.Certificate
+	if data := secret.Data["ca.crt"]; len(data) > 0 {
+		// a broken CA only makes the chain fail to verify
+		cas, _ = parseCertificates(data)
+	}
+	valid := true
+	if err := verifyCertificateChain(chain, cas, now); err != nil {
+		valid = false
+		source.ChainError = err.Error()
+	}
+	source.ChainValid = &valid
+	return source
+}
+
+// verifyCertificateChain verifies the leaf with the rest of the chain as
+// intermediates, the CAs and the self-signed roots of the chain as roots
+// besides the system ones
+func verifyCertificateChain(chain, cas []*x509.Certificate, now time.Time) error {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		if isSelfSigned(cert) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// isSelfSigned doesn't require a CA, unlike CheckSignatureFrom, since self
+// signed server certificates are common
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// parseCertificates parses the PEM certificates of the data. Other blocks,
+// e.g. private keys, are skipped without being decoded.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certs, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+func newCertificateInfos(certs []*x509.Certificate, now time.Time) []CertificateInfo {
+	infos := []CertificateInfo{}
+	for _, cert := range certs {
+		infos = append(infos, newCertificateInfo(cert, now))
+	}
+	return infos
+}
+
+func newCertificateInfo(cert *x509.Certificate, now time.Time) CertificateInfo {
+	info := CertificateInfo{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      cert.SerialNumber.Text(16),
+		DNSNames:          cert.DNSNames,
+		IsCA:              cert.IsCA,
+		NotBefore:         cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:          cert.NotAfter.UTC().Format(time.RFC3339),
+		DaysLeft:          int(cert.NotAfter.Sub(now).Hours() / 24),
+		FingerprintSHA256: formatFingerprint(sha256.Sum256(cert.Raw)),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	switch {
+	case now.Before(cert.NotBefore):
+		info.Status = CertificateStatusNotYetValid
+	case now.After(cert.NotAfter):
+		info.Status = CertificateStatusExpired
+	case cert.NotAfter.Sub(now) < certificateExpiringWithin:
+		info.Status = CertificateStatusExpiring
+	default:
+		info.Status = CertificateStatusValid
+	}
+	return info
+}
+
+func formatFingerprint(sum [sha256.Size]byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func sortedKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func TestNewTLSSecretSource(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ca := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	leaf := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "rancher"},
+		DNSNames:     []string{"rancher.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(10 * 24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	keyDER, err := x509.MarshalECPrivateKey(leaf.key)
+	assert.Nil(t, err)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "tls-rancher"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       leaf.pem,
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			"ca.crt":                ca.pem,
+		},
+	}
+	source := newTLSSecretSource(secret, now)
+	assert.Equal(t, "tls.crt", source.Key)
+	assert.Empty(t, source.Error)
+	assert.Equal(t, true, *source.ChainValid)
+	assert.False(t, source.SelfSigned)
+	assert.Len(t, source.Certificates, 1)
+	info := source.Certificates[0]
+	assert.Equal(t, "CN=rancher", info.Subject)
+	assert.Equal(t, "CN=test-ca", info.Issuer)
+	assert.Equal(t, []string{"rancher.example.com"}, info.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, info.IPAddresses)
+	assert.Equal(t, "2024-01-12T03:04:05Z", info.NotAfter)
+	assert.Equal(t, 10, info.DaysLeft)
+	assert.Equal(t, CertificateStatusExpiring, info.Status)
+	assert.Len(t, info.FingerprintSHA256, 32*3-1)
+
+	// without its CA, the chain doesn't verify
+	delete(secret.Data, "ca.crt")
+	source = newTLSSecretSource(secret, now)
+	assert.Equal(t, false, *source.ChainValid)
+	assert.NotEmpty(t, source.ChainError)
+
+	// the chain includes its self-signed root, a year later both expired
+	secret.Data[corev1.TLSCertKey] = append(append([]byte{}, leaf.pem...), ca.pem...)
+	source = newTLSSecretSource(secret, now.Add(400*24*time.Hour))
+	assert.Len(t, source.Certificates, 2)
+	assert.Equal(t, CertificateStatusExpired, source.Certificates[1].Status)
+	assert.Equal(t, false, *source.ChainValid)
+	assert.Contains(t, source.ChainError, "expired")
+
+	selfSigned := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "webhook"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+	}, nil)
+	// a self-signed leaf is reported as such, it has no chain to verify
+	source = newTLSSecretSource(&corev1.Secret{Data: map[string][]byte{corev1.TLSCertKey: selfSigned.pem}}, now)
+	assert.True(t, source.SelfSigned)
+	assert.Nil(t, source.ChainValid)
+	assert.Empty(t, source.ChainError)
+	assert.Len(t, source.Certificates, 1)
+
+	source = newTLSSecretSource(&corev1.Secret{Data: map[string][]byte{corev1.TLSCertKey: []byte("garbage")}}, now)
+	assert.Equal(t, "no certificate found", source.Error)
+	assert.Nil(t, source.ChainValid)
+}
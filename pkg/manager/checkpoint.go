@@ -0,0 +1,291 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+const leftoverDeleteTimeout = time.Minute
+
+// Checkpoint is the collection state persisted in the state directory. A
+// restarted manager resumes the collection from it.
+type Checkpoint struct {
+	BundleName     string        `json:"bundleName"`
+	BundleFileName string        `json:"bundleFileName"`
+	BundleMeta     *BundleMeta   `json:"bundleMeta,omitempty"`
+	Phases         []PhaseResult `json:"phases,omitempty"`
+	// collector modules and log namespaces of the cluster bundle that are done
+	Modules       []string `json:"modules,omitempty"`
+	LogNamespaces []string `json:"logNamespaces,omitempty"`
+	// nodes whose bundle was received
+	Nodes     []string `json:"nodes,omitempty"`
+	Cancelled bool     `json:"cancelled,omitempty"`
+	Partial   bool     `json:"partial,omitempty"`
+}
+
+// checkpointStore saves the checkpoint after every step. A nil store, when no
+// state directory is configured, records nothing.
+type checkpointStore struct {
+	sync.Mutex
+	path string
+	Checkpoint
+}
+
+func getCheckpointPath(stateDir, bundleName string) string {
+	return filepath.Join(stateDir, fmt.Sprintf("checkpoint-%s.json", bundleName))
+}
+
+// loadCheckpointStore reads the checkpoint of the bundle, resumed is false if
+// there is nothing to resume
+func loadCheckpointStore(stateDir, bundleName string) (*checkpointStore, bool, error) {
+	s := &checkpointStore{
+		path: getCheckpointPath(stateDir, bundleName),
+		Checkpoint: Checkpoint{
+			BundleName: bundleName,
+		},
+	}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "fail to read checkpoint")
+	}
+
+	checkpoint := Checkpoint{}
+	if err := json.Unmarshal(b, &checkpoint); err != nil {
+		logrus.WithError(err).Warnf("Ignore corrupted checkpoint %s", s.path)
+		return s, false, nil
+	}
+	if checkpoint.BundleName != bundleName || checkpoint.BundleMeta == nil {
+		logrus.Warnf("Ignore checkpoint %s of another collection", s.path)
+		return s, false, nil
+	}
+	s.Checkpoint = checkpoint
+	return s, true, nil
+}
+
+// save writes the checkpoint atomically. Must be called with the lock held.
+func (s *checkpointStore) save() {
+	b, err := json.Marshal(&s.Checkpoint)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode checkpoint")
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		logrus.WithError(err).Error("Failed to write checkpoint")
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		logrus.WithError(err).Error("Failed to write checkpoint")
+	}
+}
+
+func (s *checkpointStore) setBundle(bundleMeta *BundleMeta, bundleFileName string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	meta := *bundleMeta
+	s.BundleMeta = &meta
+	s.BundleFileName = bundleFileName
+	s.save()
+}
+
+func (s *checkpointStore) recordPhase(result PhaseResult) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for i := range s.Phases {
+		if s.Phases[i].Name == result.Name {
+			s.Phases[i] = result
+			s.save()
+			return
+		}
+	}
+	s.Phases = append(s.Phases, result)
+	s.save()
+}
+
+// getSucceededPhase returns the result of a phase that succeeded before the
+// manager restarted
+func (s *checkpointStore) getSucceededPhase(name types.ManagerPhase) (PhaseResult, bool) {
+	if s == nil {
+		return PhaseResult{}, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, result := range s.Phases {
+		if result.Name == name && result.State == types.ManagerPhaseStateSucceeded {
+			return result, true
+		}
+	}
+	return PhaseResult{}, false
+}
+
+func (s *checkpointStore) isDone(list *[]string, name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, done := range *list {
+		if done == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *checkpointStore) markDone(list *[]string, name string) {
+	s.Lock()
+	defer s.Unlock()
+	*list = append(*list, name)
+	s.save()
+}
+
+func (s *checkpointStore) isModuleDone(module string) bool {
+	return s != nil && s.isDone(&s.Modules, module)
+}
+
+func (s *checkpointStore) completeModule(module string) {
+	if s != nil {
+		s.markDone(&s.Modules, module)
+	}
+}
+
+func (s *checkpointStore) isLogNamespaceDone(namespace string) bool {
+	return s != nil && s.isDone(&s.LogNamespaces, namespace)
+}
+
+func (s *checkpointStore) completeLogNamespace(namespace string) {
+	if s != nil {
+		s.markDone(&s.LogNamespaces, namespace)
+	}
+}
+
+func (s *checkpointStore) isNodeDone(node string) bool {
+	return s != nil && s.isDone(&s.Nodes, node)
+}
+
+func (s *checkpointStore) completeNode(node string) {
+	if s != nil && !s.isNodeDone(node) {
+		s.markDone(&s.Nodes, node)
+	}
+}
+
+func (s *checkpointStore) setCancelled(partial bool, bundleFileName string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Cancelled = true
+	s.Partial = partial
+	s.BundleFileName = bundleFileName
+	s.save()
+}
+
+func (s *checkpointStore) getCancelled() (bool, bool) {
+	if s == nil {
+		return false, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.Cancelled, s.Partial
+}
+
+// initCheckpoint loads the checkpoint from the state directory and restores
+// the collection the previous manager pod left off
+func (m *SupportBundleManager) initCheckpoint() (bool, error) {
+	if m.StateDir == "" {
+		return false, nil
+	}
+	checkpoint, resumed, err := loadCheckpointStore(m.StateDir, m.BundleName)
+	if err != nil {
+		return false, err
+	}
+	m.checkpoint = checkpoint
+	if !resumed {
+		return false, nil
+	}
+
+	logrus.Infof("Resuming collection of support bundle %s from %s", m.BundleName, checkpoint.path)
+	m.bundleMeta = checkpoint.BundleMeta
+	m.bundleFileName = checkpoint.BundleFileName
+
+	cancelled, partial := checkpoint.getCancelled()
+	_, packaged := checkpoint.getSucceededPhase(types.ManagerPhasePackaging)
+	if packaged || (cancelled && partial) {
+		// the bundle directory was moved for packaging
+		if err := os.RemoveAll(m.getWorkingDir()); err != nil {
+			return false, err
+		}
+		size, err := m.getBundlefilesize()
+		if err != nil {
+			return false, errors.Wrap(err, "fail to get bundle file size")
+		}
+		m.status.SetFileinfo(m.bundleFileName, size)
+		return true, nil
+	}
+	if cancelled {
+		return true, nil
+	}
+
+	// packaging was interrupted, the bundle is zipped again
+	if _, err := os.Stat(m.getPackagedDir()); err == nil {
+		if err := os.RemoveAll(m.getWorkingDir()); err != nil {
+			return false, err
+		}
+		if err := os.Rename(m.getPackagedDir(), m.getWorkingDir()); err != nil {
+			return false, errors.Wrap(err, "fail to restore bundle directory")
+		}
+	}
+	if err := os.Remove(m.getBundlefile()); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeLeftovers deletes the agents and credentials of the previous manager
+// pod. They are garbage-collected with it, but maybe not yet.
+func (m *SupportBundleManager) removeLeftovers(ctx context.Context) error {
+	k8s := m.k8s.WithContext(ctx)
+	// the credentials of a service are not per collection
+	if !m.embedded {
+		if err := k8s.DeleteSecret(m.PodNamespace, m.getAuthSecretName()); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	if m.NodeCollectionMode != NodeCollectionModeAgent {
+		return nil
+	}
+
+	agents := &AgentDaemonSet{sbm: m}
+	if err := agents.Cleanup(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(leftoverDeleteTimeout)
+	for time.Now().Before(deadline) {
+		_, err := k8s.GetDaemonSetBy(m.PodNamespace, agents.getDaemonSetName())
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if !sleepContext(ctx, time.Second) {
+			return errCollectionCancelled
+		}
+	}
+	return fmt.Errorf("agent daemonset %s of the previous manager is not deleted", agents.getDaemonSetName())
+}
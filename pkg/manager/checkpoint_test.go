@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, resumed, err := loadCheckpointStore(dir, "sample")
+	assert.Nil(t, err)
+	assert.False(t, resumed)
+
+	s.setBundle(&BundleMeta{BundleName: "sample"}, "supportbundle_uuid.zip")
+	s.recordPhase(PhaseResult{Name: types.ManagerPhaseClusterBundle, State: types.ManagerPhaseStateFailed})
+	s.recordPhase(PhaseResult{Name: types.ManagerPhaseClusterBundle, State: types.ManagerPhaseStateSucceeded})
+	s.completeModule("cluster")
+	s.completeLogNamespace("kube-system")
+	s.completeNode("node1")
+	s.completeNode("node1")
+
+	s, resumed, err = loadCheckpointStore(dir, "sample")
+	assert.Nil(t, err)
+	assert.True(t, resumed)
+	assert.Equal(t, "supportbundle_uuid.zip", s.BundleFileName)
+	assert.Len(t, s.Phases, 1)
+	_, ok := s.getSucceededPhase(types.ManagerPhaseClusterBundle)
+	assert.True(t, ok)
+	assert.True(t, s.isModuleDone("cluster"))
+	assert.False(t, s.isModuleDone("default"))
+	assert.True(t, s.isLogNamespaceDone("kube-system"))
+	assert.Equal(t, []string{"node1"}, s.Nodes)
+
+	// checkpoints of other bundles are not resumed
+	assert.Nil(t, os.Rename(getCheckpointPath(dir, "sample"), getCheckpointPath(dir, "other")))
+	_, resumed, err = loadCheckpointStore(dir, "other")
+	assert.Nil(t, err)
+	assert.False(t, resumed)
+
+	// a nil store records nothing
+	var nilStore *checkpointStore
+	nilStore.completeNode("node1")
+	assert.False(t, nilStore.isNodeDone("node1"))
+}
+
+func TestRunPhasesResume(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := loadCheckpointStore(dir, "sample")
+	assert.Nil(t, err)
+	for _, name := range []types.ManagerPhase{types.ManagerPhaseInit, types.ManagerPhaseClusterBundle} {
+		s.recordPhase(PhaseResult{Name: name, State: types.ManagerPhaseStateSucceeded, Attempts: 1})
+	}
+
+	m := &SupportBundleManager{checkpoint: s}
+	var ran []types.ManagerPhase
+	phase := func(name types.ManagerPhase, rerun bool) RunPhase {
+		return RunPhase{Name: name, Rerun: rerun, Run: func(context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}}
+	}
+	m.runPhases([]RunPhase{
+		phase(types.ManagerPhaseInit, true),
+		phase(types.ManagerPhaseClusterBundle, false),
+		phase(types.ManagerPhaseNodeBundle, false),
+	})
+
+	assert.Equal(t, []types.ManagerPhase{types.ManagerPhaseInit, types.ManagerPhaseNodeBundle}, ran)
+	assert.Equal(t, 100, m.status.Progress)
+	assert.Len(t, m.phaseResults, 3)
+	_, ok := s.getSucceededPhase(types.ManagerPhaseNodeBundle)
+	assert.True(t, ok)
+}
+
+func TestInitCheckpointInterruptedPackaging(t *testing.T) {
+	m := &SupportBundleManager{BundleName: "sample", StateDir: t.TempDir()}
+	m.OutputDir = m.StateDir
+	s, _, err := loadCheckpointStore(m.StateDir, m.BundleName)
+	assert.Nil(t, err)
+	s.setBundle(&BundleMeta{BundleName: "sample"}, "supportbundle_uuid.zip")
+	m.bundleFileName = "supportbundle_uuid.zip"
+
+	// the pod was evicted while zipping
+	assert.Nil(t, os.MkdirAll(m.getPackagedDir(), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(m.getPackagedDir(), "metadata.yaml"), []byte("bundlename: sample\n"), 0644))
+	assert.Nil(t, os.WriteFile(m.getBundlefile(), []byte("truncated"), 0644))
+	assert.Nil(t, os.MkdirAll(m.getWorkingDir(), 0755))
+
+	resumed, err := m.initCheckpoint()
+	assert.Nil(t, err)
+	assert.True(t, resumed)
+	assert.FileExists(t, filepath.Join(m.getWorkingDir(), "metadata.yaml"))
+	assert.NoFileExists(t, m.getBundlefile())
+	assert.NoDirExists(t, m.getPackagedDir())
+}
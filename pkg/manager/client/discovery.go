@@ -2,11 +2,13 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 
 	"github.com/sirupsen/logrus"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
@@ -42,81 +44,96 @@ func NewDiscoveryClient(ctx context.Context, config *rest.Config) (*DiscoveryCli
 	}, nil
 }
 
-// Get extra resource/namespace and try to do specific filter with module name
-func (dc *DiscoveryClient) SpecificResourcesForNamespace(toObj ParseResult, moduleName, namespace string, targetResource []string, errLog io.Writer) (map[string]interface{}, error) {
-
-	// If we upgrade to golang v1.18, use slice.contain to replcae checing
-	resourceChecking := make(map[string]bool)
-	for _, resource := range targetResource {
-		resourceChecking[resource] = true
+// WithContext returns a client sharing the connection but bound to another context
+func (dc *DiscoveryClient) WithContext(ctx context.Context) *DiscoveryClient {
+	return &DiscoveryClient{
+		Context:         ctx,
+		discoveryClient: dc.discoveryClient,
 	}
+}
 
-	objs := make(map[string]interface{})
-
-	lists, err := dc.discoveryClient.ServerPreferredResources()
+// HasResource tells if the API server serves the resource, e.g. to detect
+// if a product is installed
+func (dc *DiscoveryClient) HasResource(gvr schema.GroupVersionResource) (bool, error) {
+	// the resources of the group version are requested with the context of
+	// the client, which the discovery interface doesn't take
+	prefix := "apis"
+	if gvr.GroupVersion().String() == "v1" {
+		prefix = "api"
+	}
+	b, err := dc.discoveryClient.RESTClient().Get().AbsPath("/"+prefix, gvr.GroupVersion().String()).Do(dc.Context).Raw()
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-
-	for _, list := range lists {
-		if len(list.APIResources) == 0 {
-			continue
+	list := &metav1.APIResourceList{}
+	if err := json.Unmarshal(b, list); err != nil {
+		return false, err
+	}
+	for _, resource := range list.APIResources {
+		if resource.Name == gvr.Resource {
+			return true, nil
 		}
-		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+	}
+	return false, nil
+}
+
+// LookupResource finds the resource served by the API server, with the
+// preferred version of its group when gvr has no version. It returns nil
+// when the resource isn't served.
+func (dc *DiscoveryClient) LookupResource(gvr schema.GroupVersionResource) (schema.GroupVersion, *metav1.APIResource, error) {
+	gv := gvr.GroupVersion()
+	if gv.Version == "" {
+		groups, err := dc.discoveryClient.ServerGroups()
 		if err != nil {
-			continue
+			return gv, nil, err
 		}
-
-		for _, resource := range list.APIResources {
-			if !resource.Namespaced {
-				continue
-			}
-
-			if _, exists := resourceChecking[resource.Name]; !exists {
-				continue
-			}
-			// I would like to build the URL with rest client
-			// methods, but I was not able to.  It might be
-			// possible if a new rest client is created each
-			// time with the GroupVersion
-			prefix := "apis"
-			if gv.String() == "v1" {
-				prefix = "api"
-			}
-			url := fmt.Sprintf("/%s/%s/namespaces/%s/%s", prefix, gv.String(), namespace, resource.Name)
-
-			result := dc.discoveryClient.RESTClient().Get().AbsPath(url).Do(dc.Context)
-
-			// It is likely that errors can occur.
-			if result.Error() != nil {
-				logrus.Tracef("Failed to get %s: %v", url, result.Error())
-				_, _ = fmt.Fprintf(errLog, "Failed to get %s: %v\n", url, result.Error())
-				continue
-			}
-
-			// This produces a byte array of json.
-			b, err := result.Raw()
-
-			if err == nil {
-				obj, err := toObj(b, gv.String(), resource.Kind, resource.Name)
-				if err != nil {
-					// This is unexpected. Log, but continue to try other resources.
-					logrus.Errorf("Failed to parse objects received from %s: %v", url, result.Error())
-					_, _ = fmt.Fprintf(errLog, "Failed to parse objects received from %s: %v\n", url, result.Error())
-					continue
-				}
-				// skip empty object, which will cause useless zero item yaml file
-				if obj != nil {
-					objs[gv.String()+"/"+resource.Name] = obj
-				} else {
-					logrus.Debugf("No %s/%s resource %s in namespace %s, skip", gv.String(), resource.Kind, resource.Name, namespace)
-				}
+		for _, group := range groups.Groups {
+			if group.Name == gv.Group {
+				gv.Version = group.PreferredVersion.Version
 			}
+		}
+		if gv.Version == "" {
+			return gv, nil, nil
+		}
+	}
 
+	list, err := dc.discoveryClient.ServerResourcesForGroupVersion(gv.String())
+	if apierrors.IsNotFound(err) {
+		return gv, nil, nil
+	}
+	if err != nil {
+		return gv, nil, err
+	}
+	for i, resource := range list.APIResources {
+		if resource.Name == gvr.Resource {
+			return gv, &list.APIResources[i], nil
 		}
 	}
+	return gv, nil, nil
+}
 
-	return objs, nil
+// ListResource returns the JSON list of a resource in a namespace, or
+// across the namespaces when empty
+func (dc *DiscoveryClient) ListResource(gv schema.GroupVersion, resource *metav1.APIResource, namespace string, opts metav1.ListOptions) ([]byte, error) {
+	prefix := "apis"
+	if gv.String() == "v1" {
+		prefix = "api"
+	}
+	url := fmt.Sprintf("/%s/%s/%s", prefix, gv.String(), resource.Name)
+	if resource.Namespaced && namespace != "" {
+		url = fmt.Sprintf("/%s/%s/namespaces/%s/%s", prefix, gv.String(), namespace, resource.Name)
+	}
+	req := dc.discoveryClient.RESTClient().Get().AbsPath(url)
+	if opts.LabelSelector != "" {
+		req = req.Param("labelSelector", opts.LabelSelector)
+	}
+	if opts.FieldSelector != "" {
+		req = req.Param("fieldSelector", opts.FieldSelector)
+	}
+	return req.Do(dc.Context).Raw()
 }
 
 func (dc *DiscoveryClient) ResourcesForNamespace(toObj ParseResult, namespace string, exclude ExcludeFilter, errLog io.Writer) (map[string]interface{}, error) {
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// DynamicClient manages the custom resources of other products, e.g. the
+// support bundles of Longhorn
+type DynamicClient struct {
+	Context context.Context
+	client  dynamic.Interface
+}
+
+func NewDynamicClient(ctx context.Context, config *rest.Config) (*DynamicClient, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewDynamicClientFor(ctx, client), nil
+}
+
+func NewDynamicClientFor(ctx context.Context, client dynamic.Interface) *DynamicClient {
+	return &DynamicClient{
+		Context: ctx,
+		client:  client,
+	}
+}
+
+// WithContext returns a client sharing the connection but bound to another context
+func (d *DynamicClient) WithContext(ctx context.Context) *DynamicClient {
+	return NewDynamicClientFor(ctx, d.client)
+}
+
+func (d *DynamicClient) Create(gvr schema.GroupVersionResource, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return d.client.Resource(gvr).Namespace(namespace).Create(d.Context, obj, metav1.CreateOptions{})
+}
+
+func (d *DynamicClient) Get(gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	return d.client.Resource(gvr).Namespace(namespace).Get(d.Context, name, metav1.GetOptions{})
+}
+
+func (d *DynamicClient) Delete(gvr schema.GroupVersionResource, namespace, name string) error {
+	return d.client.Resource(gvr).Namespace(namespace).Delete(d.Context, name, metav1.DeleteOptions{})
+}
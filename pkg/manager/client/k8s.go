@@ -2,19 +2,28 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"time"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 type KubernetesClient struct {
 	Context   context.Context
-	clientSet *kubernetes.Clientset
+	clientSet kubernetes.Interface
 }
 
 func NewKubernetesClient(ctx context.Context, config *rest.Config) (*KubernetesClient, error) {
@@ -28,12 +37,37 @@ func NewKubernetesClient(ctx context.Context, config *rest.Config) (*KubernetesC
 	}, nil
 }
 
+// NewKubernetesClientFor wraps a clientset, e.g. a fake one
+func NewKubernetesClientFor(ctx context.Context, clientSet kubernetes.Interface) *KubernetesClient {
+	return &KubernetesClient{
+		Context:   ctx,
+		clientSet: clientSet,
+	}
+}
+
+// WithContext returns a client sharing the connection but bound to another context
+func (k *KubernetesClient) WithContext(ctx context.Context) *KubernetesClient {
+	return &KubernetesClient{
+		Context:   ctx,
+		clientSet: k.clientSet,
+	}
+}
+
 func (k *KubernetesClient) GetNamespace(namespace string) (*corev1.Namespace, error) {
 	return k.clientSet.CoreV1().Namespaces().Get(k.Context, namespace, metav1.GetOptions{})
 }
 
 func (k *KubernetesClient) GetKubernetesVersion() (*version.Info, error) {
-	return k.clientSet.Discovery().ServerVersion()
+	// the discovery client doesn't take a context
+	body, err := k.GetRaw("/version", nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &version.Info{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
 func (k *KubernetesClient) GetAllPodsList(namespace string) (runtime.Object, error) {
@@ -44,6 +78,10 @@ func (k *KubernetesClient) GetPodsListByLabels(namespace string, labels string)
 	return k.clientSet.CoreV1().Pods(namespace).List(k.Context, metav1.ListOptions{LabelSelector: labels})
 }
 
+func (k *KubernetesClient) WatchPodsByLabels(namespace string, labels string) (watch.Interface, error) {
+	return k.clientSet.CoreV1().Pods(namespace).Watch(k.Context, metav1.ListOptions{LabelSelector: labels})
+}
+
 func (k *KubernetesClient) GetPodContainerLogRequest(namespace, podName, containerName string) *rest.Request {
 	return k.clientSet.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
 		Container:  containerName,
@@ -59,6 +97,15 @@ func (k *KubernetesClient) GetPodContainerPreviousLogRequest(namespace, podName,
 	})
 }
 
+func (k *KubernetesClient) GetPodContainerLogTailRequest(namespace, podName, containerName string, previous bool, tailLines int64) *rest.Request {
+	return k.clientSet.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container:  containerName,
+		Timestamps: true,
+		Previous:   previous,
+		TailLines:  &tailLines,
+	})
+}
+
 func (k *KubernetesClient) GetPodRestartCount(namespace, podName, containerName string) (int32, error) {
 	pod, err := k.clientSet.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
 	if err != nil {
@@ -78,6 +125,10 @@ func (k *KubernetesClient) GetAllServicesList(namespace string) (runtime.Object,
 	return k.clientSet.CoreV1().Services(namespace).List(k.Context, metav1.ListOptions{})
 }
 
+func (k *KubernetesClient) GetService(namespace, name string) (*corev1.Service, error) {
+	return k.clientSet.CoreV1().Services(namespace).Get(k.Context, name, metav1.GetOptions{})
+}
+
 func (k *KubernetesClient) GetAllDeploymentsList(namespace string) (runtime.Object, error) {
 	return k.clientSet.AppsV1().Deployments(namespace).List(k.Context, metav1.ListOptions{})
 }
@@ -94,10 +145,32 @@ func (k *KubernetesClient) CreateDaemonSets(namespace string, daemonSet *appsv1.
 	return k.clientSet.AppsV1().DaemonSets(namespace).Create(k.Context, daemonSet, metav1.CreateOptions{})
 }
 
+// DryRunCreateDaemonSets validates a DaemonSet on the server without persisting it
+func (k *KubernetesClient) DryRunCreateDaemonSets(namespace string, daemonSet *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	return k.clientSet.AppsV1().DaemonSets(namespace).Create(k.Context, daemonSet, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+}
+
 func (k *KubernetesClient) DeleteDaemonSets(namespace, name string) error {
 	return k.clientSet.AppsV1().DaemonSets(namespace).Delete(k.Context, name, metav1.DeleteOptions{})
 }
 
+func (k *KubernetesClient) CreateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
+	return k.clientSet.CoreV1().Secrets(namespace).Create(k.Context, secret, metav1.CreateOptions{})
+}
+
+func (k *KubernetesClient) DeleteSecret(namespace, name string) error {
+	return k.clientSet.CoreV1().Secrets(namespace).Delete(k.Context, name, metav1.DeleteOptions{})
+}
+
+func (k *KubernetesClient) GetSecretsListByLabels(namespace, labels string) (*corev1.SecretList, error) {
+	return k.clientSet.CoreV1().Secrets(namespace).List(k.Context, metav1.ListOptions{LabelSelector: labels})
+}
+
+func (k *KubernetesClient) GetSecretsListByType(namespace string, secretType corev1.SecretType) (*corev1.SecretList, error) {
+	selector := fields.OneTermEqualSelector("type", string(secretType)).String()
+	return k.clientSet.CoreV1().Secrets(namespace).List(k.Context, metav1.ListOptions{FieldSelector: selector})
+}
+
 func (k *KubernetesClient) GetDaemonSetBy(namespace, name string) (*appsv1.DaemonSet, error) {
 	return k.clientSet.AppsV1().DaemonSets(namespace).Get(k.Context, name, metav1.GetOptions{})
 }
@@ -126,10 +199,30 @@ func (k *KubernetesClient) GetNodesListByLabels(labels string) (*corev1.NodeList
 	return k.clientSet.CoreV1().Nodes().List(k.Context, metav1.ListOptions{LabelSelector: labels})
 }
 
+func (k *KubernetesClient) GetNodeProxyRequest(name, path string) *rest.Request {
+	return k.clientSet.CoreV1().RESTClient().Get().Resource("nodes").Name(name).SubResource("proxy").Suffix(path)
+}
+
 func (k *KubernetesClient) GetAllEventsList(namespace string) (runtime.Object, error) {
 	return k.clientSet.CoreV1().Events(namespace).List(k.Context, metav1.ListOptions{})
 }
 
+func (k *KubernetesClient) GetAllEventsV1List(namespace string) (*eventsv1.EventList, error) {
+	return k.clientSet.EventsV1().Events(namespace).List(k.Context, metav1.ListOptions{})
+}
+
+func (k *KubernetesClient) GetEventsByInvolvedObject(namespace, kind, name string) (*corev1.EventList, error) {
+	selector := fields.Set{
+		"involvedObject.kind": kind,
+		"involvedObject.name": name,
+	}.AsSelector().String()
+	return k.clientSet.CoreV1().Events(namespace).List(k.Context, metav1.ListOptions{FieldSelector: selector})
+}
+
+func (k *KubernetesClient) GetAllPersistentVolumeClaimsList(namespace string) (*corev1.PersistentVolumeClaimList, error) {
+	return k.clientSet.CoreV1().PersistentVolumeClaims(namespace).List(k.Context, metav1.ListOptions{})
+}
+
 func (k *KubernetesClient) GetAllConfigMaps(namespace string) (runtime.Object, error) {
 	return k.clientSet.CoreV1().ConfigMaps(namespace).List(k.Context, metav1.ListOptions{})
 }
@@ -137,3 +230,36 @@ func (k *KubernetesClient) GetAllConfigMaps(namespace string) (runtime.Object, e
 func (k *KubernetesClient) GetAllVolumeAttachments() (runtime.Object, error) {
 	return k.clientSet.StorageV1().VolumeAttachments().List(k.Context, metav1.ListOptions{})
 }
+
+func (k *KubernetesClient) GetValidatingWebhookConfigurations() (*admissionregistrationv1.ValidatingWebhookConfigurationList, error) {
+	return k.clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(k.Context, metav1.ListOptions{})
+}
+
+func (k *KubernetesClient) GetMutatingWebhookConfigurations() (*admissionregistrationv1.MutatingWebhookConfigurationList, error) {
+	return k.clientSet.AdmissionregistrationV1().MutatingWebhookConfigurations().List(k.Context, metav1.ListOptions{})
+}
+
+func (k *KubernetesClient) GetLease(namespace, name string) (*coordinationv1.Lease, error) {
+	return k.clientSet.CoordinationV1().Leases(namespace).Get(k.Context, name, metav1.GetOptions{})
+}
+
+// GetRaw gets a path of the API server, e.g. /readyz. The body of a failed
+// request is returned along with the error.
+func (k *KubernetesClient) GetRaw(path string, params map[string]string) ([]byte, error) {
+	req := k.clientSet.Discovery().RESTClient().Get().AbsPath(path)
+	for name, value := range params {
+		req = req.Param(name, value)
+	}
+	return req.DoRaw(k.Context)
+}
+
+// StreamRaw streams a path of the API server, e.g. /metrics
+func (k *KubernetesClient) StreamRaw(path string) (io.ReadCloser, error) {
+	return k.clientSet.Discovery().RESTClient().Get().AbsPath(path).Stream(k.Context)
+}
+
+// NewInformerFactory returns informers watching the resources of all
+// namespaces
+func (k *KubernetesClient) NewInformerFactory(resync time.Duration) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactory(k.clientSet, resync)
+}
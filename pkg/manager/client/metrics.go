@@ -4,8 +4,8 @@ import (
 	"context"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
@@ -25,10 +25,18 @@ func NewMetricsClient(ctx context.Context, config *rest.Config) (*MetricsClient,
 	}, nil
 }
 
-func (c *MetricsClient) GetAllNodeMetrics() (runtime.Object, error) {
+// WithContext returns a client sharing the connection but bound to another context
+func (c *MetricsClient) WithContext(ctx context.Context) *MetricsClient {
+	return &MetricsClient{
+		Context:   ctx,
+		clientset: c.clientset,
+	}
+}
+
+func (c *MetricsClient) GetAllNodeMetrics() (*metricsv1beta1.NodeMetricsList, error) {
 	return c.clientset.MetricsV1beta1().NodeMetricses().List(c.Context, metav1.ListOptions{})
 }
 
-func (c *MetricsClient) GetAllPodMetrics(namespace string) (runtime.Object, error) {
+func (c *MetricsClient) GetAllPodMetrics(namespace string) (*metricsv1beta1.PodMetricsList, error) {
 	return c.clientset.MetricsV1beta1().PodMetricses(namespace).List(c.Context, metav1.ListOptions{})
 }
@@ -2,13 +2,12 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
@@ -19,74 +18,58 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/rancher/support-bundle-kit/pkg/manager/collectors"
+	"github.com/rancher/support-bundle-kit/pkg/types"
 	"github.com/rancher/support-bundle-kit/pkg/utils"
 )
 
 type Cluster struct {
+	ctx context.Context
 	sbm *SupportBundleManager
 }
 
 func NewCluster(ctx context.Context, sbm *SupportBundleManager) *Cluster {
 	return &Cluster{
+		ctx: ctx,
 		sbm: sbm,
 	}
 }
 
-func (c *Cluster) GenerateClusterBundle(bundleDir string) (string, error) {
+func (c *Cluster) GenerateClusterBundle(bundleDir string) error {
 	logrus.Debug("Generating cluster bundle...")
-	namespace, err := c.sbm.k8s.GetNamespace(c.sbm.PodNamespace)
-	if err != nil {
-		return "", errors.Wrap(err, "cannot get deployed namespace")
-	}
-	kubeVersion, err := c.sbm.k8s.GetKubernetesVersion()
-	if err != nil {
-		return "", errors.Wrap(err, "cannot get kubernetes version")
-	}
 
-	bundleMeta := &BundleMeta{
-		BundleName:           c.sbm.BundleName,
-		BundleVersion:        BundleVersion,
-		KubernetesVersion:    kubeVersion.GitVersion,
-		ProjectNamespaceUUID: string(namespace.UID),
-		BundleCreatedAt:      utils.Now(),
-		IssueURL:             c.sbm.IssueURL,
-		IssueDescription:     c.sbm.Description,
-	}
-
-	// Use custom bundle file name from environment variable if set, otherwise use UUID
-	bundleIdentifier := bundleMeta.ProjectNamespaceUUID
-	if c.sbm.CustomBundleFileName != "" {
-		bundleIdentifier = c.sbm.CustomBundleFileName
-	}
-
-	bundleName := fmt.Sprintf("supportbundle_%s_%s.zip",
-		bundleIdentifier,
-		strings.ReplaceAll(bundleMeta.BundleCreatedAt, ":", "-"))
-
-	errLog, err := os.Create(filepath.Join(bundleDir, "bundleGenerationError.log"))
+	errLogFile, err := c.sbm.openErrorLog()
 	if err != nil {
 		logrus.Errorf("Failed to create bundle generation log: %v", err)
-		return "", err
+		return err
 	}
 	defer func() {
-		_ = errLog.Close()
+		_ = errLogFile.Close()
 	}()
-
-	metaFile := filepath.Join(bundleDir, "metadata.yaml")
-	encodeToYAMLFile(bundleMeta, metaFile, errLog)
+	errLog := c.sbm.progress.WarningWriter(errLogFile)
 
 	yamlsDir := filepath.Join(bundleDir, "yamls")
-	var modules []interface{}
 	for _, moduleName := range c.sbm.BundleCollectors {
-		module := collectors.InitModuleCollector(moduleName, yamlsDir, c.sbm.Namespaces, c.sbm.discovery, c.matchesExcludeResources, encodeToYAMLFile, errLog)
-		modules = append(modules, module)
+		if c.sbm.checkpoint.isModuleDone(moduleName) {
+			logrus.Debugf("Resources of collector %s were collected before the manager restarted", moduleName)
+			continue
+		}
+		module, err := collectors.InitModuleCollector(moduleName, yamlsDir, c.sbm.Namespaces, c.sbm.discovery, c.matchesExcludeResources, c.encodeResource, errLog)
+		if err != nil {
+			logrus.WithError(err).Warnf("Skip collector %s", moduleName)
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to init collector %s: %v\n", moduleName, err)
+			continue
+		}
+		collectors.GetAllSupportBundleYAMLs([]collectors.ModuleCollector{module})
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+		c.sbm.checkpoint.completeModule(moduleName)
 	}
-	collectors.GetAllSupportBundleYAMLs(modules)
 
 	logsDir := filepath.Join(bundleDir, "logs")
 	c.generateSupportBundleLogs(logsDir, errLog)
 
-	return bundleName, nil
+	return nil
 }
 
 // matchesExcludeResources returns true if given resource group version mathces our ExcludeResources list.
@@ -99,6 +82,12 @@ func (c *Cluster) matchesExcludeResources(gv schema.GroupVersion, resource metav
 	return false
 }
 
+// encodeResource writes collected resources and counts them
+func (c *Cluster) encodeResource(obj interface{}, path string, errLog io.Writer) {
+	encodeToYAMLFile(obj, path, errLog)
+	c.sbm.progress.AddStep(types.ManagerStepResources, 1)
+}
+
 func encodeToYAMLFile(obj interface{}, path string, errLog io.Writer) {
 	var err error
 	defer func() {
@@ -141,13 +130,27 @@ func encodeToYAMLFile(obj interface{}, path string, errLog io.Writer) {
 	}
 }
 
+func writeJSONFile(path string, obj interface{}, errLog io.Writer) {
+	b, err := json.MarshalIndent(obj, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(path, b, 0644)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to generate %v: %v\n", path, err)
+	}
+}
+
 type GetRuntimeObjectListFunc func() (runtime.Object, error)
 
 func (c *Cluster) generateSupportBundleLogs(logsDir string, errLog io.Writer) {
-	namespaces := []string{"default", "kube-system", "cattle-system"}
-	namespaces = append(namespaces, c.sbm.Namespaces...)
-
-	for _, ns := range namespaces {
+	for _, ns := range utils.BundleNamespaces(c.sbm.Namespaces) {
+		if c.sbm.checkpoint.isLogNamespaceDone(ns) {
+			logrus.Debugf("Logs of namespace %s were collected before the manager restarted", ns)
+			continue
+		}
 		list, err := c.sbm.k8s.GetAllPodsList(ns)
 		if err != nil {
 			_, _ = fmt.Fprintf(errLog, "Support bundle: cannot get pod list: %v\n", err)
@@ -163,7 +166,8 @@ func (c *Cluster) generateSupportBundleLogs(logsDir string, errLog io.Writer) {
 			podDir := filepath.Join(logsDir, ns, podName)
 			for _, container := range pod.Spec.Containers {
 				req := c.sbm.k8s.GetPodContainerLogRequest(ns, podName, container.Name)
-				getLogToFile(podDir, podName, container.Name, req, c.sbm.context, errLog, false)
+				getLogToFile(podDir, podName, container.Name, req, c.ctx, errLog, false)
+				c.sbm.progress.AddStep(types.ManagerStepLogs, 1)
 				restartCount, err := c.sbm.k8s.GetPodRestartCount(ns, podName, container.Name)
 				if err != nil {
 					_, _ = fmt.Fprintf(errLog, "Cannot get pod `%s` info (error: %v), just continue", podName, err)
@@ -171,10 +175,14 @@ func (c *Cluster) generateSupportBundleLogs(logsDir string, errLog io.Writer) {
 				}
 				if restartCount > 0 {
 					req := c.sbm.k8s.GetPodContainerPreviousLogRequest(ns, podName, container.Name)
-					getLogToFile(podDir, podName, container.Name, req, c.sbm.context, errLog, true)
+					getLogToFile(podDir, podName, container.Name, req, c.ctx, errLog, true)
 				}
 			}
 		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.sbm.checkpoint.completeLogNamespace(ns)
 	}
 }
 
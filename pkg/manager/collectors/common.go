@@ -1,8 +1,10 @@
 package collectors
 
 import (
+	"fmt"
 	"io"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/Jeffail/gabs/v2"
@@ -13,29 +15,50 @@ import (
 
 type encodeToYAMLFile func(obj interface{}, path string, errLog io.Writer)
 
-type moduleCollector interface {
+type ModuleCollector interface {
 	generateYAMLs()
-	toObj(b []byte, groupVersion, kind string, resources ...string) (interface{}, error)
 }
 
-func InitModuleCollector(moduleName string, yamlDir string, nameSpaces []string, discovery *client.DiscoveryClient, exclude client.ExcludeFilter, encodeFunc encodeToYAMLFile, errLog io.Writer) interface{} {
+// InitModuleCollector returns the built-in cluster and default modules, or
+// the module of a spec, either built-in or the path of a spec file
+func InitModuleCollector(moduleName string, yamlDir string, nameSpaces []string, discovery *client.DiscoveryClient, exclude client.ExcludeFilter, encodeFunc encodeToYAMLFile, errLog io.Writer) (ModuleCollector, error) {
 	common := NewCommonModule(discovery, encodeFunc, exclude, yamlDir, errLog)
 	switch strings.ToLower(moduleName) {
 	case "cluster":
-		return NewClusterModule(common, "Cluster")
+		return NewClusterModule(common, "Cluster"), nil
 	case "default":
-		return NewDefaultModule(common, "Default", nameSpaces)
-	case "harvester":
-		return NewHarvesterModule(common, "Harvester")
-	default:
+		return NewDefaultModule(common, "Default", nameSpaces), nil
+	}
+	spec, err := LoadSpec(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	return NewSpecModule(common, spec), nil
+}
+
+// CheckModuleCollector tells if InitModuleCollector knows the module
+func CheckModuleCollector(moduleName string) error {
+	switch strings.ToLower(moduleName) {
+	case "cluster", "default":
+		return nil
+	}
+	_, err := LoadSpec(moduleName)
+	return err
+}
+
+// CheckBuiltinCollector tells if the module is built in. Unlike
+// CheckModuleCollector, it never reads a spec file.
+func CheckBuiltinCollector(moduleName string) error {
+	name := strings.ToLower(moduleName)
+	if name == "cluster" || name == "default" || slices.Contains(BuiltinSpecs(), name) {
 		return nil
 	}
+	return fmt.Errorf("unknown collector %q, expected cluster, default or %s", moduleName, strings.Join(BuiltinSpecs(), ", "))
 }
 
-func GetAllSupportBundleYAMLs(modules []interface{}) {
+func GetAllSupportBundleYAMLs(modules []ModuleCollector) {
 	logrus.Infof("Prepare to get all support bundle yamls!")
-	for _, raw_module := range modules {
-		module := raw_module.(moduleCollector)
+	for _, module := range modules {
 		module.generateYAMLs()
 	}
 }
@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
 )
 
 type defaultModule struct {
@@ -26,19 +28,9 @@ func (module defaultModule) generateYAMLs() {
 	logrus.Infof("[%s] generate YAMLs, yamlsDir: %s", module.name, module.c.yamlsDir)
 
 	// Namespaced scope: all resources
-	namespaces := []string{"default", "kube-system", "cattle-system"}
-	namespaces = append(namespaces, module.nameSpaces...)
-
-	done := make(map[string]struct{})
-	for _, namespace := range namespaces {
-		if _, ok := done[namespace]; ok {
-			continue
-		}
-
+	for _, namespace := range utils.BundleNamespaces(module.nameSpaces) {
 		namespacedDir := filepath.Join(module.c.yamlsDir, "namespaced", namespace)
 		module.generateDiscoveredNamespacedYAMLs(namespace, namespacedDir, module.c.errorLog)
-
-		done[namespace] = struct{}{}
 	}
 
 }
@@ -0,0 +1,237 @@
+package collectors
+
+import (
+	"embed"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	defaultNamespacedOutput = "namespaced/{namespace}/{groupVersion}/{resource}.yaml"
+	defaultClusterOutput    = "cluster/{groupVersion}/{resource}.yaml"
+)
+
+//go:embed specs/*.yaml
+var builtinSpecs embed.FS
+
+// CollectorSpec declares the resources collected by a module
+type CollectorSpec struct {
+	Name      string         `yaml:"name"`
+	Resources []ResourceSpec `yaml:"resources"`
+}
+
+type ResourceSpec struct {
+	Group string `yaml:"group,omitempty"`
+	// Version defaults to the preferred version of the group
+	Version  string `yaml:"version,omitempty"`
+	Resource string `yaml:"resource"`
+	// Namespaces of a namespaced resource, all of them when empty
+	Namespaces    []string     `yaml:"namespaces,omitempty"`
+	LabelSelector string       `yaml:"labelSelector,omitempty"`
+	FieldSelector string       `yaml:"fieldSelector,omitempty"`
+	Filters       []ItemFilter `yaml:"filters,omitempty"`
+	// Output is the file written under yamls/, where {namespace},
+	// {groupVersion} and {resource} are replaced. It follows the layout of
+	// the default module by default.
+	Output string `yaml:"output,omitempty"`
+}
+
+// ItemFilter keeps the items whose field is one of Include, and drops those
+// whose field is one of Exclude
+type ItemFilter struct {
+	// Field is the dotted path of a field of the items, e.g. metadata.name
+	Field   string   `yaml:"field"`
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// BuiltinSpecs returns the names of the specs shipped with the manager
+func BuiltinSpecs() []string {
+	entries, _ := builtinSpecs.ReadDir("specs")
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	return names
+}
+
+// isSpecFile tells if a module name is the path of a spec file rather than a
+// built-in module
+func isSpecFile(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.ContainsAny(name, `/\`) || ext == ".yaml" || ext == ".yml"
+}
+
+// LoadSpec returns the built-in spec of the name, or the spec of the file
+// when the name is a path
+func LoadSpec(name string) (*CollectorSpec, error) {
+	var b []byte
+	var err error
+	if isSpecFile(name) {
+		b, err = os.ReadFile(name)
+	} else {
+		b, err = builtinSpecs.ReadFile(path.Join("specs", strings.ToLower(name)+".yaml"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unknown collector %q, expected cluster, default, %s or a spec file", name, strings.Join(BuiltinSpecs(), ", "))
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read collector %s", name)
+	}
+	spec, err := ParseSpec(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid collector %s", name)
+	}
+	return spec, nil
+}
+
+func ParseSpec(b []byte) (*CollectorSpec, error) {
+	spec := &CollectorSpec{}
+	if err := yaml.UnmarshalStrict(b, spec); err != nil {
+		return nil, err
+	}
+	if spec.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	for _, r := range spec.Resources {
+		if r.Resource == "" {
+			return nil, fmt.Errorf("missing resource")
+		}
+		for _, filter := range r.Filters {
+			if filter.Field == "" {
+				return nil, fmt.Errorf("missing field of a filter of %s", r.Resource)
+			}
+			if len(filter.Include) == 0 && len(filter.Exclude) == 0 {
+				return nil, fmt.Errorf("filter of %s.%s has neither include nor exclude", r.Resource, filter.Field)
+			}
+		}
+		if strings.Contains(r.Output, "..") || filepath.IsAbs(r.Output) {
+			return nil, fmt.Errorf("invalid output %q of %s", r.Output, r.Resource)
+		}
+	}
+	return spec, nil
+}
+
+func (r ResourceSpec) getOutput(namespace string, gv schema.GroupVersion, resource *metav1.APIResource) string {
+	output := r.Output
+	if output == "" {
+		output = defaultClusterOutput
+		if resource.Namespaced {
+			output = defaultNamespacedOutput
+		}
+	}
+	return strings.NewReplacer("{namespace}", namespace, "{groupVersion}", gv.String(), "{resource}", resource.Name).Replace(output)
+}
+
+// matches tells if the item passes the filters
+func (r ResourceSpec) matches(item *gabs.Container) bool {
+	for _, filter := range r.Filters {
+		value := ""
+		if data := item.Path(filter.Field).Data(); data != nil {
+			value = fmt.Sprint(data)
+		}
+		if len(filter.Include) > 0 && !slices.Contains(filter.Include, value) {
+			return false
+		}
+		if slices.Contains(filter.Exclude, value) {
+			return false
+		}
+	}
+	return true
+}
+
+type specModule struct {
+	c    *common
+	spec *CollectorSpec
+}
+
+func NewSpecModule(common *common, spec *CollectorSpec) *specModule {
+	return &specModule{
+		c:    common,
+		spec: spec,
+	}
+}
+
+func (module specModule) generateYAMLs() {
+	logrus.Infof("[%s] generate YAMLs, yamlsDir: %s", module.spec.Name, module.c.yamlsDir)
+
+	for _, r := range module.spec.Resources {
+		gvr := schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+		gv, resource, err := module.c.discovery.LookupResource(gvr)
+		if err != nil {
+			logrus.WithError(err).Errorf("[%s] Unable to find resource %s", module.spec.Name, gvr)
+			_, _ = fmt.Fprintf(module.c.errorLog, "Unable to find resource %s: %v\n", gvr, err)
+			continue
+		}
+		if resource == nil {
+			logrus.Debugf("[%s] Resource %s isn't served, skip", module.spec.Name, gvr)
+			continue
+		}
+
+		namespaces := r.Namespaces
+		if !resource.Namespaced || len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+		for _, namespace := range namespaces {
+			b, err := module.c.discovery.ListResource(gv, resource, namespace, metav1.ListOptions{LabelSelector: r.LabelSelector, FieldSelector: r.FieldSelector})
+			if err != nil {
+				logrus.Tracef("Failed to get %s in namespace %q: %v", gvr, namespace, err)
+				_, _ = fmt.Fprintf(module.c.errorLog, "Failed to get %s/%s in namespace %q: %v\n", gv, resource.Name, namespace, err)
+				continue
+			}
+			objs, err := module.toObjs(b, gv, resource, r)
+			if err != nil {
+				logrus.Errorf("Failed to parse objects of %s/%s: %v", gv, resource.Name, err)
+				_, _ = fmt.Fprintf(module.c.errorLog, "Failed to parse objects of %s/%s: %v\n", gv, resource.Name, err)
+				continue
+			}
+			for _, ns := range slices.Sorted(maps.Keys(objs)) {
+				file := filepath.Join(module.c.yamlsDir, r.getOutput(ns, gv, resource))
+				logrus.Debugf("Prepare to encode to yaml file path: %s", file)
+				module.c.encodeFunc(objs[ns], file, module.c.errorLog)
+			}
+		}
+	}
+}
+
+// toObjs filters the items of a list and splits them by namespace, lists
+// left empty are skipped
+func (module specModule) toObjs(b []byte, gv schema.GroupVersion, resource *metav1.APIResource, r ResourceSpec) (map[string]interface{}, error) {
+	jsonParsed, err := module.c.toObjCommon(b, gv.String(), resource.Kind)
+	if err != nil || jsonParsed == nil {
+		return nil, err
+	}
+
+	items := map[string][]interface{}{}
+	for _, item := range jsonParsed.S("items").Children() {
+		if !r.matches(item) {
+			continue
+		}
+		namespace := ""
+		if resource.Namespaced {
+			namespace, _ = item.Path("metadata.namespace").Data().(string)
+		}
+		items[namespace] = append(items[namespace], item.Data())
+	}
+
+	objs := map[string]interface{}{}
+	list, _ := jsonParsed.Data().(map[string]interface{})
+	for namespace, nsItems := range items {
+		obj := maps.Clone(list)
+		obj["items"] = nsItems
+		objs[namespace] = obj
+	}
+	return objs, nil
+}
@@ -0,0 +1,98 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestLoadSpec(t *testing.T) {
+	assert.Equal(t, []string{"harvester", "kubevirt", "longhorn", "rancher"}, BuiltinSpecs())
+	for _, name := range BuiltinSpecs() {
+		spec, err := LoadSpec(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, name, spec.Name)
+		assert.NotEmpty(t, spec.Resources)
+	}
+
+	spec, err := LoadSpec("Harvester")
+	assert.Nil(t, err)
+	assert.Equal(t, "harvester", spec.Name)
+
+	_, err = LoadSpec("unknown")
+	assert.ErrorContains(t, err, `unknown collector "unknown"`)
+	assert.Nil(t, CheckModuleCollector("cluster"))
+	assert.NotNil(t, CheckModuleCollector("unknown"))
+	assert.Nil(t, CheckBuiltinCollector("Longhorn"))
+	assert.Nil(t, CheckBuiltinCollector("default"))
+
+	path := filepath.Join(t.TempDir(), "vendor.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+name: vendor
+resources:
+- group: vendor.example.com
+  resource: widgets
+  labelSelector: app=widget
+  output: vendor/{namespace}/widgets.yaml
+`), 0644))
+	spec, err = LoadSpec(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "app=widget", spec.Resources[0].LabelSelector)
+	assert.Nil(t, CheckModuleCollector(path))
+	assert.ErrorContains(t, CheckBuiltinCollector(path), "unknown collector")
+
+	for content, message := range map[string]string{
+		"resources: []":                                                 "missing name",
+		"name: a\nresources: [{group: a}]":                              "missing resource",
+		"name: a\nunknown: true":                                        "unknown",
+		"name: a\nresources: [{resource: a, output: ../a.yaml}]":        "invalid output",
+		"name: a\nresources: [{resource: a, filters: [{field: type}]}]": "neither include nor exclude",
+	} {
+		_, err := ParseSpec([]byte(content))
+		assert.ErrorContains(t, err, message, content)
+	}
+}
+
+func TestSpecModuleToObjs(t *testing.T) {
+	spec, err := LoadSpec("harvester")
+	assert.Nil(t, err)
+	module := NewSpecModule(NewCommonModule(nil, nil, nil, "", nil), spec)
+
+	secrets := []byte(`{"kind": "SecretList", "apiVersion": "v1", "metadata": {}, "items": [
+		{"metadata": {"name": "plan", "namespace": "fleet-local"}, "type": "rke.cattle.io/machine-plan", "data": {"applied-checksum": "YQ==", "token": "c2VjcmV0"}},
+		{"metadata": {"name": "token", "namespace": "fleet-local"}, "type": "kubernetes.io/service-account-token", "data": {"token": "c2VjcmV0"}}
+	]}`)
+	gv := schema.GroupVersion{Version: "v1"}
+	resource := &metav1.APIResource{Name: "secrets", Kind: "Secret", Namespaced: true}
+	objs, err := module.toObjs(secrets, gv, resource, spec.Resources[0])
+	assert.Nil(t, err)
+	assert.Len(t, objs, 1)
+	items := objs["fleet-local"].(map[string]interface{})["items"].([]interface{})
+	assert.Len(t, items, 1)
+	item := items[0].(map[string]interface{})
+	assert.Equal(t, "plan", item["metadata"].(map[string]interface{})["name"])
+	assert.Equal(t, map[string]interface{}{"applied-checksum": "YQ=="}, item["data"])
+	assert.Equal(t, "namespaced/fleet-local/v1/secrets.yaml", spec.Resources[0].getOutput("fleet-local", gv, resource))
+
+	settings := []byte(`{"kind": "SettingList", "apiVersion": "harvesterhci.io/v1beta1", "metadata": {}, "items": [
+		{"metadata": {"name": "ssl-certificates"}, "value": "secret"},
+		{"metadata": {"name": "log-level"}, "value": "info"}
+	]}`)
+	gv = schema.GroupVersion{Group: "harvesterhci.io", Version: "v1beta1"}
+	resource = &metav1.APIResource{Name: "settings", Kind: "Setting"}
+	objs, err = module.toObjs(settings, gv, resource, spec.Resources[1])
+	assert.Nil(t, err)
+	items = objs[""].(map[string]interface{})["items"].([]interface{})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "log-level", items[0].(map[string]interface{})["metadata"].(map[string]interface{})["name"])
+	assert.Equal(t, "cluster/harvesterhci.io/v1beta1/settings.yaml", spec.Resources[1].getOutput("", gv, resource))
+
+	// nothing left after filtering, no file is written
+	objs, err = module.toObjs([]byte(`{"items": [{"metadata": {"name": "additional-ca"}}]}`), gv, resource, spec.Resources[1])
+	assert.Nil(t, err)
+	assert.Empty(t, objs)
+}
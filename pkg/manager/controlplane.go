@@ -0,0 +1,210 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+)
+
+const controlPlaneDir = "controlplane"
+
+// controlPlaneMetricPrefixes select the API server metrics of request
+// latency, API priority and fairness, and etcd
+var controlPlaneMetricPrefixes = []string{
+	"apiserver_request_duration_seconds",
+	"apiserver_request_sli_duration_seconds",
+	"apiserver_request_total",
+	"apiserver_current_inflight_requests",
+	"apiserver_flowcontrol_",
+	"apiserver_storage_",
+	"etcd_",
+}
+
+// controlPlaneLeases are the leader election Leases in kube-system
+var controlPlaneLeases = []string{"kube-controller-manager", "kube-scheduler"}
+
+// APIServiceStatus is an item of controlplane/apiservices.json
+type APIServiceStatus struct {
+	Name               string `json:"name"`
+	Service            string `json:"service,omitempty"`
+	Available          string `json:"available"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+func (m *SupportBundleManager) phaseCollectControlPlaneBundle(ctx context.Context) error {
+	k8s := m.k8s.WithContext(ctx)
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	dir := filepath.Join(m.getWorkingDir(), controlPlaneDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// the API server may not answer at all in an outage, every item is
+	// collected on its own
+	if version, err := k8s.GetKubernetesVersion(); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get kubernetes version: %v\n", err)
+	} else {
+		writeJSONFile(filepath.Join(dir, "version.json"), version, errLog)
+	}
+
+	for _, check := range []string{"healthz", "livez", "readyz"} {
+		body, err := k8s.GetRaw("/"+check, map[string]string{"verbose": ""})
+		if err != nil {
+			// an unhealthy check fails with the verbose output
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get /%s: %v\n", check, err)
+		}
+		if len(body) > 0 {
+			path := filepath.Join(dir, check+".txt")
+			if err := os.WriteFile(path, body, 0644); err != nil {
+				_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to generate %v: %v\n", path, err)
+			}
+		}
+	}
+
+	if err := collectControlPlaneMetrics(k8s, filepath.Join(dir, "metrics.txt")); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get API server metrics: %v\n", err)
+	}
+
+	if apiServices, err := getAPIServices(k8s); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get API services: %v\n", err)
+	} else {
+		writeJSONFile(filepath.Join(dir, "apiservices.json"), newAPIServiceStatuses(apiServices), errLog)
+	}
+
+	for _, name := range controlPlaneLeases {
+		lease, err := k8s.GetLease("kube-system", name)
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("Lease kube-system/%s not found", name)
+			continue
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get lease %s: %v\n", name, err)
+			continue
+		}
+		lease.APIVersion = "coordination.k8s.io/v1"
+		lease.Kind = "Lease"
+		encodeToYAMLFile(lease, filepath.Join(dir, "leases", name+".yaml"), errLog)
+	}
+	return nil
+}
+
+func collectControlPlaneMetrics(k8s *client.KubernetesClient, path string) error {
+	stream, err := k8s.StreamRaw("/metrics")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return filterMetrics(stream, f, controlPlaneMetricPrefixes)
+}
+
+// filterMetrics copies the metrics of the Prometheus text format whose name
+// starts with one of the prefixes, with their HELP and TYPE comments
+func filterMetrics(r io.Reader, w io.Writer, prefixes []string) error {
+	matches := func(name string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	writer := bufio.NewWriter(w)
+	for scanner.Scan() {
+		line := scanner.Text()
+		name := line
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "#" {
+			name = fields[2]
+		}
+		if !matches(name) {
+			continue
+		}
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func getAPIServices(k8s *client.KubernetesClient) (*apiregistrationv1.APIServiceList, error) {
+	body, err := k8s.GetRaw("/apis/apiregistration.k8s.io/v1/apiservices", nil)
+	if err != nil {
+		return nil, err
+	}
+	list := &apiregistrationv1.APIServiceList{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// newAPIServiceStatuses lists the unavailable API services first
+func newAPIServiceStatuses(list *apiregistrationv1.APIServiceList) []APIServiceStatus {
+	var statuses []APIServiceStatus
+	for _, apiService := range list.Items {
+		status := APIServiceStatus{
+			Name:      apiService.Name,
+			Available: string(apiregistrationv1.ConditionUnknown),
+		}
+		if service := apiService.Spec.Service; service != nil {
+			status.Service = service.Namespace + "/" + service.Name
+		}
+		for _, condition := range apiService.Status.Conditions {
+			if condition.Type != apiregistrationv1.Available {
+				continue
+			}
+			status.Available = string(condition.Status)
+			status.Reason = condition.Reason
+			status.Message = condition.Message
+			if !condition.LastTransitionTime.IsZero() {
+				status.LastTransitionTime = condition.LastTransitionTime.UTC().Format(time.RFC3339)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		iAvailable := statuses[i].Available == string(apiregistrationv1.ConditionTrue)
+		jAvailable := statuses[j].Available == string(apiregistrationv1.ConditionTrue)
+		if iAvailable != jAvailable {
+			return !iAvailable
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	metrics := `# HELP apiserver_request_total Counter of apiserver requests
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",verb="GET"} 42
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 300
+# HELP etcd_request_duration_seconds Etcd request latency in seconds
+# TYPE etcd_request_duration_seconds histogram
+etcd_request_duration_seconds_bucket{operation="get",le="0.005"} 10
+apiserver_flowcontrol_current_inqueue_requests{priority_level="workload-low"} 3
+`
+	out := &bytes.Buffer{}
+	assert.Nil(t, filterMetrics(strings.NewReader(metrics), out, controlPlaneMetricPrefixes))
+	assert.Equal(t, `# HELP apiserver_request_total Counter of apiserver requests
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",verb="GET"} 42
+# HELP etcd_request_duration_seconds Etcd request latency in seconds
+# TYPE etcd_request_duration_seconds histogram
+etcd_request_duration_seconds_bucket{operation="get",le="0.005"} 10
+apiserver_flowcontrol_current_inqueue_requests{priority_level="workload-low"} 3
+`, out.String())
+}
+
+func TestNewAPIServiceStatuses(t *testing.T) {
+	list := &apiregistrationv1.APIServiceList{Items: []apiregistrationv1.APIService{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "v1.apps"},
+			Status: apiregistrationv1.APIServiceStatus{Conditions: []apiregistrationv1.APIServiceCondition{
+				{Type: apiregistrationv1.Available, Status: apiregistrationv1.ConditionTrue, Reason: "Local"},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "v1beta1.metrics.k8s.io"},
+			Spec: apiregistrationv1.APIServiceSpec{
+				Service: &apiregistrationv1.ServiceReference{Namespace: "kube-system", Name: "metrics-server"},
+			},
+			Status: apiregistrationv1.APIServiceStatus{Conditions: []apiregistrationv1.APIServiceCondition{
+				{Type: apiregistrationv1.Available, Status: apiregistrationv1.ConditionFalse, Reason: "FailedDiscoveryCheck", Message: "no response"},
+			}},
+		},
+	}}
+
+	statuses := newAPIServiceStatuses(list)
+	assert.Equal(t, []APIServiceStatus{
+		{
+			Name:      "v1beta1.metrics.k8s.io",
+			Service:   "kube-system/metrics-server",
+			Available: "False",
+			Reason:    "FailedDiscoveryCheck",
+			Message:   "no response",
+		},
+		{
+			Name:      "v1.apps",
+			Available: "True",
+			Reason:    "Local",
+		},
+	}, statuses)
+}
@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/kubectl/pkg/describe"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	describeDir = "describe"
+	// directory of the cluster scoped objects, a namespace can't be named so
+	describeClusterDir = "_cluster"
+	describeChunkSize  = 500
+	// describers don't take a context, each of their requests is bounded
+	// instead
+	describeRequestTimeout = 30 * time.Second
+)
+
+// describeTarget is an object to describe like kubectl describe
+type describeTarget struct {
+	kind      schema.GroupKind
+	namespace string
+	name      string
+}
+
+func (t describeTarget) path(bundleDir string) string {
+	namespace := t.namespace
+	if namespace == "" {
+		namespace = describeClusterDir
+	}
+	return filepath.Join(bundleDir, describeDir, namespace, strings.ToLower(t.kind.Kind), t.name+".txt")
+}
+
+func (m *SupportBundleManager) phaseDescribeBundle(ctx context.Context) error {
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	targets := m.getDescribeTargets(m.k8s.WithContext(ctx), errLog)
+	logrus.Infof("Describing %d objects", len(targets))
+
+	restConfig := rest.CopyConfig(m.restConfig)
+	restConfig.Timeout = describeRequestTimeout
+	describers := map[schema.GroupKind]describe.ResourceDescriber{}
+	settings := describe.DescriberSettings{ShowEvents: true, ChunkSize: describeChunkSize}
+	for _, target := range targets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		describer, ok := describers[target.kind]
+		if !ok {
+			describer, ok = describe.DescriberFor(target.kind, restConfig)
+			if !ok {
+				return fmt.Errorf("no describer for %s", target.kind)
+			}
+			describers[target.kind] = describer
+		}
+		output, err := describer.Describe(target.namespace, target.name, settings)
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to describe %s %s/%s: %v\n", target.kind.Kind, target.namespace, target.name, err)
+			continue
+		}
+		path := target.path(m.getWorkingDir())
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to generate %v: %v\n", path, err)
+		}
+	}
+	return nil
+}
+
+// getDescribeTargets returns all nodes, and the pods, PVCs and workloads of
+// the namespaces that may need attention. Failures to list are logged.
+func (m *SupportBundleManager) getDescribeTargets(k8s *client.KubernetesClient, errLog io.Writer) []describeTarget {
+	var targets []describeTarget
+	logError := func(what string, err error) {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list %s to describe: %v\n", what, err)
+	}
+
+	if list, err := k8s.GetAllNodesList(); err != nil {
+		logError("nodes", err)
+	} else if nodes, ok := list.(*corev1.NodeList); ok {
+		for _, node := range nodes.Items {
+			targets = append(targets, describeTarget{kind: corev1.SchemeGroupVersion.WithKind("Node").GroupKind(), name: node.Name})
+		}
+	}
+
+	for _, ns := range utils.BundleNamespaces(m.Namespaces) {
+		if list, err := k8s.GetAllPodsList(ns); err != nil {
+			logError("pods", err)
+		} else if pods, ok := list.(*corev1.PodList); ok {
+			for i := range pods.Items {
+				if isPodUnhealthy(&pods.Items[i]) {
+					targets = append(targets, newNamespacedDescribeTarget(corev1.SchemeGroupVersion.WithKind("Pod"), ns, pods.Items[i].Name))
+				}
+			}
+		}
+
+		if pvcs, err := k8s.GetAllPersistentVolumeClaimsList(ns); err != nil {
+			logError("persistent volume claims", err)
+		} else {
+			for _, pvc := range pvcs.Items {
+				targets = append(targets, newNamespacedDescribeTarget(corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"), ns, pvc.Name))
+			}
+		}
+
+		if list, err := k8s.GetAllDeploymentsList(ns); err != nil {
+			logError("deployments", err)
+		} else if deployments, ok := list.(*appsv1.DeploymentList); ok {
+			for i := range deployments.Items {
+				if isDeploymentUnavailable(&deployments.Items[i]) {
+					targets = append(targets, newNamespacedDescribeTarget(appsv1.SchemeGroupVersion.WithKind("Deployment"), ns, deployments.Items[i].Name))
+				}
+			}
+		}
+
+		if list, err := k8s.GetAllStatefulSetsList(ns); err != nil {
+			logError("statefulsets", err)
+		} else if statefulSets, ok := list.(*appsv1.StatefulSetList); ok {
+			for i := range statefulSets.Items {
+				if isStatefulSetUnavailable(&statefulSets.Items[i]) {
+					targets = append(targets, newNamespacedDescribeTarget(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), ns, statefulSets.Items[i].Name))
+				}
+			}
+		}
+
+		if list, err := k8s.GetAllDaemonSetsList(ns); err != nil {
+			logError("daemonsets", err)
+		} else if daemonSets, ok := list.(*appsv1.DaemonSetList); ok {
+			for i := range daemonSets.Items {
+				if isDaemonSetUnavailable(&daemonSets.Items[i]) {
+					targets = append(targets, newNamespacedDescribeTarget(appsv1.SchemeGroupVersion.WithKind("DaemonSet"), ns, daemonSets.Items[i].Name))
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func newNamespacedDescribeTarget(gvk schema.GroupVersionKind, namespace, name string) describeTarget {
+	return describeTarget{kind: gvk.GroupKind(), namespace: namespace, name: name}
+}
+
+// isPodUnhealthy tells if a pod isn't running or ready. Completed pods are
+// left out.
+func isPodUnhealthy(pod *corev1.Pod) bool {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return false
+	case corev1.PodRunning:
+	default:
+		return true
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status != corev1.ConditionTrue
+		}
+	}
+	return true
+}
+
+func isDeploymentUnavailable(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UnavailableReplicas > 0 || deployment.Status.AvailableReplicas < replicas
+}
+
+func isStatefulSetUnavailable(statefulSet *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return statefulSet.Status.AvailableReplicas < replicas
+}
+
+func isDaemonSetUnavailable(daemonSet *appsv1.DaemonSet) bool {
+	return daemonSet.Status.NumberUnavailable > 0 || daemonSet.Status.NumberAvailable < daemonSet.Status.DesiredNumberScheduled
+}
@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+func TestIsPodUnhealthy(t *testing.T) {
+	ready := func(status corev1.ConditionStatus) []corev1.PodCondition {
+		return []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	}
+	for _, tc := range []struct {
+		name      string
+		status    corev1.PodStatus
+		unhealthy bool
+	}{
+		{"ready", corev1.PodStatus{Phase: corev1.PodRunning, Conditions: ready(corev1.ConditionTrue)}, false},
+		{"not ready", corev1.PodStatus{Phase: corev1.PodRunning, Conditions: ready(corev1.ConditionFalse)}, true},
+		{"pending", corev1.PodStatus{Phase: corev1.PodPending}, true},
+		{"failed", corev1.PodStatus{Phase: corev1.PodFailed}, true},
+		{"completed", corev1.PodStatus{Phase: corev1.PodSucceeded}, false},
+	} {
+		assert.Equal(t, tc.unhealthy, isPodUnhealthy(&corev1.Pod{Status: tc.status}), tc.name)
+	}
+}
+
+func TestIsWorkloadUnavailable(t *testing.T) {
+	assert.False(t, isDeploymentUnavailable(&appsv1.Deployment{
+		Spec:   appsv1.DeploymentSpec{Replicas: ptr.To[int32](2)},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 2},
+	}))
+	assert.True(t, isDeploymentUnavailable(&appsv1.Deployment{
+		Spec:   appsv1.DeploymentSpec{Replicas: ptr.To[int32](2)},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 1, UnavailableReplicas: 1},
+	}))
+	assert.False(t, isDeploymentUnavailable(&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](0)}}))
+
+	assert.True(t, isStatefulSetUnavailable(&appsv1.StatefulSet{
+		Spec:   appsv1.StatefulSetSpec{Replicas: ptr.To[int32](3)},
+		Status: appsv1.StatefulSetStatus{AvailableReplicas: 2},
+	}))
+
+	assert.False(t, isDaemonSetUnavailable(&appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, NumberAvailable: 3},
+	}))
+	assert.True(t, isDaemonSetUnavailable(&appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, NumberAvailable: 2, NumberUnavailable: 1},
+	}))
+}
+
+func TestDescribeTargetPath(t *testing.T) {
+	pod := newNamespacedDescribeTarget(corev1.SchemeGroupVersion.WithKind("Pod"), "longhorn-system", "manager")
+	assert.Equal(t, "bundle/describe/longhorn-system/pod/manager.txt", pod.path("bundle"))
+	node := describeTarget{kind: corev1.SchemeGroupVersion.WithKind("Node").GroupKind(), name: "node1"}
+	assert.Equal(t, "bundle/describe/_cluster/node/node1.txt", node.path("bundle"))
+
+	assert.Equal(t, []string{"default", "kube-system", "cattle-system", "longhorn-system"}, utils.BundleNamespaces([]string{"longhorn-system", "kube-system", ""}))
+}
@@ -0,0 +1,252 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+const timelineDir = "timeline"
+
+// TimelineEvent is a line of timeline/events.jsonl. Events of the same
+// series, or repeated with the same object, reason and message, are merged
+// into one with the total count.
+type TimelineEvent struct {
+	FirstTimestamp      time.Time               `json:"firstTimestamp"`
+	LastTimestamp       time.Time               `json:"lastTimestamp"`
+	Count               int32                   `json:"count"`
+	Namespace           string                  `json:"namespace,omitempty"`
+	Type                string                  `json:"type,omitempty"`
+	Reason              string                  `json:"reason,omitempty"`
+	Message             string                  `json:"message,omitempty"`
+	Action              string                  `json:"action,omitempty"`
+	InvolvedObject      corev1.ObjectReference  `json:"involvedObject"`
+	Related             *corev1.ObjectReference `json:"related,omitempty"`
+	ReportingController string                  `json:"reportingController,omitempty"`
+	ReportingInstance   string                  `json:"reportingInstance,omitempty"`
+	// Names of the merged Event objects
+	Names []string `json:"names"`
+
+	uids map[k8stypes.UID]bool
+}
+
+type timelineKey struct {
+	namespace           string
+	object              corev1.ObjectReference
+	eventType           string
+	reason              string
+	message             string
+	reportingController string
+}
+
+func (m *SupportBundleManager) phaseCollectEventsBundle(ctx context.Context) error {
+	k8s := m.k8s.WithContext(ctx)
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	// both APIs serve the same events, listing both still gets them when
+	// one is denied
+	var coreEvents *corev1.EventList
+	var failed int
+	if obj, err := k8s.GetAllEventsList(corev1.NamespaceAll); err != nil {
+		failed++
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list core events: %v\n", err)
+	} else {
+		coreEvents, _ = obj.(*corev1.EventList)
+	}
+	events, err := k8s.GetAllEventsV1List(corev1.NamespaceAll)
+	if err != nil {
+		failed++
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to list events.k8s.io/v1 events: %v\n", err)
+	}
+	if failed == 2 {
+		return errors.Wrap(err, "failed to list events")
+	}
+
+	timeline := newEventTimeline(coreEvents, events)
+	logrus.Infof("Writing a timeline of %d events", len(timeline))
+	return writeEventTimeline(filepath.Join(m.getWorkingDir(), timelineDir, "events.jsonl"), timeline)
+}
+
+// newEventTimeline merges the events of both APIs and sorts them by the
+// time they were last seen
+func newEventTimeline(coreEvents *corev1.EventList, events *eventsv1.EventList) []*TimelineEvent {
+	var all []*TimelineEvent
+	if coreEvents != nil {
+		for i := range coreEvents.Items {
+			all = append(all, newTimelineEventFromCore(&coreEvents.Items[i]))
+		}
+	}
+	if events != nil {
+		for i := range events.Items {
+			all = append(all, newTimelineEventFromV1(&events.Items[i]))
+		}
+	}
+
+	merged := map[timelineKey]*TimelineEvent{}
+	var timeline []*TimelineEvent
+	for _, e := range all {
+		key := timelineKey{
+			namespace:           e.Namespace,
+			object:              e.InvolvedObject,
+			eventType:           e.Type,
+			reason:              e.Reason,
+			message:             e.Message,
+			reportingController: e.ReportingController,
+		}
+		// the resource version and field path change between occurrences
+		key.object.ResourceVersion = ""
+		key.object.FieldPath = ""
+		existing, ok := merged[key]
+		if !ok {
+			merged[key] = e
+			timeline = append(timeline, e)
+			continue
+		}
+		existing.merge(e)
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if !timeline[i].LastTimestamp.Equal(timeline[j].LastTimestamp) {
+			return timeline[i].LastTimestamp.Before(timeline[j].LastTimestamp)
+		}
+		return timeline[i].FirstTimestamp.Before(timeline[j].FirstTimestamp)
+	})
+	for _, e := range timeline {
+		sort.Strings(e.Names)
+	}
+	return timeline
+}
+
+func (e *TimelineEvent) merge(o *TimelineEvent) {
+	for uid := range o.uids {
+		// the same event listed from the other API
+		if e.uids[uid] {
+			return
+		}
+	}
+	for uid := range o.uids {
+		e.uids[uid] = true
+	}
+	e.Names = append(e.Names, o.Names...)
+	e.Count += o.Count
+	if o.FirstTimestamp.Before(e.FirstTimestamp) {
+		e.FirstTimestamp = o.FirstTimestamp
+	}
+	if o.LastTimestamp.After(e.LastTimestamp) {
+		e.LastTimestamp = o.LastTimestamp
+	}
+}
+
+func newTimelineEventFromCore(event *corev1.Event) *TimelineEvent {
+	first := firstTime(event.FirstTimestamp.Time, event.EventTime.Time, event.CreationTimestamp.Time)
+	last := event.LastTimestamp.Time
+	count := event.Count
+	if event.Series != nil {
+		last = latestTime(last, event.Series.LastObservedTime.Time)
+		count = max(count, event.Series.Count)
+	}
+	return &TimelineEvent{
+		FirstTimestamp:      first,
+		LastTimestamp:       firstTime(last, first),
+		Count:               max(count, 1),
+		Namespace:           event.Namespace,
+		Type:                event.Type,
+		Reason:              event.Reason,
+		Message:             event.Message,
+		Action:              event.Action,
+		InvolvedObject:      event.InvolvedObject,
+		Related:             event.Related,
+		ReportingController: firstString(event.ReportingController, event.Source.Component),
+		ReportingInstance:   firstString(event.ReportingInstance, event.Source.Host),
+		Names:               []string{event.Name},
+		uids:                map[k8stypes.UID]bool{event.UID: true},
+	}
+}
+
+func newTimelineEventFromV1(event *eventsv1.Event) *TimelineEvent {
+	first := firstTime(event.DeprecatedFirstTimestamp.Time, event.EventTime.Time, event.CreationTimestamp.Time)
+	last := event.DeprecatedLastTimestamp.Time
+	count := event.DeprecatedCount
+	if event.Series != nil {
+		last = latestTime(last, event.Series.LastObservedTime.Time)
+		count = max(count, event.Series.Count)
+	}
+	return &TimelineEvent{
+		FirstTimestamp:      first,
+		LastTimestamp:       firstTime(last, first),
+		Count:               max(count, 1),
+		Namespace:           event.Namespace,
+		Type:                event.Type,
+		Reason:              event.Reason,
+		Message:             event.Note,
+		Action:              event.Action,
+		InvolvedObject:      event.Regarding,
+		Related:             event.Related,
+		ReportingController: firstString(event.ReportingController, event.DeprecatedSource.Component),
+		ReportingInstance:   firstString(event.ReportingInstance, event.DeprecatedSource.Host),
+		Names:               []string{event.Name},
+		uids:                map[k8stypes.UID]bool{event.UID: true},
+	}
+}
+
+func writeEventTimeline(path string, timeline []*TimelineEvent) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	encoder := json.NewEncoder(f)
+	encoder.SetEscapeHTML(false)
+	for _, e := range timeline {
+		if err := encoder.Encode(e); err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+	}
+	return nil
+}
+
+func firstTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func latestTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func firstString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewEventTimeline(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	pod := corev1.ObjectReference{Kind: "Pod", Namespace: "longhorn-system", Name: "manager", UID: "pod-uid"}
+	node := corev1.ObjectReference{Kind: "Node", Name: "node1", UID: "node1"}
+
+	coreEvents := &corev1.EventList{Items: []corev1.Event{
+		{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "longhorn-system", Name: "manager.1", UID: "e1"},
+			InvolvedObject: pod,
+			Type:           corev1.EventTypeWarning,
+			Reason:         "BackOff",
+			Message:        "Back-off restarting failed container",
+			Source:         corev1.EventSource{Component: "kubelet", Host: "node1"},
+			FirstTimestamp: metav1.NewTime(t0.Add(time.Minute)),
+			LastTimestamp:  metav1.NewTime(t0.Add(5 * time.Minute)),
+			Count:          4,
+		},
+		{
+			// a second object of the same series
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "longhorn-system", Name: "manager.2", UID: "e2"},
+			InvolvedObject: pod,
+			Type:           corev1.EventTypeWarning,
+			Reason:         "BackOff",
+			Message:        "Back-off restarting failed container",
+			Source:         corev1.EventSource{Component: "kubelet", Host: "node1"},
+			FirstTimestamp: metav1.NewTime(t0.Add(10 * time.Minute)),
+			LastTimestamp:  metav1.NewTime(t0.Add(20 * time.Minute)),
+			Count:          2,
+		},
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "node1.1", UID: "e3"},
+			InvolvedObject: node,
+			Type:           corev1.EventTypeNormal,
+			Reason:         "NodeNotReady",
+			FirstTimestamp: metav1.NewTime(t0),
+			LastTimestamp:  metav1.NewTime(t0),
+			Count:          1,
+		},
+	}}
+	events := &eventsv1.EventList{Items: []eventsv1.Event{
+		{
+			// e1 listed from events.k8s.io/v1
+			ObjectMeta:               metav1.ObjectMeta{Namespace: "longhorn-system", Name: "manager.1", UID: "e1"},
+			Regarding:                pod,
+			Type:                     corev1.EventTypeWarning,
+			Reason:                   "BackOff",
+			Note:                     "Back-off restarting failed container",
+			DeprecatedSource:         corev1.EventSource{Component: "kubelet", Host: "node1"},
+			DeprecatedFirstTimestamp: metav1.NewTime(t0.Add(time.Minute)),
+			DeprecatedLastTimestamp:  metav1.NewTime(t0.Add(5 * time.Minute)),
+			DeprecatedCount:          4,
+		},
+		{
+			ObjectMeta:          metav1.ObjectMeta{Namespace: "longhorn-system", Name: "volume.1", UID: "e4"},
+			Regarding:           corev1.ObjectReference{Kind: "Volume", Namespace: "longhorn-system", Name: "pvc-1"},
+			Type:                corev1.EventTypeWarning,
+			Reason:              "Degraded",
+			Note:                "volume is degraded",
+			ReportingController: "longhorn-manager",
+			EventTime:           metav1.NewMicroTime(t0.Add(2 * time.Minute)),
+			Series: &eventsv1.EventSeries{
+				Count:            3,
+				LastObservedTime: metav1.NewMicroTime(t0.Add(8 * time.Minute)),
+			},
+		},
+	}}
+
+	timeline := newEventTimeline(coreEvents, events)
+	assert.Len(t, timeline, 3)
+
+	assert.Equal(t, "NodeNotReady", timeline[0].Reason)
+	assert.Equal(t, int32(1), timeline[0].Count)
+	assert.Equal(t, node, timeline[0].InvolvedObject)
+
+	assert.Equal(t, "Degraded", timeline[1].Reason)
+	assert.Equal(t, int32(3), timeline[1].Count)
+	assert.Equal(t, t0.Add(2*time.Minute), timeline[1].FirstTimestamp)
+	assert.Equal(t, t0.Add(8*time.Minute), timeline[1].LastTimestamp)
+
+	backOff := timeline[2]
+	assert.Equal(t, "BackOff", backOff.Reason)
+	assert.Equal(t, int32(6), backOff.Count)
+	assert.Equal(t, t0.Add(time.Minute), backOff.FirstTimestamp)
+	assert.Equal(t, t0.Add(20*time.Minute), backOff.LastTimestamp)
+	assert.Equal(t, []string{"manager.1", "manager.2"}, backOff.Names)
+	assert.Equal(t, "kubelet", backOff.ReportingController)
+	assert.Equal(t, "node1", backOff.ReportingInstance)
+
+	path := filepath.Join(t.TempDir(), timelineDir, "events.jsonl")
+	assert.Nil(t, writeEventTimeline(path, timeline))
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := TimelineEvent{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.Equal(t, timeline[lines].Reason, e.Reason)
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+}
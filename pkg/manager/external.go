@@ -0,0 +1,376 @@
+package manager
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	DefaultExternalBundleTimeout = 20 * time.Minute
+
+	externalDir = "external"
+	// externalBundleNamePrefix names what is created in other products to
+	// request their bundles
+	externalBundleNamePrefix = "support-bundle-kit-"
+
+	ExternalBundleFormatZip   = "zip"
+	ExternalBundleFormatTarGz = "tar.gz"
+)
+
+var externalBundlePollInterval = 5 * time.Second
+
+// externalBundleCleanupTimeout bounds the cleanup, which runs after the
+// phase is cancelled or timed out too
+const externalBundleCleanupTimeout = 30 * time.Second
+
+// ExternalBundleResult is the outcome of collecting the bundle of another
+// product into external/, recorded in metadata.yaml
+type ExternalBundleResult struct {
+	Name       string `json:"name" yaml:"name"`
+	State      string `json:"state" yaml:"state"`
+	File       string `json:"file,omitempty" yaml:"file,omitempty"`
+	Size       int64  `json:"size,omitempty" yaml:"size,omitempty"`
+	StartedAt  string `json:"startedAt" yaml:"startedAt"`
+	FinishedAt string `json:"finishedAt" yaml:"finishedAt"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ExternalBundleProvider asks another product for its own bundle. The
+// manager triggers it, polls it until it's ready, then downloads and
+// verifies the archive.
+type ExternalBundleProvider interface {
+	Name() string
+	// Detect tells if the product is installed
+	Detect(ctx context.Context) (bool, error)
+	// Trigger requests the bundle. A bundle already requested, e.g. before
+	// the manager restarted, isn't an error.
+	Trigger(ctx context.Context, req *ExternalBundleRequest) error
+	Poll(ctx context.Context, req *ExternalBundleRequest) (*ExternalBundleStatus, error)
+	// Cleanup removes what Trigger created once the bundle is downloaded or
+	// failed
+	Cleanup(ctx context.Context, req *ExternalBundleRequest) error
+}
+
+// ExternalBundleRequest identifies the request of a bundle across the calls
+// to a provider
+type ExternalBundleRequest struct {
+	// ID is the ID of the support bundle, in lower case
+	ID string
+	// Name is a DNS-safe name derived from the ID, a restarted manager
+	// finds its request again with it
+	Name        string
+	Description string
+	IssueURL    string
+}
+
+type ExternalBundleStatus struct {
+	Ready bool
+	// Error is set once the product failed to generate the bundle
+	Error string
+	// Progress is logged while waiting
+	Progress string
+	// Download is set once the bundle is ready
+	Download *ExternalBundleDownload
+}
+
+type ExternalBundleDownload struct {
+	URL    string
+	Header http.Header
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// Format of the archive, it's verified unless empty
+	Format string
+}
+
+func (m *SupportBundleManager) phaseCollectExternalBundles(ctx context.Context) error {
+	// Longhorn runs this manager to build its own bundle, which must not
+	// request another Longhorn bundle
+	if strings.EqualFold(m.SpecifyCollector, "longhorn") {
+		logrus.Info("Collecting the support bundle of Longhorn, skip external bundles")
+		return nil
+	}
+
+	providers, err := m.getExternalBundleProviders()
+	if err != nil {
+		return err
+	}
+
+	timeout := m.ExternalBundleTimeout
+	if timeout <= 0 {
+		timeout = DefaultExternalBundleTimeout
+	}
+	req := m.newExternalBundleRequest()
+	dir := filepath.Join(m.getWorkingDir(), externalDir)
+
+	var failed []string
+	for _, p := range providers {
+		detected, err := p.Detect(ctx)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to detect %s", p.Name())
+			failed = append(failed, p.Name())
+			continue
+		}
+		if !detected {
+			logrus.Infof("%s not detected, skip its support bundle", p.Name())
+			continue
+		}
+
+		result := ExternalBundleResult{Name: p.Name(), StartedAt: utils.Now()}
+		file, size, err := collectExternalBundle(ctx, p, req, timeout, dir)
+		result.FinishedAt = utils.Now()
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to collect the support bundle of %s", p.Name())
+			result.State = types.ManagerPhaseStateFailed
+			result.Error = err.Error()
+			failed = append(failed, p.Name())
+		} else {
+			result.State = types.ManagerPhaseStateSucceeded
+			result.File = filepath.Join(externalDir, file)
+			result.Size = size
+		}
+		m.recordExternalBundle(result)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to collect the support bundles of %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// getExternalBundleProviders returns the built-in providers and those of
+// ExternalBundleProviders, which replace the built-in ones of the same name
+func (m *SupportBundleManager) getExternalBundleProviders() ([]ExternalBundleProvider, error) {
+	providers := []ExternalBundleProvider{
+		&longhornProvider{
+			discovery:  m.discovery,
+			dynamic:    m.dynamic,
+			backendURL: longhornBackendURL,
+		},
+	}
+	if m.ExternalBundleProviders == "" {
+		return providers, nil
+	}
+	configs, err := loadHTTPProviderConfigs(m.ExternalBundleProviders)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range configs {
+		p, err := newHTTPProvider(config, m.discovery, m.k8s)
+		if err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range providers {
+			if providers[i].Name() == p.Name() {
+				providers[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			providers = append(providers, p)
+		}
+	}
+	return providers, nil
+}
+
+// newExternalBundleRequest names the requests after the bundle, a restarted
+// manager finds them again
+func (m *SupportBundleManager) newExternalBundleRequest() *ExternalBundleRequest {
+	id := m.BundleName
+	if m.bundleMeta != nil && m.bundleMeta.BundleID != "" {
+		id = m.bundleMeta.BundleID
+	}
+	id = strings.ToLower(id)
+	return &ExternalBundleRequest{
+		ID:          id,
+		Name:        externalBundleNamePrefix + id,
+		Description: m.getBundleDescription(),
+		IssueURL:    m.IssueURL,
+	}
+}
+
+// recordExternalBundle keeps the latest result of each product in the
+// metadata
+func (m *SupportBundleManager) recordExternalBundle(result ExternalBundleResult) {
+	if m.bundleMeta == nil {
+		return
+	}
+	results := []ExternalBundleResult{}
+	for _, r := range m.bundleMeta.ExternalBundles {
+		if r.Name != result.Name {
+			results = append(results, r)
+		}
+	}
+	m.bundleMeta.ExternalBundles = append(results, result)
+	m.checkpoint.setBundle(m.bundleMeta, m.bundleFileName)
+	m.writeMetadata()
+}
+
+func (m *SupportBundleManager) getBundleDescription() string {
+	if m.Description != "" {
+		return m.Description
+	}
+	return fmt.Sprintf("Requested by support bundle %s", m.BundleName)
+}
+
+// collectExternalBundle returns the name and size of the archive written in
+// dir
+func collectExternalBundle(ctx context.Context, p ExternalBundleProvider, req *ExternalBundleRequest, timeout time.Duration, dir string) (string, int64, error) {
+	if err := p.Trigger(ctx, req); err != nil {
+		return "", 0, errors.Wrapf(err, "failed to request %s support bundle", p.Name())
+	}
+	defer func() {
+		// what was created in the product is leaked unless it's cleaned up
+		// once the phase context is done
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), externalBundleCleanupTimeout)
+		defer cancel()
+		if err := p.Cleanup(cleanupCtx, req); err != nil {
+			logrus.WithError(err).Warnf("Failed to clean up %s support bundle %s", p.Name(), req.Name)
+		}
+	}()
+
+	status, err := waitExternalBundle(ctx, p, req, timeout)
+	if err != nil {
+		return "", 0, err
+	}
+
+	download := status.Download
+	file := fmt.Sprintf("%s-support-bundle_%s_%s", p.Name(), req.ID, strings.ReplaceAll(utils.Now(), ":", "-"))
+	if download.Format != "" {
+		file += "." + download.Format
+	}
+	size, err := downloadExternalBundle(ctx, download, filepath.Join(dir, file))
+	if err != nil {
+		return "", 0, err
+	}
+	logrus.Infof("Downloaded %s support bundle %s (%d bytes)", p.Name(), file, size)
+	return file, size, nil
+}
+
+// waitExternalBundle polls the provider until the bundle is ready
+func waitExternalBundle(ctx context.Context, p ExternalBundleProvider, req *ExternalBundleRequest, timeout time.Duration) (*ExternalBundleStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		status, err := p.Poll(ctx, req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to poll %s support bundle", p.Name())
+		}
+		if status.Error != "" {
+			return nil, fmt.Errorf("%s failed to generate support bundle %s: %s", p.Name(), req.Name, status.Error)
+		}
+		if status.Ready {
+			if status.Download == nil || status.Download.URL == "" {
+				return nil, fmt.Errorf("%s support bundle %s is ready without a download", p.Name(), req.Name)
+			}
+			return status, nil
+		}
+		logrus.Debugf("%s support bundle %s is %s", p.Name(), req.Name, status.Progress)
+
+		if !sleepContext(ctx, externalBundlePollInterval) {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("timed out after %v waiting for %s support bundle %s, last state %s", timeout, p.Name(), req.Name, status.Progress)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// downloadExternalBundle downloads an archive to path, and removes it if it
+// isn't valid
+func downloadExternalBundle(ctx context.Context, d *ExternalBundleDownload, path string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range d.Header {
+		req.Header[key] = values
+	}
+	httpClient := d.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to download %s", d.URL)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download %s: %s", d.URL, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyArchive(path, d.Format)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, errors.Wrapf(err, "failed to download %s", d.URL)
+	}
+	return size, nil
+}
+
+func verifyArchive(path, format string) error {
+	switch format {
+	case ExternalBundleFormatZip:
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		return r.Close()
+	case ExternalBundleFormatTarGz:
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			_, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, tr); err != nil {
+				return err
+			}
+		}
+	case "":
+		return nil
+	}
+	return fmt.Errorf("unknown archive format %q", format)
+}
@@ -0,0 +1,421 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+)
+
+// HTTPProviderConfig declares a product serving its bundle over HTTP. Every
+// URL, path, header and body is a Go template of the ExternalBundleRequest
+// fields, plus .Trigger and .Status, the JSON answers of the trigger and of
+// the last poll. A trigger answered with a conflict has no answer, templates
+// using .Trigger fail to render then.
+type HTTPProviderConfig struct {
+	Name      string                `yaml:"name"`
+	Discovery HTTPProviderDiscovery `yaml:"discovery"`
+	// URL the paths are relative to, e.g. http://rancher.cattle-system.svc
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// BearerTokenFile is sent as an Authorization header, e.g. the token of
+	// the manager's service account
+	BearerTokenFile    string `yaml:"bearerTokenFile,omitempty"`
+	CAFile             string `yaml:"caFile,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+
+	// Trigger is sent once, without it the bundle is only polled or
+	// downloaded
+	Trigger *HTTPProviderRequest `yaml:"trigger,omitempty"`
+	// Poll is sent until the bundle is ready, without it the bundle is
+	// downloaded right away
+	Poll     *HTTPProviderPoll    `yaml:"poll,omitempty"`
+	Download HTTPProviderDownload `yaml:"download"`
+	Cleanup  *HTTPProviderRequest `yaml:"cleanup,omitempty"`
+}
+
+// HTTPProviderDiscovery detects the product by a resource or a service
+type HTTPProviderDiscovery struct {
+	// Resource is group/version/resource, e.g. kubevirt.io/v1/kubevirts
+	Resource string `yaml:"resource,omitempty"`
+	// Service is namespace/name
+	Service string `yaml:"service,omitempty"`
+}
+
+type HTTPProviderRequest struct {
+	// Method defaults to POST for triggers and DELETE for cleanups
+	Method string `yaml:"method,omitempty"`
+	Path   string `yaml:"path"`
+	Body   string `yaml:"body,omitempty"`
+}
+
+type HTTPProviderPoll struct {
+	Path string `yaml:"path"`
+	// StateField is the dotted path of the state in the JSON answer, e.g.
+	// status.state
+	StateField  string   `yaml:"stateField"`
+	ReadyStates []string `yaml:"readyStates"`
+	ErrorStates []string `yaml:"errorStates,omitempty"`
+	// ErrorField is the dotted path of the message reported on errors
+	ErrorField string `yaml:"errorField,omitempty"`
+}
+
+type HTTPProviderDownload struct {
+	Path string `yaml:"path"`
+	// Format is zip or tar.gz, the archive isn't verified without it
+	Format string `yaml:"format,omitempty"`
+}
+
+// httpProviderData is what the templates of an HTTPProviderConfig see
+type httpProviderData struct {
+	*ExternalBundleRequest
+	Trigger interface{}
+	Status  interface{}
+}
+
+type httpProvider struct {
+	config    HTTPProviderConfig
+	discovery *client.DiscoveryClient
+	k8s       *client.KubernetesClient
+	client    *http.Client
+	templates map[string]*template.Template
+
+	trigger interface{}
+	// triggerConflict is set when the bundle was already requested, the
+	// answer of the first trigger isn't known
+	triggerConflict bool
+	status          interface{}
+}
+
+func loadHTTPProviderConfigs(path string) ([]HTTPProviderConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read external bundle providers")
+	}
+	configs := []HTTPProviderConfig{}
+	if err := yaml.UnmarshalStrict(b, &configs); err != nil {
+		return nil, errors.Wrap(err, "fail to parse external bundle providers")
+	}
+	names := map[string]bool{}
+	for _, config := range configs {
+		if err := config.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid external bundle provider %q", config.Name)
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate external bundle provider %q", config.Name)
+		}
+		names[config.Name] = true
+	}
+	return configs, nil
+}
+
+func (c HTTPProviderConfig) validate() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, `/\ `) {
+		return fmt.Errorf("invalid name")
+	}
+	if (c.Discovery.Resource == "") == (c.Discovery.Service == "") {
+		return fmt.Errorf("discovery needs either a resource or a service")
+	}
+	if c.Discovery.Resource != "" {
+		if _, err := parseGroupVersionResource(c.Discovery.Resource); err != nil {
+			return err
+		}
+	}
+	if c.Discovery.Service != "" && strings.Count(c.Discovery.Service, "/") != 1 {
+		return fmt.Errorf("invalid service %q, expected namespace/name", c.Discovery.Service)
+	}
+	if c.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	if c.Poll != nil && (c.Poll.StateField == "" || len(c.Poll.ReadyStates) == 0) {
+		return fmt.Errorf("poll needs a stateField and readyStates")
+	}
+	switch c.Download.Format {
+	case "", ExternalBundleFormatZip, ExternalBundleFormatTarGz:
+	default:
+		return fmt.Errorf("unknown download format %q", c.Download.Format)
+	}
+	return nil
+}
+
+// parseGroupVersionResource parses group/version/resource, or
+// version/resource for the core group
+func parseGroupVersionResource(s string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(s, "/")
+	switch len(parts) {
+	case 2:
+		return schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}, nil
+	case 3:
+		return schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("invalid resource %q, expected group/version/resource", s)
+}
+
+func newHTTPProvider(config HTTPProviderConfig, discovery *client.DiscoveryClient, k8s *client.KubernetesClient) (*httpProvider, error) {
+	p := &httpProvider{
+		config:    config,
+		discovery: discovery,
+		k8s:       k8s,
+		templates: map[string]*template.Template{},
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to read CA of %s", config.Name)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", config.CAFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	p.client = &http.Client{Transport: transport}
+
+	texts := map[string]string{"url": config.URL, "download.path": config.Download.Path}
+	for key, value := range config.Headers {
+		texts["headers."+key] = value
+	}
+	for prefix, r := range map[string]*HTTPProviderRequest{"trigger": config.Trigger, "cleanup": config.Cleanup} {
+		if r != nil {
+			texts[prefix+".path"] = r.Path
+			texts[prefix+".body"] = r.Body
+		}
+	}
+	if config.Poll != nil {
+		texts["poll.path"] = config.Poll.Path
+	}
+	for key, text := range texts {
+		t, err := template.New(key).Funcs(template.FuncMap{"json": toJSON}).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s of %s", key, config.Name)
+		}
+		p.templates[key] = t
+	}
+	return p, nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (p *httpProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *httpProvider) Detect(ctx context.Context) (bool, error) {
+	if p.config.Discovery.Resource != "" {
+		gvr, err := parseGroupVersionResource(p.config.Discovery.Resource)
+		if err != nil {
+			return false, err
+		}
+		return p.discovery.WithContext(ctx).HasResource(gvr)
+	}
+	namespace, name, _ := strings.Cut(p.config.Discovery.Service, "/")
+	_, err := p.k8s.WithContext(ctx).GetService(namespace, name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Trigger takes a conflict as the bundle being already requested
+func (p *httpProvider) Trigger(ctx context.Context, req *ExternalBundleRequest) error {
+	if p.config.Trigger == nil {
+		return nil
+	}
+	answer, status, err := p.do(ctx, req, "trigger", p.config.Trigger, http.MethodPost)
+	if status == http.StatusConflict {
+		logrus.Infof("%s support bundle %s already requested", p.config.Name, req.Name)
+		p.triggerConflict = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p.trigger = answer
+	return nil
+}
+
+func (p *httpProvider) Poll(ctx context.Context, req *ExternalBundleRequest) (*ExternalBundleStatus, error) {
+	poll := p.config.Poll
+	if poll != nil {
+		answer, _, err := p.do(ctx, req, "poll", &HTTPProviderRequest{Path: poll.Path}, http.MethodGet)
+		if err != nil {
+			return nil, err
+		}
+		p.status = answer
+
+		state := ""
+		if value := lookupField(answer, poll.StateField); value != nil {
+			state = fmt.Sprint(value)
+		}
+		status := &ExternalBundleStatus{Progress: fmt.Sprintf("%q", state)}
+		if slices.Contains(poll.ErrorStates, state) {
+			status.Error = fmt.Sprintf("state %q", state)
+			if poll.ErrorField != "" {
+				if message := lookupField(answer, poll.ErrorField); message != nil {
+					status.Error = fmt.Sprint(message)
+				}
+			}
+			return status, nil
+		}
+		if !slices.Contains(poll.ReadyStates, state) {
+			return status, nil
+		}
+	}
+
+	url, err := p.render("url", req)
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.render("download.path", req)
+	if err != nil {
+		return nil, err
+	}
+	header, err := p.getHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalBundleStatus{
+		Ready: true,
+		Download: &ExternalBundleDownload{
+			URL:    url + path,
+			Header: header,
+			Client: p.client,
+			Format: p.config.Download.Format,
+		},
+	}, nil
+}
+
+func (p *httpProvider) Cleanup(ctx context.Context, req *ExternalBundleRequest) error {
+	if p.config.Cleanup == nil {
+		return nil
+	}
+	_, status, err := p.do(ctx, req, "cleanup", p.config.Cleanup, http.MethodDelete)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends one of the requests of the config and decodes its JSON answer,
+// the status code is returned with the error
+func (p *httpProvider) do(ctx context.Context, req *ExternalBundleRequest, name string, r *HTTPProviderRequest, defaultMethod string) (interface{}, int, error) {
+	method := r.Method
+	if method == "" {
+		method = defaultMethod
+	}
+	url, err := p.render("url", req)
+	if err != nil {
+		return nil, 0, err
+	}
+	path, err := p.render(name+".path", req)
+	if err != nil {
+		return nil, 0, err
+	}
+	var body io.Reader
+	if r.Body != "" {
+		s, err := p.render(name+".body", req)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = strings.NewReader(s)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, url+path, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	header, err := p.getHeader(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	httpReq.Header = header
+	if body != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to %s %s", method, url+path)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp.StatusCode, fmt.Errorf("failed to %s %s: %s: %s", method, url+path, resp.Status, bytes.TrimSpace(b))
+	}
+	var answer interface{}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &answer); err != nil {
+			return nil, resp.StatusCode, errors.Wrapf(err, "failed to decode the answer of %s %s", method, url+path)
+		}
+	}
+	return answer, resp.StatusCode, nil
+}
+
+func (p *httpProvider) getHeader(req *ExternalBundleRequest) (http.Header, error) {
+	header := http.Header{}
+	if p.config.BearerTokenFile != "" {
+		token, err := os.ReadFile(p.config.BearerTokenFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to read token of %s", p.config.Name)
+		}
+		header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	for key := range p.config.Headers {
+		value, err := p.render("headers."+key, req)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(key, value)
+	}
+	return header, nil
+}
+
+func (p *httpProvider) render(key string, req *ExternalBundleRequest) (string, error) {
+	buf := &bytes.Buffer{}
+	data := httpProviderData{ExternalBundleRequest: req, Trigger: p.trigger, Status: p.status}
+	if err := p.templates[key].Execute(buf, data); err != nil {
+		if p.triggerConflict && p.trigger == nil {
+			return "", errors.Wrapf(err, "failed to render %s of %s, .Trigger is unavailable since the bundle was already requested", key, p.config.Name)
+		}
+		return "", errors.Wrapf(err, "failed to render %s of %s", key, p.config.Name)
+	}
+	return buf.String(), nil
+}
+
+// lookupField returns the value at a dotted path of a decoded JSON object
+func lookupField(obj interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = m[key]
+	}
+	return obj
+}
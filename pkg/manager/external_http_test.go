@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTarGz(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	assert.Nil(t, w.WriteHeader(&tar.Header{Name: "virt-handler.log", Mode: 0644, Size: 4}))
+	_, err := w.Write([]byte("boot"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestLoadHTTPProviderConfigs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "providers.yaml")
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	configs, err := loadHTTPProviderConfigs(write(`
+- name: kubevirt
+  discovery:
+    resource: kubevirt.io/v1/kubevirts
+  url: https://diagnostics.kubevirt.svc
+  insecureSkipVerify: true
+  trigger:
+    path: /bundles
+    body: '{"name": {{ json .Name }}}'
+  poll:
+    path: /bundles/{{ .Trigger.id }}
+    stateField: status.phase
+    readyStates: [Done]
+  download:
+    path: /bundles/{{ .Trigger.id }}/archive
+    format: tar.gz
+`))
+	assert.Nil(t, err)
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "kubevirt", configs[0].Name)
+	assert.Equal(t, []string{"Done"}, configs[0].Poll.ReadyStates)
+
+	for content, message := range map[string]string{
+		"- name: a\n  url: http://a\n":                                                                                     "discovery",
+		"- name: a\n  discovery: {service: a}\n  url: http://a\n":                                                          "namespace/name",
+		"- name: a\n  discovery: {resource: a/b/c/d}\n  url: http://a\n":                                                   "group/version/resource",
+		"- name: a\n  discovery: {service: ns/a}\n  url: http://a\n  download: {format: rar}\n":                            "unknown download format",
+		"- name: a\n  discovery: {service: ns/a}\n  url: http://a\n  poll: {path: /}\n":                                    "stateField",
+		"- name: a\n  discovery: {service: ns/a}\n  url: http://a\n  unknown: true\n":                                      "unknown",
+		"- name: a/b\n  discovery: {service: ns/a}\n  url: http://a\n":                                                     "invalid name",
+		"- {name: a, discovery: {service: ns/a}, url: http://a}\n- {name: a, discovery: {service: ns/a}, url: http://a}\n": "duplicate",
+	} {
+		_, err := loadHTTPProviderConfigs(write(content))
+		assert.ErrorContains(t, err, message, content)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	setTestExternalBundlePollInterval(t)
+	archive := newTestTarGz(t)
+	var lock sync.Mutex
+	var requests []string
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+		assert.Equal(t, "support-bundle-kit-abc", req.Header.Get("X-Request"))
+		switch req.Method + " " + req.URL.Path {
+		case "POST /bundles":
+			body := map[string]string{}
+			assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+			assert.Equal(t, map[string]string{"name": "support-bundle-kit-abc", "description": "test"}, body)
+			_, _ = w.Write([]byte(`{"id": "42"}`))
+		case "GET /bundles/42":
+			polls++
+			if polls < 3 {
+				_, _ = w.Write([]byte(`{"status": {"phase": "Running"}}`))
+			} else {
+				_, _ = w.Write([]byte(`{"status": {"phase": "Done", "file": "kubevirt.tgz"}}`))
+			}
+		case "GET /bundles/42/kubevirt.tgz":
+			_, _ = w.Write(archive)
+		case "DELETE /bundles/42":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+	p, err := newHTTPProvider(HTTPProviderConfig{
+		Name:            "kubevirt",
+		Discovery:       HTTPProviderDiscovery{Service: "kubevirt/diagnostics"},
+		URL:             server.URL,
+		Headers:         map[string]string{"X-Request": "{{ .Name }}"},
+		BearerTokenFile: tokenFile,
+		Trigger: &HTTPProviderRequest{
+			Path: "/bundles",
+			Body: `{"name": {{ json .Name }}, "description": {{ json .Description }}}`,
+		},
+		Poll: &HTTPProviderPoll{
+			Path:        "/bundles/{{ .Trigger.id }}",
+			StateField:  "status.phase",
+			ReadyStates: []string{"Done"},
+			ErrorStates: []string{"Failed"},
+		},
+		Download: HTTPProviderDownload{Path: "/bundles/{{ .Trigger.id }}/{{ .Status.status.file }}", Format: ExternalBundleFormatTarGz},
+		Cleanup:  &HTTPProviderRequest{Path: "/bundles/{{ .Trigger.id }}"},
+	}, nil, nil)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	file, size, err := collectExternalBundle(context.Background(), p, newTestExternalBundleRequest("abc"), 5*time.Second, dir)
+	assert.Nil(t, err)
+	assert.Regexp(t, `^kubevirt-support-bundle_abc_.*\.tar\.gz$`, file)
+	assert.Equal(t, int64(len(archive)), size)
+	assert.FileExists(t, filepath.Join(dir, file))
+	assert.Equal(t, []string{
+		"POST /bundles",
+		"GET /bundles/42",
+		"GET /bundles/42",
+		"GET /bundles/42",
+		"GET /bundles/42/kubevirt.tgz",
+		"DELETE /bundles/42",
+	}, requests)
+}
+
+func TestHTTPProviderFailures(t *testing.T) {
+	setTestExternalBundlePollInterval(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/failed":
+			_, _ = w.Write([]byte(`{"state": "Failed", "message": "out of disk"}`))
+		case "/download":
+			_, _ = w.Write([]byte("not a zip"))
+		default:
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	config := HTTPProviderConfig{
+		Name:     "vendor",
+		URL:      server.URL,
+		Trigger:  &HTTPProviderRequest{Path: "/trigger"},
+		Poll:     &HTTPProviderPoll{Path: "/failed", StateField: "state", ReadyStates: []string{"Ready"}, ErrorStates: []string{"Failed"}, ErrorField: "message"},
+		Download: HTTPProviderDownload{Path: "/download", Format: ExternalBundleFormatZip},
+	}
+	p, err := newHTTPProvider(config, nil, nil)
+	assert.Nil(t, err)
+	// the conflicting trigger is taken as already requested
+	_, _, err = collectExternalBundle(context.Background(), p, newTestExternalBundleRequest("abc"), 5*time.Second, t.TempDir())
+	assert.ErrorContains(t, err, "vendor failed to generate support bundle support-bundle-kit-abc: out of disk")
+
+	// without poll, the bundle is downloaded right away
+	config.Poll = nil
+	p, err = newHTTPProvider(config, nil, nil)
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	_, _, err = collectExternalBundle(context.Background(), p, newTestExternalBundleRequest("abc"), 5*time.Second, dir)
+	assert.ErrorContains(t, err, "zip")
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
+
+func TestHTTPProviderTriggerConflict(t *testing.T) {
+	setTestExternalBundlePollInterval(t)
+	archive := newTestTarGz(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.Path {
+		case "POST /bundles":
+			w.WriteHeader(http.StatusConflict)
+		case "GET /bundles/support-bundle-kit-abc":
+			_, _ = w.Write([]byte(`{"phase": "Done"}`))
+		case "GET /bundles/support-bundle-kit-abc/download":
+			_, _ = w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := HTTPProviderConfig{
+		Name:     "vendor",
+		URL:      server.URL,
+		Trigger:  &HTTPProviderRequest{Path: "/bundles"},
+		Poll:     &HTTPProviderPoll{Path: "/bundles/{{ .Trigger.id }}", StateField: "phase", ReadyStates: []string{"Done"}},
+		Download: HTTPProviderDownload{Path: "/bundles/{{ .Name }}/download", Format: ExternalBundleFormatTarGz},
+	}
+	req := newTestExternalBundleRequest("abc")
+
+	// the answer of the first trigger isn't known
+	p, err := newHTTPProvider(config, nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Trigger(context.Background(), req))
+	_, err = p.Poll(context.Background(), req)
+	assert.ErrorContains(t, err, ".Trigger is unavailable since the bundle was already requested")
+
+	// templates of the request fields still work
+	config.Poll.Path = "/bundles/{{ .Name }}"
+	p, err = newHTTPProvider(config, nil, nil)
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	file, _, err := collectExternalBundle(context.Background(), p, req, 5*time.Second, dir)
+	assert.Nil(t, err)
+	assert.FileExists(t, filepath.Join(dir, file))
+}
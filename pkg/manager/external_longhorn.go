@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+)
+
+const (
+	longhornNamespace   = "longhorn-system"
+	longhornBackendURL  = "http://longhorn-backend.longhorn-system.svc:9500"
+	longhornBundleReady = "ReadyForDownload"
+	longhornBundleError = "Error"
+)
+
+var longhornSupportBundleGVR = schema.GroupVersionResource{Group: "longhorn.io", Version: "v1beta2", Resource: "supportbundles"}
+
+// longhornProvider asks Longhorn for its own support bundle through a
+// SupportBundle resource, and downloads it from the Longhorn manager API
+type longhornProvider struct {
+	discovery  *client.DiscoveryClient
+	dynamic    *client.DynamicClient
+	backendURL string
+}
+
+func (l *longhornProvider) Name() string {
+	return "longhorn"
+}
+
+func (l *longhornProvider) Detect(ctx context.Context) (bool, error) {
+	return l.discovery.WithContext(ctx).HasResource(longhornSupportBundleGVR)
+}
+
+func (l *longhornProvider) Trigger(ctx context.Context, req *ExternalBundleRequest) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(longhornSupportBundleGVR.GroupVersion().String())
+	obj.SetKind("SupportBundle")
+	obj.SetNamespace(longhornNamespace)
+	obj.SetName(req.Name)
+	obj.SetLabels(map[string]string{"app.kubernetes.io/created-by": "support-bundle-kit"})
+	obj.Object["spec"] = map[string]interface{}{
+		"description": req.Description,
+		"issueURL":    req.IssueURL,
+	}
+
+	_, err := l.dynamic.WithContext(ctx).Create(longhornSupportBundleGVR, longhornNamespace, obj)
+	if apierrors.IsAlreadyExists(err) {
+		logrus.Infof("Longhorn support bundle %s already requested", req.Name)
+		return nil
+	}
+	return errors.Wrap(err, "failed to create longhorn support bundle")
+}
+
+func (l *longhornProvider) Poll(ctx context.Context, req *ExternalBundleRequest) (*ExternalBundleStatus, error) {
+	obj, err := l.dynamic.WithContext(ctx).Get(longhornSupportBundleGVR, longhornNamespace, req.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get longhorn support bundle")
+	}
+	state, _, _ := unstructured.NestedString(obj.Object, "status", "state")
+	progress, _, _ := unstructured.NestedInt64(obj.Object, "status", "progress")
+	status := &ExternalBundleStatus{Progress: fmt.Sprintf("%q, %d%%", state, progress)}
+	switch state {
+	case longhornBundleReady:
+		status.Ready = true
+		if filename, _, _ := unstructured.NestedString(obj.Object, "status", "filename"); filename != "" {
+			status.Download = &ExternalBundleDownload{
+				URL:    fmt.Sprintf("%s/v1/supportbundles/%s/%s/download", l.backendURL, req.Name, filename),
+				Format: ExternalBundleFormatZip,
+			}
+		}
+	case longhornBundleError:
+		status.Error = getConditionMessages(obj)
+	}
+	return status, nil
+}
+
+// Cleanup deletes the SupportBundle, Longhorn removes its bundle manager
+// with it
+func (l *longhornProvider) Cleanup(ctx context.Context, req *ExternalBundleRequest) error {
+	err := l.dynamic.WithContext(ctx).Delete(longhornSupportBundleGVR, longhornNamespace, req.Name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func getConditionMessages(obj *unstructured.Unstructured) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	var messages []string
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if message, _ := condition["message"].(string); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return "unknown error"
+	}
+	return strings.Join(messages, "; ")
+}
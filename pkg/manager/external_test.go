@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+)
+
+func setTestExternalBundlePollInterval(t *testing.T) {
+	externalBundlePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		externalBundlePollInterval = 5 * time.Second
+	})
+}
+
+func newTestExternalBundleRequest(name string) *ExternalBundleRequest {
+	return &ExternalBundleRequest{ID: name, Name: externalBundleNamePrefix + name, Description: "test"}
+}
+
+func newTestLonghornProvider(t *testing.T, handler http.HandlerFunc) (*longhornProvider, *dynamicfake.FakeDynamicClient) {
+	setTestExternalBundlePollInterval(t)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	fakeDynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		longhornSupportBundleGVR: "SupportBundleList",
+	})
+	return &longhornProvider{
+		dynamic:    client.NewDynamicClientFor(context.Background(), fakeDynamic),
+		backendURL: server.URL,
+	}, fakeDynamic
+}
+
+// setLonghornBundleStatus plays Longhorn once the bundle is requested
+func setLonghornBundleStatus(t *testing.T, fakeDynamic *dynamicfake.FakeDynamicClient, name string, status map[string]interface{}) {
+	go func() {
+		resource := fakeDynamic.Resource(longhornSupportBundleGVR).Namespace(longhornNamespace)
+		for i := 0; i < 100; i++ {
+			obj, err := resource.Get(context.Background(), name, metav1.GetOptions{})
+			if err == nil {
+				assert.Nil(t, unstructured.SetNestedMap(obj.Object, status, "status"))
+				_, err = resource.Update(context.Background(), obj, metav1.UpdateOptions{})
+				assert.Nil(t, err)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func TestCollectLonghornBundle(t *testing.T) {
+	archive := newTestZip(t)
+	var downloaded string
+	l, fakeDynamic := newTestLonghornProvider(t, func(w http.ResponseWriter, req *http.Request) {
+		downloaded = req.URL.Path
+		_, _ = w.Write(archive)
+	})
+	req := newTestExternalBundleRequest("abc")
+	setLonghornBundleStatus(t, fakeDynamic, req.Name, map[string]interface{}{
+		"state":    longhornBundleReady,
+		"filename": "supportbundle_xyz.zip",
+	})
+
+	dir := t.TempDir()
+	file, size, err := collectExternalBundle(context.Background(), l, req, 5*time.Second, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/supportbundles/support-bundle-kit-abc/supportbundle_xyz.zip/download", downloaded)
+	assert.Regexp(t, `^longhorn-support-bundle_abc_.*\.zip$`, file)
+	assert.Equal(t, int64(len(archive)), size)
+	assert.FileExists(t, filepath.Join(dir, file))
+
+	// the request is removed afterwards
+	_, err = fakeDynamic.Resource(longhornSupportBundleGVR).Namespace(longhornNamespace).Get(context.Background(), req.Name, metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestCollectLonghornBundleFailures(t *testing.T) {
+	l, fakeDynamic := newTestLonghornProvider(t, func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("not a zip"))
+	})
+
+	req := newTestExternalBundleRequest("error")
+	setLonghornBundleStatus(t, fakeDynamic, req.Name, map[string]interface{}{
+		"state": longhornBundleError,
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Manager", "status": "False", "message": "failed to create manager"},
+		},
+	})
+	_, _, err := collectExternalBundle(context.Background(), l, req, 5*time.Second, t.TempDir())
+	assert.ErrorContains(t, err, "failed to create manager")
+
+	req = newTestExternalBundleRequest("corrupted")
+	setLonghornBundleStatus(t, fakeDynamic, req.Name, map[string]interface{}{
+		"state":    longhornBundleReady,
+		"filename": "supportbundle_xyz.zip",
+	})
+	dir := t.TempDir()
+	_, _, err = collectExternalBundle(context.Background(), l, req, 5*time.Second, dir)
+	assert.NotNil(t, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+
+	_, _, err = collectExternalBundle(context.Background(), l, newTestExternalBundleRequest("stuck"), 50*time.Millisecond, t.TempDir())
+	assert.ErrorContains(t, err, "timed out")
+}
+
+func TestExternalBundlesOfLonghornBundle(t *testing.T) {
+	// the manager Longhorn runs for its own bundle never detects providers,
+	// they would request another Longhorn bundle
+	m := &SupportBundleManager{SpecifyCollector: "longhorn", OutputDir: t.TempDir()}
+	assert.Nil(t, m.phaseCollectExternalBundles(context.Background()))
+	assert.NoDirExists(t, filepath.Join(m.getWorkingDir(), externalDir))
+}
+
+// contextProvider records the context of the cleanup
+type contextProvider struct {
+	cleanupErr error
+}
+
+func (p *contextProvider) Name() string {
+	return "vendor"
+}
+
+func (p *contextProvider) Detect(_ context.Context) (bool, error) {
+	return true, nil
+}
+
+func (p *contextProvider) Trigger(_ context.Context, _ *ExternalBundleRequest) error {
+	return nil
+}
+
+func (p *contextProvider) Poll(_ context.Context, _ *ExternalBundleRequest) (*ExternalBundleStatus, error) {
+	return &ExternalBundleStatus{Progress: "generating"}, nil
+}
+
+func (p *contextProvider) Cleanup(ctx context.Context, _ *ExternalBundleRequest) error {
+	p.cleanupErr = ctx.Err()
+	return nil
+}
+
+func TestCollectExternalBundleCleanupAfterTimeout(t *testing.T) {
+	setTestExternalBundlePollInterval(t)
+	p := &contextProvider{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := collectExternalBundle(ctx, p, newTestExternalBundleRequest("abc"), time.Minute, t.TempDir())
+	assert.NotNil(t, err)
+	assert.Nil(t, p.cleanupErr)
+}
@@ -0,0 +1,455 @@
+package manager
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+var errBundlePathNotFound = errors.New("no such file or directory in the bundle")
+
+// getBundleDir returns the directory holding the bundle tree. It's the
+// working dir during the collection and moves for packaging.
+func (m *SupportBundleManager) getBundleDir() string {
+	if _, err := os.Stat(m.getWorkingDir()); err == nil || m.bundleFileName == "" {
+		return m.getWorkingDir()
+	}
+	return m.getPackagedDir()
+}
+
+// bundlePath is a path of the bundle tree resolved on disk. Paths below a
+// node bundle zip are looked up inside the zip.
+type bundlePath struct {
+	// relative path in the bundle tree
+	name string
+	// file or directory on disk, the zip for paths inside a zip
+	fsPath string
+	// path inside the zip, empty for the zip itself
+	zipPath string
+	inZip   bool
+}
+
+func (m *SupportBundleManager) resolveBundlePath(p string) (*bundlePath, error) {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	resolved := &bundlePath{name: name, fsPath: m.getBundleDir()}
+	if name == "" {
+		return resolved, nil
+	}
+
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		resolved.fsPath = filepath.Join(resolved.fsPath, segment)
+		fi, err := os.Stat(resolved.fsPath)
+		if err != nil {
+			return nil, errBundlePathNotFound
+		}
+		if fi.IsDir() {
+			continue
+		}
+		if i == len(segments)-1 {
+			break
+		}
+		if !isArchive(resolved.fsPath) {
+			return nil, errBundlePathNotFound
+		}
+		resolved.inZip = true
+		resolved.zipPath = strings.Join(segments[i+1:], "/")
+		break
+	}
+	return resolved, nil
+}
+
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, ".zip")
+}
+
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// listBundlePath lists the children of a directory, or everything below it
+// when recursive. Directory sizes are the sizes of their content.
+func listBundlePath(p *bundlePath, recursive bool) ([]apiv1.FileInfo, error) {
+	if p.inZip || isArchive(p.fsPath) {
+		return listZip(p, recursive)
+	}
+
+	fi, err := os.Stat(p.fsPath)
+	if err != nil {
+		return nil, errBundlePathNotFound
+	}
+	if !fi.IsDir() {
+		return []apiv1.FileInfo{{Path: p.name, Size: fi.Size(), ModTime: formatModTime(fi.ModTime())}}, nil
+	}
+
+	var files []apiv1.FileInfo
+	dirs := map[string]int{}
+	err = filepath.WalkDir(p.fsPath, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fsPath == p.fsPath {
+			return nil
+		}
+		rel, err := filepath.Rel(p.fsPath, fsPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		depth := strings.Count(rel, "/")
+		if d.IsDir() {
+			if recursive || depth == 0 {
+				dirs[rel] = len(files)
+				files = append(files, apiv1.FileInfo{Path: path.Join(p.name, rel), Dir: true, ModTime: formatModTime(fi.ModTime())})
+			}
+			return nil
+		}
+
+		// account the size to the listed directories above
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if i, ok := dirs[dir]; ok {
+				files[i].Size += fi.Size()
+			}
+		}
+		if recursive || depth == 0 {
+			files = append(files, apiv1.FileInfo{
+				Path:    path.Join(p.name, rel),
+				Size:    fi.Size(),
+				ModTime: formatModTime(fi.ModTime()),
+				Archive: isArchive(rel),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// zipEntries returns the entries of the zip below a path, keyed by their path
+// relative to it
+func zipEntries(z *zip.Reader, zipPath string) map[string]*zip.File {
+	entries := map[string]*zip.File{}
+	prefix := ""
+	if zipPath != "" {
+		prefix = zipPath + "/"
+	}
+	for _, f := range z.File {
+		name := strings.TrimSuffix(f.Name, "/")
+		if f.FileInfo().IsDir() || !strings.HasPrefix(name, prefix) || name == zipPath {
+			continue
+		}
+		entries[strings.TrimPrefix(name, prefix)] = f
+	}
+	return entries
+}
+
+func listZip(p *bundlePath, recursive bool) ([]apiv1.FileInfo, error) {
+	z, err := zip.OpenReader(p.fsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to open %s", p.fsPath)
+	}
+	defer func() {
+		_ = z.Close()
+	}()
+
+	if p.zipPath != "" {
+		for _, f := range z.File {
+			if f.Name == p.zipPath && !f.FileInfo().IsDir() {
+				return []apiv1.FileInfo{{Path: p.name, Size: int64(f.UncompressedSize64), ModTime: formatModTime(f.Modified)}}, nil
+			}
+		}
+	}
+
+	entries := zipEntries(&z.Reader, p.zipPath)
+	if len(entries) == 0 {
+		return nil, errBundlePathNotFound
+	}
+
+	var files []apiv1.FileInfo
+	dirs := map[string]int{}
+	addDir := func(dir string) {
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = len(files)
+			files = append(files, apiv1.FileInfo{Path: path.Join(p.name, dir), Dir: true})
+		}
+	}
+	for rel, f := range entries {
+		size := int64(f.UncompressedSize64)
+		parts := strings.Split(rel, "/")
+		for i := 1; i < len(parts); i++ {
+			dir := strings.Join(parts[:i], "/")
+			if !recursive && i > 1 {
+				break
+			}
+			addDir(dir)
+			files[dirs[dir]].Size += size
+		}
+		if recursive || len(parts) == 1 {
+			files = append(files, apiv1.FileInfo{Path: path.Join(p.name, rel), Size: size, ModTime: formatModTime(f.Modified)})
+		}
+	}
+	return files, nil
+}
+
+func (s *HttpServer) listFiles(w http.ResponseWriter, req *http.Request) {
+	name, err := url.PathUnescape(mux.Vars(req)["path"])
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := s.manager.resolveBundlePath(name)
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusNotFound, err)
+		return
+	}
+
+	files, err := listBundlePath(p, req.URL.Query().Get("recursive") == "true")
+	if err == errBundlePathNotFound {
+		utils.HttpResponseError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		utils.HttpResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	utils.HttpResponseOKWithBody(w, apiv1.FileList{Path: p.name, Files: files})
+}
+
+// getFile serves a single file with Range support, or a directory as a zip
+func (s *HttpServer) getFile(w http.ResponseWriter, req *http.Request) {
+	name, err := url.PathUnescape(mux.Vars(req)["path"])
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	p, err := s.manager.resolveBundlePath(name)
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if p.inZip {
+		err = serveZipPath(w, req, p)
+	} else {
+		err = serveFSPath(w, req, p)
+	}
+	if err == errBundlePathNotFound {
+		utils.HttpResponseError(w, http.StatusNotFound, err)
+	} else if err != nil {
+		logrus.WithError(err).Errorf("Failed to serve %s", p.name)
+	}
+}
+
+func setAttachment(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+}
+
+func serveFSPath(w http.ResponseWriter, req *http.Request, p *bundlePath) error {
+	f, err := os.Open(p.fsPath)
+	if err != nil {
+		return errBundlePathNotFound
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		setAttachment(w, fi.Name())
+		http.ServeContent(w, req, fi.Name(), fi.ModTime(), f)
+		return nil
+	}
+
+	// the archive has the directory at its root
+	base := filepath.Base(p.fsPath)
+	w.Header().Set("Content-Type", "application/zip")
+	setAttachment(w, base+".zip")
+	zw := zip.NewWriter(w)
+	err = filepath.WalkDir(p.fsPath, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(p.fsPath, fsPath)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(base, filepath.ToSlash(rel))
+		header.Method = zip.Deflate
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = src.Close()
+		}()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func serveZipPath(w http.ResponseWriter, req *http.Request, p *bundlePath) error {
+	z, err := zip.OpenReader(p.fsPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = z.Close()
+	}()
+
+	for _, f := range z.File {
+		if f.Name == p.zipPath && !f.FileInfo().IsDir() {
+			base := path.Base(f.Name)
+			setAttachment(w, base)
+			content := &zipEntryReader{file: f, size: int64(f.UncompressedSize64)}
+			defer func() {
+				_ = content.Close()
+			}()
+			http.ServeContent(w, req, base, f.Modified, content)
+			return nil
+		}
+	}
+
+	entries := zipEntries(&z.Reader, p.zipPath)
+	if len(entries) == 0 {
+		return errBundlePathNotFound
+	}
+	names := make([]string, 0, len(entries))
+	for rel := range entries {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+
+	// compressed entries are copied as is
+	base := path.Base(p.zipPath)
+	w.Header().Set("Content-Type", "application/zip")
+	setAttachment(w, base+".zip")
+	zw := zip.NewWriter(w)
+	for _, rel := range names {
+		f := entries[rel]
+		header := f.FileHeader
+		header.Name = path.Join(base, rel)
+		dst, err := zw.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		src, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// zipEntryReader makes a compressed zip entry seekable for Range requests.
+// Seeking backwards decompresses again from the start, seeking forwards
+// skips the data in between.
+type zipEntryReader struct {
+	file   *zip.File
+	size   int64
+	offset int64
+
+	rc       io.ReadCloser
+	rcOffset int64
+}
+
+func (r *zipEntryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *zipEntryReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil || r.rcOffset > r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		rc, err := r.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc, r.rcOffset = rc, 0
+	}
+	if r.rcOffset < r.offset {
+		n, err := io.CopyN(io.Discard, r.rc, r.offset-r.rcOffset)
+		r.rcOffset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	r.rcOffset += int64(n)
+	return n, err
+}
+
+func (r *zipEntryReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
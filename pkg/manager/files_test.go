@@ -0,0 +1,158 @@
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+)
+
+func writeTestBundleTree(t *testing.T, dir string) {
+	files := map[string]string{
+		"metadata.yaml":                      "bundleName: sample\n",
+		"yamls/cluster/v1/nodes.yaml":        "items: []\n",
+		"logs/kube-system/coredns/dns.log":   "started\n",
+		"logs/kube-system/coredns/dns.log.1": "crashed\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"node1/logs/dmesg.log":     strings.Repeat("kernel: boot\n", 1000),
+		"node1/configs/os-release": "ID=sle-micro\n",
+	} {
+		f, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = f.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "nodes"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "nodes", "node1.zip"), buf.Bytes(), 0644))
+}
+
+func findFile(files []apiv1.FileInfo, path string) *apiv1.FileInfo {
+	for i := range files {
+		if files[i].Path == path {
+			return &files[i]
+		}
+	}
+	return nil
+}
+
+func TestListFiles(t *testing.T) {
+	m, auth, server := newTestAPIServer(t)
+	writeTestBundleTree(t, m.getWorkingDir())
+	c, err := apiv1.NewClient(server.URL, apiv1.WithCA(auth.caPEM), apiv1.WithToken(auth.consumerToken))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	root, err := c.ListFiles(ctx, "", false)
+	assert.Nil(t, err)
+	assert.Len(t, root.Files, 4)
+	logs := findFile(root.Files, "logs")
+	assert.NotNil(t, logs)
+	assert.True(t, logs.Dir)
+	assert.Equal(t, int64(16), logs.Size)
+
+	nodes, err := c.ListFiles(ctx, "nodes", false)
+	assert.Nil(t, err)
+	assert.True(t, findFile(nodes.Files, "nodes/node1.zip").Archive)
+
+	inZip, err := c.ListFiles(ctx, "nodes/node1.zip", true)
+	assert.Nil(t, err)
+	dmesg := findFile(inZip.Files, "nodes/node1.zip/node1/logs/dmesg.log")
+	assert.NotNil(t, dmesg)
+	assert.Equal(t, int64(13000), dmesg.Size)
+	assert.Equal(t, int64(13013), findFile(inZip.Files, "nodes/node1.zip/node1").Size)
+
+	_, err = c.ListFiles(ctx, "missing", false)
+	assert.NotNil(t, err)
+}
+
+func TestResolveBundlePath(t *testing.T) {
+	m := &SupportBundleManager{OutputDir: t.TempDir()}
+	writeTestBundleTree(t, m.getWorkingDir())
+
+	p, err := m.resolveBundlePath("../../metadata.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(m.getWorkingDir(), "metadata.yaml"), p.fsPath)
+
+	p, err = m.resolveBundlePath("/nodes/node1.zip/node1/logs/")
+	assert.Nil(t, err)
+	assert.True(t, p.inZip)
+	assert.Equal(t, "node1/logs", p.zipPath)
+
+	_, err = m.resolveBundlePath("metadata.yaml/x")
+	assert.Equal(t, errBundlePathNotFound, err)
+}
+
+func TestDownloadFiles(t *testing.T) {
+	m, auth, server := newTestAPIServer(t)
+	writeTestBundleTree(t, m.getWorkingDir())
+	c, err := apiv1.NewClient(server.URL, apiv1.WithCA(auth.caPEM), apiv1.WithToken(auth.consumerToken))
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	_, err = c.DownloadFile(ctx, "logs/kube-system/coredns/dns.log", &buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "started\n", buf.String())
+
+	// resume a file inside a node bundle
+	buf.Reset()
+	n, err := c.DownloadFile(ctx, "nodes/node1.zip/node1/logs/dmesg.log", &buf, 12987)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), n)
+	assert.Equal(t, "kernel: boot\n", buf.String())
+
+	// directories are zipped
+	buf.Reset()
+	_, err = c.DownloadFile(ctx, "nodes/node1.zip/node1/configs", &buf, 0)
+	assert.Nil(t, err)
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	assert.Len(t, z.File, 1)
+	assert.Equal(t, "configs/os-release", z.File[0].Name)
+
+	buf.Reset()
+	_, err = c.DownloadFile(ctx, "logs/kube-system", &buf, 0)
+	assert.Nil(t, err)
+	z, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	assert.Len(t, z.File, 2)
+}
+
+func TestBundleRange(t *testing.T) {
+	m, auth, server := newTestAPIServer(t)
+	assert.Nil(t, os.WriteFile(m.getBundlefile(), []byte("0123456789"), 0644))
+
+	req, err := http.NewRequest("GET", server.URL+apiv1.BundlePath, nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+auth.consumerToken)
+	req.Header.Set("Range", "bytes=4-")
+	resp, err := server.Client().Do(req)
+	assert.Nil(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "456789", string(b))
+	assert.Equal(t, "attachment; filename=supportbundle_test.zip", resp.Header.Get("Content-Disposition"))
+}
@@ -0,0 +1,262 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	helmDir = "helm"
+	// release Secrets of the Helm 3 storage driver
+	helmReleaseSecretType = "helm.sh/release.v1"
+	helmReleaseSelector   = "owner=helm"
+
+	redacted = "REDACTED"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+	// the values under matching keys are redacted
+	sensitiveKeyPattern = regexp.MustCompile(`(?i)pass(word|wd|phrase)?|secret|token|key|credential|cert|auth|private|salt|dsn|connection`)
+	userInfoPattern     = regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`)
+	pemBlockPattern     = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?(-----END [A-Z0-9 ]+-----|$)`)
+	// key=value or key: value pairs in text
+	keyValuePattern = regexp.MustCompile(`([\w.-]+)(\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s,;]+)`)
+)
+
+// HelmReleases is written to helm/releases.yaml
+type HelmReleases struct {
+	Releases []HelmRelease `yaml:"releases"`
+}
+
+// HelmRelease is the latest revision of a release with its history. Values
+// are the user supplied values, redacted.
+type HelmRelease struct {
+	Name         string                 `yaml:"name"`
+	Namespace    string                 `yaml:"namespace"`
+	Chart        string                 `yaml:"chart"`
+	ChartVersion string                 `yaml:"chartVersion"`
+	AppVersion   string                 `yaml:"appVersion,omitempty"`
+	Revision     int                    `yaml:"revision"`
+	Status       string                 `yaml:"status"`
+	Description  string                 `yaml:"description,omitempty"`
+	Updated      string                 `yaml:"updated,omitempty"`
+	Notes        string                 `yaml:"notes,omitempty"`
+	Values       map[string]interface{} `yaml:"values,omitempty"`
+	History      []HelmRevision         `yaml:"history"`
+}
+
+type HelmRevision struct {
+	Revision     int    `yaml:"revision"`
+	ChartVersion string `yaml:"chartVersion"`
+	AppVersion   string `yaml:"appVersion,omitempty"`
+	Status       string `yaml:"status"`
+	Description  string `yaml:"description,omitempty"`
+	Updated      string `yaml:"updated,omitempty"`
+}
+
+// helmRelease is the part of a Helm release record the bundle needs, the
+// templates and manifest are left out
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		LastDeployed time.Time `json:"last_deployed"`
+		Description  string    `json:"description"`
+		Status       string    `json:"status"`
+		Notes        string    `json:"notes"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	Config map[string]interface{} `json:"config"`
+}
+
+func (m *SupportBundleManager) phaseCollectHelmBundle(ctx context.Context) error {
+	k8s := m.k8s.WithContext(ctx)
+	secrets, err := k8s.GetSecretsListByLabels(corev1.NamespaceAll, helmReleaseSelector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list helm release secrets")
+	}
+
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	releases := newHelmReleases(secrets, errLog)
+	logrus.Infof("Writing %d helm releases", len(releases.Releases))
+	encodeToYAMLFile(releases, filepath.Join(m.getWorkingDir(), helmDir, "releases.yaml"), errLog)
+	return nil
+}
+
+// newHelmReleases decodes the release Secrets in memory, the ones failing
+// to decode are logged
+func newHelmReleases(secrets *corev1.SecretList, errLog io.Writer) *HelmReleases {
+	revisions := map[string][]*helmRelease{}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Type != helmReleaseSecretType {
+			continue
+		}
+		release, err := decodeHelmRelease(secret.Data["release"])
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to decode helm release %s/%s: %v\n", secret.Namespace, secret.Name, err)
+			continue
+		}
+		if release.Namespace == "" {
+			release.Namespace = secret.Namespace
+		}
+		key := release.Namespace + "/" + release.Name
+		revisions[key] = append(revisions[key], release)
+	}
+
+	releases := &HelmReleases{Releases: []HelmRelease{}}
+	for _, history := range revisions {
+		sort.Slice(history, func(i, j int) bool {
+			return history[i].Version < history[j].Version
+		})
+		latest := history[len(history)-1]
+		r := HelmRelease{
+			Name:         latest.Name,
+			Namespace:    latest.Namespace,
+			Chart:        latest.Chart.Metadata.Name,
+			ChartVersion: latest.Chart.Metadata.Version,
+			AppVersion:   latest.Chart.Metadata.AppVersion,
+			Revision:     latest.Version,
+			Status:       latest.Info.Status,
+			Description:  latest.Info.Description,
+			Updated:      formatHelmTime(latest.Info.LastDeployed),
+			Notes:        redactText(latest.Info.Notes),
+			Values:       redactValues(latest.Config),
+		}
+		for _, revision := range history {
+			r.History = append(r.History, HelmRevision{
+				Revision:     revision.Version,
+				ChartVersion: revision.Chart.Metadata.Version,
+				AppVersion:   revision.Chart.Metadata.AppVersion,
+				Status:       revision.Info.Status,
+				Description:  revision.Info.Description,
+				Updated:      formatHelmTime(revision.Info.LastDeployed),
+			})
+		}
+		releases.Releases = append(releases.Releases, r)
+	}
+	sort.Slice(releases.Releases, func(i, j int) bool {
+		a, b := releases.Releases[i], releases.Releases[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return releases
+}
+
+// decodeHelmRelease decodes the base64 encoded, usually gzipped, JSON
+// release record
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		if b, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	release := &helmRelease{}
+	if err := json.Unmarshal(b, release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func formatHelmTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// redactValues returns a copy of the values where the strings and numbers
+// under sensitive keys, private keys and credentials in URLs are redacted.
+// Booleans and the structure are kept, e.g. whether TLS is enabled.
+func redactValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	return redactValue(values, false).(map[string]interface{})
+}
+
+func redactValue(value interface{}, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redactedValues := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redactedValues[key] = redactValue(item, sensitive || sensitiveKeyPattern.MatchString(key))
+		}
+		return redactedValues
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = redactValue(item, sensitive)
+		}
+		return items
+	case bool, nil:
+		return v
+	case string:
+		if sensitive || strings.Contains(v, "-----BEGIN") {
+			return redacted
+		}
+		return userInfoPattern.ReplaceAllString(v, "://"+redacted+"@")
+	default:
+		if sensitive {
+			return redacted
+		}
+		return v
+	}
+}
+
+// redactText redacts PEM blocks, credentials in URLs and the values of
+// sensitive keys in free text, e.g. the notes of a release
+func redactText(text string) string {
+	text = pemBlockPattern.ReplaceAllString(text, redacted)
+	text = userInfoPattern.ReplaceAllString(text, "://"+redacted+"@")
+	return keyValuePattern.ReplaceAllStringFunc(text, func(pair string) string {
+		match := keyValuePattern.FindStringSubmatch(pair)
+		if !sensitiveKeyPattern.MatchString(match[1]) {
+			return pair
+		}
+		return match[1] + match[2] + redacted
+	})
+}
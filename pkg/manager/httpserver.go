@@ -2,9 +2,11 @@ package manager
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,9 +17,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/types"
 	"github.com/rancher/support-bundle-kit/pkg/utils"
 )
 
+const eventStreamKeepalive = 15 * time.Second
+
 type HttpServer struct {
 	context context.Context
 	manager *SupportBundleManager
@@ -34,6 +40,33 @@ func (s *HttpServer) getStatus(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *HttpServer) getStatusV1(w http.ResponseWriter, req *http.Request) {
+	s.manager.status.RLock()
+	status := apiv1.NewStatus(s.manager.status.ManagerStatus)
+	s.manager.status.RUnlock()
+	utils.HttpResponseOKWithBody(w, status)
+}
+
+func (s *HttpServer) cancel(w http.ResponseWriter, req *http.Request) {
+	cancelReq := apiv1.CancelRequest{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&cancelReq); err != nil && err != io.EOF {
+			utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("invalid cancel request: %v", err))
+			return
+		}
+	}
+	if err := s.manager.Cancel(cancelReq.Partial); err != nil {
+		utils.HttpResponseError(w, http.StatusConflict, err)
+		return
+	}
+	utils.HttpResponseStatus(w, http.StatusAccepted)
+}
+
+func getOpenAPI(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(apiv1.OpenAPI)
+}
+
 func (s *HttpServer) getBundle(w http.ResponseWriter, req *http.Request) {
 	bundleFile := s.manager.getBundlefile()
 	f, err := os.Open(bundleFile)
@@ -50,14 +83,15 @@ func (s *HttpServer) getBundle(w http.ResponseWriter, req *http.Request) {
 		utils.HttpResponseError(w, http.StatusNotFound, fmt.Errorf("fail to stat bundle file: %v", err))
 		return
 	}
+	if fstat.IsDir() {
+		utils.HttpResponseError(w, http.StatusNotFound, errors.New("bundle is not packaged yet"))
+		return
+	}
 
+	// ServeContent handles Range requests, interrupted downloads can resume
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Length", strconv.FormatInt(fstat.Size(), 10))
 	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(bundleFile))
-	if _, err := io.Copy(w, f); err != nil {
-		utils.HttpResponseError(w, http.StatusInternalServerError, err)
-		return
-	}
+	http.ServeContent(w, req, filepath.Base(bundleFile), fstat.ModTime(), f)
 }
 
 func (s *HttpServer) createNodeBundle(w http.ResponseWriter, req *http.Request) {
@@ -99,16 +133,121 @@ func (s *HttpServer) createNodeBundle(w http.ResponseWriter, req *http.Request)
 	utils.HttpResponseStatus(w, http.StatusCreated)
 }
 
-func (s *HttpServer) Run(m *SupportBundleManager) {
-	defaultTimeout := 24 * time.Hour
+// streamEvents streams collection events as Server-Sent Events. A consumer
+// reconnecting with Last-Event-ID resumes after the last event it got.
+func (s *HttpServer) streamEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.HttpResponseError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	var lastID int64
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %s", id))
+			return
+		}
+	}
 
+	backlog, events, unsubscribe := s.manager.progress.Subscribe(lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.context.Done():
+			return
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// fell behind, the consumer reconnects and resumes from the history
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, event types.ManagerEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, b)
+	return err
+}
+
+// bundleRoute is an endpoint of a single collection, relative to the v1 prefix
+type bundleRoute struct {
+	method  string
+	path    string
+	role    apiRole
+	handler func(*HttpServer, http.ResponseWriter, *http.Request)
+}
+
+var bundleRoutes = []bundleRoute{
+	{"GET", "/status", apiRoleConsumer, (*HttpServer).getStatusV1},
+	{"GET", "/bundle", apiRoleConsumer, (*HttpServer).getBundle},
+	{"GET", "/events", apiRoleConsumer, (*HttpServer).streamEvents},
+	{"GET", "/tree", apiRoleConsumer, (*HttpServer).listFiles},
+	{"GET", "/tree/{path:.+}", apiRoleConsumer, (*HttpServer).listFiles},
+	{"GET", "/files/{path:.+}", apiRoleConsumer, (*HttpServer).getFile},
+	{"POST", "/cancel", apiRoleConsumer, (*HttpServer).cancel},
+	{"POST", "/nodes/{nodeName}", apiRoleAgent, (*HttpServer).createNodeBundle},
+}
+
+// newRouter serves the v1 API, and the unversioned endpoints for consumers and
+// agents of older releases
+func (s *HttpServer) newRouter(auth *apiAuth) *mux.Router {
 	r := mux.NewRouter()
 	r.UseEncodedPath()
 
-	r.Path("/status").Methods("GET").HandlerFunc(s.getStatus)
-	r.Path("/bundle").Methods("GET").HandlerFunc(s.getBundle)
-	r.Path("/nodes/{nodeName}").Methods("POST").HandlerFunc(s.createNodeBundle)
+	for _, route := range bundleRoutes {
+		handler := route.handler
+		r.Path(apiv1.PathPrefix + route.path).Methods(route.method).HandlerFunc(auth.require(route.role, func(w http.ResponseWriter, req *http.Request) {
+			handler(s, w, req)
+		}))
+	}
+	r.Path(apiv1.OpenAPIPath).Methods("GET").HandlerFunc(getOpenAPI)
+
+	r.Path("/status").Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.getStatus))
+	r.Path("/bundle").Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.getBundle))
+	r.Path("/events").Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.streamEvents))
+	r.Path("/nodes/{nodeName}").Methods("POST").HandlerFunc(auth.require(apiRoleAgent, s.createNodeBundle))
+	return r
+}
 
+func (s *HttpServer) Run(m *SupportBundleManager) error {
+	return serveAPI(s.newRouter(m.auth), m.auth)
+}
+
+// serveAPI listens right away, so a port already in use fails the caller, and
+// serves in the background
+func serveAPI(r http.Handler, auth *apiAuth) error {
+	defaultTimeout := 24 * time.Hour
 	server := &http.Server{
 		Addr:           ":" + ManagerPort,
 		Handler:        r,
@@ -116,5 +255,27 @@ func (s *HttpServer) Run(m *SupportBundleManager) {
 		WriteTimeout:   defaultTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
-	_ = server.ListenAndServe()
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "fail to listen on %s", server.Addr)
+	}
+	if auth != nil {
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{*auth.cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	go func() {
+		var err error
+		if auth != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Error("Manager API server stopped")
+		}
+	}()
+	return nil
 }
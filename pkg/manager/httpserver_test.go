@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func newTestAPIServer(t *testing.T) (*SupportBundleManager, *apiAuth, *httptest.Server) {
+	auth, err := newAPIAuth("127.0.0.1")
+	assert.Nil(t, err)
+
+	m := &SupportBundleManager{
+		OutputDir:      t.TempDir(),
+		bundleFileName: "supportbundle_test.zip",
+		ch:             make(chan struct{}),
+		auth:           auth,
+	}
+	m.initNodeMaps()
+	s := &HttpServer{context: context.Background(), manager: m}
+
+	server := httptest.NewUnstartedServer(s.newRouter(auth))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{*auth.cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return m, auth, server
+}
+
+func newTestZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("node1/logs/dmesg.log")
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("boot"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestClientV1(t *testing.T) {
+	m, auth, server := newTestAPIServer(t)
+	ctx := context.Background()
+
+	m.status.SetPhase(types.ManagerPhasePackaging)
+	m.status.SetProgress(80)
+	m.status.SetFileinfo("supportbundle_test.zip", 4)
+	assert.Nil(t, os.WriteFile(m.getBundlefile(), []byte("test"), 0644))
+
+	consumer, err := apiv1.NewClient(server.URL, apiv1.WithCA(auth.caPEM), apiv1.WithToken(auth.consumerToken))
+	assert.Nil(t, err)
+	status, err := consumer.Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &apiv1.Status{Phase: types.ManagerPhasePackaging, Progress: 80, FileName: "supportbundle_test.zip", FileSize: 4}, status)
+
+	var buf bytes.Buffer
+	name, size, err := consumer.Download(ctx, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "supportbundle_test.zip", name)
+	assert.Equal(t, int64(4), size)
+	assert.Equal(t, "test", buf.String())
+
+	// the consumer token can't upload node bundles
+	err = consumer.UploadNode(ctx, "node1", bytes.NewReader(newTestZip(t)))
+	apiErr := &apiv1.APIError{}
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+
+	m.expectedNodes["node1"] = NodeCollectionModeAgent
+	m.expectedNodes["node2"] = NodeCollectionModeAgent
+	agent, err := apiv1.NewClient(server.URL, apiv1.WithCA(auth.caPEM), apiv1.WithToken(auth.agentToken))
+	assert.Nil(t, err)
+	assert.Nil(t, agent.UploadNode(ctx, "node1", bytes.NewReader(newTestZip(t))))
+	assert.FileExists(t, filepath.Join(m.getWorkingDir(), "nodes", "node1.zip"))
+	assert.NotContains(t, m.expectedNodes, "node1")
+
+	err = agent.UploadNode(ctx, "node2", bytes.NewReader([]byte("not a zip")))
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Len(t, apiErr.Errors, 1)
+
+	// the agent token can't read the status
+	_, err = agent.Status(ctx)
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestLegacyStatus(t *testing.T) {
+	m, auth, server := newTestAPIServer(t)
+	m.status.SetPhase(types.ManagerPhaseInit)
+
+	client := server.Client()
+	req, err := http.NewRequest("GET", server.URL+"/status", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+auth.consumerToken)
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), `"Phase":"init"`)
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, _, server := newTestAPIServer(t)
+	resp, err := server.Client().Get(server.URL + apiv1.OpenAPIPath)
+	assert.Nil(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/yaml", resp.Header.Get("Content-Type"))
+}
+
+func TestServeAPIPortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", ":"+ManagerPort)
+	if err != nil {
+		t.Skipf("port %s isn't free: %v", ManagerPort, err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	// the bind error fails the caller instead of being dropped
+	err = serveAPI(http.NewServeMux(), nil)
+	assert.ErrorContains(t, err, "fail to listen on :"+ManagerPort)
+}
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
@@ -9,6 +10,7 @@ import (
 )
 
 type LocalStore struct {
+	sync.RWMutex
 	sbs map[string]*types.SupportBundle
 }
 
@@ -40,11 +42,15 @@ func (s *LocalStore) getSb(namespace, supportbundle string) (*types.SupportBundl
 }
 
 func (s *LocalStore) GetSupportBundle(namespace, supportbundle string) (*types.SupportBundle, error) {
+	s.RLock()
+	defer s.RUnlock()
 	logrus.Debugf("Get supportbundle %s/%s", namespace, supportbundle)
 	return s.getSb(namespace, supportbundle)
 }
 
 func (s *LocalStore) GetState(namespace, supportbundle string) (types.SupportBundleState, error) {
+	s.RLock()
+	defer s.RUnlock()
 	sb, err := s.getSb(namespace, supportbundle)
 	if err != nil {
 		return "", err
@@ -52,3 +58,34 @@ func (s *LocalStore) GetState(namespace, supportbundle string) (types.SupportBun
 	logrus.Debugf("Get supportbundle %s/%s state %s", namespace, supportbundle, sb.Status.State)
 	return sb.Status.State, nil
 }
+
+func (s *LocalStore) SetState(namespace, supportbundle string, state types.SupportBundleState) error {
+	s.Lock()
+	defer s.Unlock()
+	sb, err := s.getSb(namespace, supportbundle)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Set supportbundle %s/%s state %s", namespace, supportbundle, state)
+	sb.Status.State = state
+	return nil
+}
+
+// AddSupportBundle starts tracking another supportbundle, in service mode one
+// store holds all collections
+func (s *LocalStore) AddSupportBundle(namespace, supportbundle string) {
+	s.Lock()
+	defer s.Unlock()
+	logrus.Debugf("Add supportbundle %s/%s to the local state store", namespace, supportbundle)
+	s.sbs[getSupportBundleKey(namespace, supportbundle)] = &types.SupportBundle{
+		Status: types.SupportBundleStatus{
+			State: types.SupportBundleStateGenerating,
+		},
+	}
+}
+
+func (s *LocalStore) RemoveSupportBundle(namespace, supportbundle string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sbs, getSupportBundleKey(namespace, supportbundle))
+}
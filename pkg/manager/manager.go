@@ -3,12 +3,11 @@ package manager
 import (
 	"archive/zip"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,10 +17,10 @@ import (
 	"github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/rest"
 
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
 	"github.com/rancher/support-bundle-kit/pkg/manager/client"
 	"github.com/rancher/support-bundle-kit/pkg/types"
 	"github.com/rancher/support-bundle-kit/pkg/utils"
@@ -43,34 +42,90 @@ type SupportBundleManager struct {
 	NodeSelector         string
 	TaintToleration      string
 	RegistrySecret       string
+	AgentTemplate        string
+	AgentSecurityProfile string
 	IssueURL             string
 	Description          string
 	NodeTimeout          time.Duration
+	NodeCollectionMode   string
+	SecureAPI            bool
+	StateDir             string
 
 	ExcludeResources    []schema.GroupResource
 	ExcludeResourceList []string
 	BundleCollectors    []string
 	SpecifyCollector    string
 
+	// Phases selects the phases to run, SkipPhases skips phases and
+	// PhaseOptions tunes timeouts, retries and failure handling per phase
+	Phases       []string
+	SkipPhases   []string
+	PhaseOptions string
+
+	// Prometheus tells where the Prometheus is and what to collect from it
+	Prometheus PrometheusOptions
+	// MetricsSamples of metrics-server are taken MetricsInterval apart
+	MetricsSamples  int
+	MetricsInterval time.Duration
+	// ExternalBundles runs the external bundle phase without naming it in
+	// Phases
+	ExternalBundles bool
+	// ExternalBundleTimeout bounds the wait for the bundles of other
+	// products, e.g. Longhorn
+	ExternalBundleTimeout time.Duration
+	// ExternalBundleProviders is a YAML file of HTTP providers of external
+	// bundles, added to the built-in ones
+	ExternalBundleProviders string
+
 	context context.Context
+	// collectionContext is cancelled to abort the collection
+	collectionContext context.Context
+	cancelCollection  context.CancelFunc
+	cancelLock        sync.Mutex
+	cancelled         bool
+	cancelPartial     bool
 
 	restConfig *rest.Config
 	k8s        *client.KubernetesClient
 	k8sMetrics *client.MetricsClient
 	discovery  *client.DiscoveryClient
+	dynamic    *client.DynamicClient
 
-	state  StateStoreInterface
-	status ManagerStatus
+	state    StateStoreInterface
+	status   ManagerStatus
+	progress ProgressBroker
 
 	ch            chan struct{}
 	done          bool
 	nodesLock     sync.Mutex
 	expectedNodes map[string]string
-}
-
-type RunPhase struct {
-	Name types.ManagerPhase
-	Run  func() error
+	failedNodes   map[string]string
+	knownNodes    map[string]struct{}
+
+	nodeProxySlots chan struct{}
+	// nodeCollectors are the running node collections through the API server
+	nodeCollectors sync.WaitGroup
+
+	agentTemplatePatch []byte
+
+	// embedded collections are run by a Service, which serves their API
+	embedded bool
+	// nodeUploadPath is where agents upload node bundles to
+	nodeUploadPath string
+	authSecretName string
+	// managerBundleName labels the manager pod, collections of a service
+	// run in the pod of the service
+	managerBundleName string
+	// trigger is the condition that requested an embedded collection
+	trigger *apiv1.Trigger
+
+	bundleMeta   *BundleMeta
+	phaseResults []PhaseResult
+	checkpoint   *checkpointStore
+
+	auth *apiAuth
+	// serving is set once the API server started, init may be retried
+	serving bool
 }
 
 func (m *SupportBundleManager) check() error {
@@ -80,17 +135,44 @@ func (m *SupportBundleManager) check() error {
 	if m.BundleName == "" {
 		return errors.New("support bundle name is not specified")
 	}
-	if m.ManagerPodIP == "" {
-		return errors.New("manager pod IP is not specified")
+	switch m.NodeCollectionMode {
+	case "":
+		m.NodeCollectionMode = NodeCollectionModeAgent
+	case NodeCollectionModeAgent, NodeCollectionModeAPIServer:
+	default:
+		return fmt.Errorf("invalid node collection mode %s", m.NodeCollectionMode)
 	}
-	if m.ImageName == "" {
-		return errors.New("image name is not specified")
+	if m.AgentSecurityProfile == "" {
+		m.AgentSecurityProfile = AgentSecurityProfileFull
 	}
-	if m.ImagePullPolicy == "" {
-		return errors.New("image pull policy is not specified")
+	if !isValidAgentSecurityProfile(m.AgentSecurityProfile) {
+		return fmt.Errorf("invalid agent security profile %s", m.AgentSecurityProfile)
+	}
+	// the API server mode doesn't spawn agents
+	if m.NodeCollectionMode == NodeCollectionModeAgent {
+		if m.ManagerPodIP == "" {
+			return errors.New("manager pod IP is not specified")
+		}
+		if m.ImageName == "" {
+			return errors.New("image name is not specified")
+		}
+		if m.ImagePullPolicy == "" {
+			return errors.New("image pull policy is not specified")
+		}
+	}
+	if m.AgentTemplate != "" {
+		patch, err := loadAgentTemplate(m.AgentTemplate)
+		if err != nil {
+			return err
+		}
+		m.agentTemplatePatch = patch
 	}
 	if m.OutputDir == "" {
 		m.OutputDir = filepath.Join(os.TempDir(), "support-bundle-kit")
+		// keep the bundle next to the checkpoint to survive restarts
+		if m.StateDir != "" {
+			m.OutputDir = m.StateDir
+		}
 	}
 	if err := os.MkdirAll(m.getWorkingDir(), os.FileMode(0755)); err != nil {
 		return err
@@ -115,101 +197,135 @@ func (m *SupportBundleManager) getBundlefilesize() (int64, error) {
 }
 
 func (m *SupportBundleManager) Run() error {
-	requiredPhases := []RunPhase{
+	if err := m.Collect(); err != nil {
+		return err
+	}
+
+	<-m.context.Done()
+	return nil
+}
+
+// Collect runs the phases of the collection and returns when it's finished
+func (m *SupportBundleManager) Collect() error {
+	phases, err := m.resolvePhases()
+	if err != nil {
+		return err
+	}
+
+	m.runPhases(phases)
+	return nil
+}
+
+func (m *SupportBundleManager) resolvePhases() ([]RunPhase, error) {
+	registry, err := m.newPhaseRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if err := registry.Configure(m.PhaseOptions); err != nil {
+		return nil, err
+	}
+	return registry.Resolve(m.Phases, m.SkipPhases)
+}
+
+// newPhaseRegistry registers the built-in phases. init, packaging and done
+// always run, the other phases can be selected or skipped.
+func (m *SupportBundleManager) newPhaseRegistry() (*PhaseRegistry, error) {
+	registry := NewPhaseRegistry()
+	for _, phase := range []RunPhase{
 		{
-			types.ManagerPhaseInit,
-			m.phaseInit,
+			Name:      types.ManagerPhaseInit,
+			Run:       m.phaseInit,
+			Mandatory: true,
+			Rerun:     true,
 		},
 		{
-			types.ManagerPhaseClusterBundle,
-			m.phaseCollectClusterBundle,
+			Name:      types.ManagerPhaseClusterBundle,
+			Run:       m.phaseCollectClusterBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
 		},
-
 		{
-			types.ManagerPhaseNodeBundle,
-			m.phaseCollectNodeBundles,
+			Name:      types.ManagerPhaseNodeBundle,
+			Run:       m.phaseCollectNodeBundles,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
 		},
-	}
-
-	// optionalPhases should have independent phases
-	// if logic is dependent, put it into one function
-	optionalPhases := []RunPhase{
 		{
-			types.ManagerPhasePrometheusBundle,
-			m.phaseCollectPrometheusBundle,
+			Name:      types.ManagerPhasePrometheusBundle,
+			Run:       m.phaseCollectPrometheusBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
 		},
-	}
-
-	postPhases := []RunPhase{
 		{
-			types.ManagerPhasePackaging,
-			m.phasePackaging,
+			Name:      types.ManagerPhaseMetricsBundle,
+			Run:       m.phaseCollectMetricsBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
 		},
 		{
-			types.ManagerPhaseDone,
-			m.phaseDone,
+			Name:      types.ManagerPhaseEventsBundle,
+			Run:       m.phaseCollectEventsBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
 		},
-	}
-
-	m.runAllPhases(requiredPhases, optionalPhases, postPhases)
-
-	<-m.context.Done()
-	return nil
-}
-
-func (m *SupportBundleManager) runAllPhases(requiredPhases []RunPhase, optionalPhases []RunPhase, postPhases []RunPhase) {
-	progressCount := 0
-	maxProgressCount := len(requiredPhases) + len(optionalPhases) + len(postPhases)
-
-	for _, phase := range requiredPhases {
-		if err := m.runPhase(phase, &progressCount, maxProgressCount, true); err != nil {
-			logrus.Errorf("Failed to run requiredPhases %s: %s", phase.Name, err.Error())
-			return
-		}
-	}
-
-	for _, phase := range optionalPhases {
-		if err := m.runPhase(phase, &progressCount, maxProgressCount, false); err != nil {
-			logrus.Errorf("Failed to run optionalPhases %s: %s, but error is skiped", phase.Name, err.Error())
-			// Since it's optional, don't return error.
-			continue
-		}
-	}
-
-	for _, phase := range postPhases {
-		if err := m.runPhase(phase, &progressCount, maxProgressCount, true); err != nil {
-			logrus.Errorf("Failed to run postPhases %s: %s", phase.Name, err.Error())
-			return
+		{
+			Name:      types.ManagerPhaseDescribeBundle,
+			Run:       m.phaseDescribeBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
+		},
+		{
+			Name:      types.ManagerPhaseControlPlaneBundle,
+			Run:       m.phaseCollectControlPlaneBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
+		},
+		{
+			Name:      types.ManagerPhaseHelmBundle,
+			Run:       m.phaseCollectHelmBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
+		},
+		{
+			Name:      types.ManagerPhaseCertificatesBundle,
+			Run:       m.phaseCollectCertificatesBundle,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
+		},
+		{
+			Name:      types.ManagerPhaseExternalBundle,
+			Run:       m.phaseCollectExternalBundles,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Soft:      true,
+			// it creates resources in other products and may wait long
+			OptIn: !m.ExternalBundles,
+		},
+		{
+			Name:      types.ManagerPhasePackaging,
+			Run:       m.phasePackaging,
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Mandatory: true,
+		},
+		{
+			Name:      types.ManagerPhaseDone,
+			Run:       m.phaseDone,
+			DependsOn: []types.ManagerPhase{types.ManagerPhasePackaging},
+			Mandatory: true,
+			Rerun:     true,
+		},
+	} {
+		if err := registry.Register(phase); err != nil {
+			return nil, err
 		}
 	}
+	return registry, nil
 }
 
-func (m *SupportBundleManager) runPhase(phase RunPhase, progressCount *int, maxProgressCount int, setError bool) error {
-	logrus.Infof("Running phase %s", phase.Name)
-	m.status.SetPhase(phase.Name)
-
-	err := phase.Run()
-	if err != nil {
-		if setError {
-			m.status.SetError(err.Error())
-			logrus.Errorf("Failed to run phase %s: %s", phase.Name, err.Error())
-			return err
+func (m *SupportBundleManager) phaseInit(ctx context.Context) error {
+	// Init default collector, added once when init is retried
+	for _, collector := range []string{"cluster", "default"} {
+		if !slices.Contains(m.BundleCollectors, collector) {
+			m.BundleCollectors = append(m.BundleCollectors, collector)
 		}
 	}
-
-	*progressCount++
-	progress := 100 * (*progressCount) / maxProgressCount
-	m.status.SetProgress(progress)
-
-	if err == nil {
-		logrus.Infof("Succeed to run phase %s. Progress (%d).", phase.Name, progress)
-	}
-	return err
-}
-
-func (m *SupportBundleManager) phaseInit() error {
-	// Init default collector
-	m.BundleCollectors = append(m.BundleCollectors, "cluster", "default")
 	m.ExcludeResources = []schema.GroupResource{
 		// Default exclusion
 		{Group: v1.GroupName, Resource: "secrets"},
@@ -223,13 +339,32 @@ func (m *SupportBundleManager) phaseInit() error {
 	if err := m.check(); err != nil {
 		return err
 	}
-
-	m.context = signals.SetupSignalContext()
-	err := m.initClients()
+	resumed, err := m.initCheckpoint()
 	if err != nil {
 		return err
 	}
 
+	// a service passes its context to the collections it runs
+	if m.context == nil {
+		m.context = signals.SetupSignalContext()
+	}
+	m.cancelLock.Lock()
+	m.collectionContext, m.cancelCollection = context.WithCancel(m.context)
+	cancelled := m.cancelled
+	m.cancelLock.Unlock()
+	if cancelled {
+		return errCollectionCancelled
+	}
+	// the collection context doesn't exist before init, so ctx of init
+	// isn't cancelled with the collection
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(m.collectionContext, cancel)()
+
+	if err := m.initClients(); err != nil {
+		return err
+	}
+
 	m.PodNamespace = utils.PodNamespace()
 
 	m.initStateStore()
@@ -242,75 +377,68 @@ func (m *SupportBundleManager) phaseInit() error {
 		return fmt.Errorf("invalid start state %s", state)
 	}
 
-	// create a http server to
-	// (1) provide status to controller
-	// (2) accept node bundles from agent daemonset
-	s := HttpServer{
-		context: m.context,
-		manager: m,
-	}
-
-	go s.Run(m)
-
-	return nil
-}
-
-func (m *SupportBundleManager) phaseCollectClusterBundle() error {
-	cluster := NewCluster(m.context, m)
-	bundleName, err := cluster.GenerateClusterBundle(m.getWorkingDir())
-	if err != nil {
-		return errors.Wrap(err, "fail to generate cluster bundle")
-	}
-	m.bundleFileName = bundleName
-	return nil
-}
-
-func (m *SupportBundleManager) phaseCollectPrometheusBundle() error {
-	pods, err := m.k8s.GetPodsListByLabels("cattle-monitoring-system", "app.kubernetes.io/name=prometheus")
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			logrus.Info("prometheus pods not found")
-			return nil
+	if resumed {
+		if err := m.removeLeftovers(ctx); err != nil {
+			return errors.Wrap(err, "fail to remove leftovers of the previous manager")
 		}
-
-		return errors.Wrap(err, "failed to get prometheus pods")
-	}
-
-	if len(pods.Items) == 0 {
-		logrus.Info("prometheus pods not found")
-		return nil
+	} else {
+		if m.bundleMeta, err = m.newBundleMeta(); err != nil {
+			return err
+		}
+		m.bundleFileName = m.getBundleFileNameFor(m.bundleMeta)
+		m.checkpoint.setBundle(m.bundleMeta, m.bundleFileName)
+		m.writeMetadata()
 	}
 
-	if len(pods.Items) > 1 {
-		return fmt.Errorf("multiple %d prometheus pods found", len(pods.Items))
+	if m.SecureAPI && !m.embedded {
+		// a retried init keeps the credentials the API server is started with
+		if m.auth == nil {
+			if m.auth, err = newAPIAuth(m.ManagerPodIP); err != nil {
+				return errors.Wrap(err, "fail to generate manager API credentials")
+			}
+		}
+		if err := m.createAuthSecret(ctx); err != nil {
+			return errors.Wrap(err, "fail to create manager API credentials secret")
+		}
 	}
 
-	targetPod := pods.Items[0]
-	p, err := utils.NewPrometheus(targetPod.Status.PodIP)
-	if err != nil {
-		logrus.Debugf("host: %s, port: %d", targetPod.Status.PodIP, utils.PrometheusPort)
-		return errors.Wrap(err, "failed to new prometheus")
-	}
+	// create a http server to
+	// (1) provide status to controller
+	// (2) accept node bundles from agent daemonset
+	// a service serves the API of its collections itself
+	if !m.embedded && !m.serving {
+		s := HttpServer{
+			context: m.context,
+			manager: m,
+		}
 
-	alerts, err := p.GetAlerts(m.context)
-	if err != nil {
-		return errors.Wrap(err, "failed to get prometheus alert")
+		if err := s.Run(m); err != nil {
+			return errors.Wrap(err, "fail to serve the manager API")
+		}
+		m.serving = true
 	}
 
-	b, err := json.MarshalIndent(alerts, "", "\t")
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal prometheus alert")
+	// the collection was cancelled before the manager restarted
+	if cancelled, partial := m.checkpoint.getCancelled(); cancelled {
+		m.cancelLock.Lock()
+		m.cancelled = true
+		m.cancelPartial = partial
+		m.cancelLock.Unlock()
+		return errCollectionCancelled
 	}
+	return nil
+}
 
-	if err := os.WriteFile(fmt.Sprintf("%s/prometheus-alerts.json", m.getWorkingDir()), b, 0644); err != nil {
-		return errors.Wrap(err, "failed to write prometheus alert")
+func (m *SupportBundleManager) phaseCollectClusterBundle(ctx context.Context) error {
+	cluster := NewCluster(ctx, m)
+	if err := cluster.GenerateClusterBundle(m.getWorkingDir()); err != nil {
+		return errors.Wrap(err, "fail to generate cluster bundle")
 	}
-
 	return nil
 }
 
-func (m *SupportBundleManager) phaseCollectNodeBundles() error {
-	err := m.collectNodeBundles()
+func (m *SupportBundleManager) phaseCollectNodeBundles(ctx context.Context) error {
+	err := m.collectNodeBundles(ctx)
 	if err != nil {
 		// Ignore error here, since in some failure cases we might not receive all node bundles.
 		// A support bundle with partital data is also useful.
@@ -319,11 +447,14 @@ func (m *SupportBundleManager) phaseCollectNodeBundles() error {
 	return nil
 }
 
-func (m *SupportBundleManager) phasePackaging() error {
-	return m.compressBundle()
+func (m *SupportBundleManager) phasePackaging(ctx context.Context) error {
+	// record the phase outcomes so far
+	m.writeMetadata()
+	return m.compressBundle(ctx)
 }
 
-func (m *SupportBundleManager) phaseDone() error {
+func (m *SupportBundleManager) phaseDone(_ context.Context) error {
+	m.setState(types.SupportBundleStateReady)
 	logrus.Infof("Support bundle %s ready to download", m.getBundlefile())
 	return nil
 }
@@ -335,17 +466,22 @@ func (m *SupportBundleManager) initClients() error {
 		return err
 	}
 
-	m.k8s, err = client.NewKubernetesClient(m.context, m.restConfig)
+	m.k8s, err = client.NewKubernetesClient(m.collectionContext, m.restConfig)
+	if err != nil {
+		return err
+	}
+
+	m.k8sMetrics, err = client.NewMetricsClient(m.collectionContext, m.restConfig)
 	if err != nil {
 		return err
 	}
 
-	m.k8sMetrics, err = client.NewMetricsClient(m.context, m.restConfig)
+	m.discovery, err = client.NewDiscoveryClient(m.collectionContext, m.restConfig)
 	if err != nil {
 		return err
 	}
 
-	m.discovery, err = client.NewDiscoveryClient(m.context, m.restConfig)
+	m.dynamic, err = client.NewDynamicClient(m.collectionContext, m.restConfig)
 	if err != nil {
 		return err
 	}
@@ -353,27 +489,55 @@ func (m *SupportBundleManager) initClients() error {
 }
 
 func (m *SupportBundleManager) initStateStore() {
-	m.state = NewLocalStore(m.PodNamespace, m.BundleName)
+	// a service shares its store with the collections it runs
+	if m.state == nil {
+		m.state = NewLocalStore(m.PodNamespace, m.BundleName)
+	}
+}
+
+func (m *SupportBundleManager) setState(state types.SupportBundleState) {
+	if m.state == nil {
+		return
+	}
+	if err := m.state.SetState(m.PodNamespace, m.BundleName, state); err != nil {
+		logrus.WithError(err).Error("Failed to set support bundle state")
+	}
 }
 
 // collectNodeBundles spawns a daemonset on each node and waits for agents on
 // each node to push node bundles
-func (m *SupportBundleManager) collectNodeBundles() error {
+func (m *SupportBundleManager) collectNodeBundles(ctx context.Context) error {
 	m.ch = make(chan struct{})
+	m.nodeProxySlots = make(chan struct{}, nodeProxyConcurrency)
+
+	// collections through the API server end with the node phase, none of
+	// them writes to the bundle after it returns
+	ctx, stopNodeCollectors := context.WithCancel(ctx)
+	defer func() {
+		stopNodeCollectors()
+		m.nodeCollectors.Wait()
+	}()
+
+	if m.NodeCollectionMode == NodeCollectionModeAPIServer {
+		return m.collectNodeBundlesViaAPIServer(ctx)
+	}
 
 	// create a daemonset to collect node bundles and push back
 	agents := &AgentDaemonSet{sbm: m}
-	agentDaemonSet, err := agents.Create(m.ImageName, fmt.Sprintf("http://%s", net.JoinHostPort(m.ManagerPodIP, ManagerPort)))
+	agentDaemonSet, err := agents.Create(m.ImageName, m.getManagerURL())
 	if err != nil {
 		return err
 	}
 
-	err = m.refreshNodes(agentDaemonSet)
+	err = m.refreshNodes(ctx, agentDaemonSet)
 	if err != nil {
 		return err
 	}
 
-	m.waitNodesCompleted()
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go m.watchAgentPods(watchCtx, agentDaemonSet)
+	m.waitNodesCompleted(ctx, agentDaemonSet)
+	stopWatch()
 
 	// Clean up when everything is fine. If something went wrong, keep ds for debugging.
 	// The ds will be garbage-collected when manager pod is gone.
@@ -384,6 +548,34 @@ func (m *SupportBundleManager) collectNodeBundles() error {
 	return nil
 }
 
+// collectNodeBundlesViaAPIServer collects node bundles from all selected nodes
+// through the API server's node proxy, without deploying agents
+func (m *SupportBundleManager) collectNodeBundlesViaAPIServer(ctx context.Context) error {
+	nodes, err := m.getTargetNodeNames(nil)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return errors.New("no nodes are found")
+	}
+
+	m.nodesLock.Lock()
+	m.initNodeMaps()
+	for _, name := range nodes {
+		node, err := m.k8s.GetNodeBy(name)
+		if err != nil {
+			m.nodesLock.Unlock()
+			return err
+		}
+		m.trackNode(ctx, node)
+	}
+	m.checkNodesCompleted()
+	m.nodesLock.Unlock()
+
+	m.waitNodesCompleted(ctx, nil)
+	return nil
+}
+
 func (m *SupportBundleManager) verifyNodeBundle(file string) error {
 	f, err := zip.OpenReader(file)
 	if err == nil {
@@ -393,18 +585,47 @@ func (m *SupportBundleManager) verifyNodeBundle(file string) error {
 }
 
 func (m *SupportBundleManager) printTimeoutNodes() {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
 	for node := range m.expectedNodes {
 		logrus.Warnf("Collection timed out for node: %s", node)
 	}
 }
 
-func (m *SupportBundleManager) waitNodesCompleted() {
-	select {
-	case <-m.ch:
-		logrus.Info("All node bundles are received.")
-	case <-m.timeout():
-		logrus.Info("Some nodes are timeout, not all node bundles are received.")
-		m.printTimeoutNodes()
+func (m *SupportBundleManager) printFailedNodes() {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	for node, reason := range m.failedNodes {
+		logrus.Warnf("Collection failed for node %s: %s", node, reason)
+	}
+}
+
+func (m *SupportBundleManager) waitNodesCompleted(ctx context.Context, agentDaemonSet *appsv1.DaemonSet) {
+	timeout := m.timeout()
+	ticker := time.NewTicker(types.NodeSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ch:
+			logrus.Info("All node bundles are received or failed.")
+			m.printFailedNodes()
+			return
+		case <-timeout:
+			logrus.Info("Some nodes are timeout, not all node bundles are received.")
+			m.printTimeoutNodes()
+			m.printFailedNodes()
+			m.abandonNodes("Timeout")
+			return
+		case <-ctx.Done():
+			logrus.Info("Stop waiting for node bundles, collection is cancelled or timed out.")
+			m.abandonNodes("Cancelled")
+			return
+		case <-ticker.C:
+			m.syncNodes(ctx, agentDaemonSet)
+		}
 	}
 }
 
@@ -417,17 +638,37 @@ func (m *SupportBundleManager) timeout() <-chan time.Time {
 }
 
 func (m *SupportBundleManager) completeNode(node string) {
+	m.removeExpectedNode(node, "")
+}
+
+// removeExpectedNode stops waiting for a node. A non-empty reason means the
+// node failed and will not deliver a bundle.
+func (m *SupportBundleManager) removeExpectedNode(node string, reason string) {
 	m.nodesLock.Lock()
 	defer m.nodesLock.Unlock()
 
 	_, ok := m.expectedNodes[node]
 	if ok {
-		logrus.Debugf("Complete node %s", node)
+		if reason == "" {
+			logrus.Debugf("Complete node %s", node)
+			m.checkpoint.completeNode(node)
+		} else {
+			logrus.Debugf("Fail node %s: %s", node, reason)
+			m.failedNodes[node] = reason
+			m.progress.Warning(node, reason)
+		}
 		delete(m.expectedNodes, node)
+		m.progress.SetStep(types.ManagerStepNodes, int64(len(m.knownNodes)-len(m.expectedNodes)), int64(len(m.knownNodes)))
 	} else {
 		logrus.Warnf("Complete an unknown node %s", node)
 	}
 
+	m.checkNodesCompleted()
+}
+
+// checkNodesCompleted stops waiting once no node bundle is expected. Must be
+// called with nodesLock held.
+func (m *SupportBundleManager) checkNodesCompleted() {
 	if len(m.expectedNodes) == 0 {
 		if !m.done {
 			logrus.Debugf("All nodes are completed")
@@ -437,17 +678,29 @@ func (m *SupportBundleManager) completeNode(node string) {
 	}
 }
 
-func (m *SupportBundleManager) compressBundle() error {
-	bundleDir := strings.TrimSuffix(m.bundleFileName, filepath.Ext(m.getBundlefile()))
-	bundleDirPath := filepath.Join(m.OutputDir, bundleDir)
+// getPackagedDir returns the directory the working dir is moved to for packaging
+func (m *SupportBundleManager) getPackagedDir() string {
+	return filepath.Join(m.OutputDir, strings.TrimSuffix(m.bundleFileName, filepath.Ext(m.bundleFileName)))
+}
+
+func (m *SupportBundleManager) compressBundle(ctx context.Context) error {
+	bundleDirPath := m.getPackagedDir()
+	bundleDir := filepath.Base(bundleDirPath)
 	err := os.Rename(m.getWorkingDir(), bundleDirPath)
 	if err != nil {
 		return errors.Wrap(err, "fail to compress bundle")
 	}
-	cmd := exec.Command("zip", "-r", m.getBundlefile(), bundleDir)
+	cmd := exec.CommandContext(ctx, "zip", "-r", m.getBundlefile(), bundleDir)
 	cmd.Dir = m.OutputDir
+	stopWatch := m.watchBundleSize()
 	err = cmd.Run()
+	stopWatch()
 	if err != nil {
+		// put the working dir back for a retry
+		_ = os.Remove(m.getBundlefile())
+		if err := os.Rename(bundleDirPath, m.getWorkingDir()); err != nil {
+			logrus.WithError(err).Error("Failed to restore the working dir")
+		}
 		return errors.Wrap(err, "fail to compress bundle")
 	}
 
@@ -456,9 +709,32 @@ func (m *SupportBundleManager) compressBundle() error {
 		return errors.Wrap(err, "fail to get bundle file size")
 	}
 	m.status.SetFileinfo(m.bundleFileName, size)
+	m.progress.SetStep(types.ManagerStepBytes, size, size)
 	return nil
 }
 
+// watchBundleSize reports the bytes packaged so far while zip is running
+func (m *SupportBundleManager) watchBundleSize() func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if size, err := m.getBundlefilesize(); err == nil {
+					m.progress.SetStep(types.ManagerStepBytes, size, 0)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
 func (m *SupportBundleManager) getAgentPodsCreatedBy(daemonSet *appsv1.DaemonSet) (*v1.PodList, error) {
 	startTime := time.Now()
 	ticker := time.NewTicker(types.PodCreationWaitInterval)
@@ -467,23 +743,11 @@ func (m *SupportBundleManager) getAgentPodsCreatedBy(daemonSet *appsv1.DaemonSet
 	for range ticker.C {
 		logrus.Debug("Waiting for the creation of agent DaemonSet Pods for scheduled node names collection")
 
-		pods, err := m.k8s.GetPodsListByLabels(m.PodNamespace, fmt.Sprintf("app=%s", types.SupportBundleAgent))
+		filteredPods, err := m.listAgentPods(daemonSet)
 		if err != nil {
 			return nil, err
 		}
 
-		// Filter out pods not created by the current agent DaemonSet or without assigned node names
-		filteredPods := make([]v1.Pod, 0, len(pods.Items))
-		for _, pod := range pods.Items {
-			if len(pod.OwnerReferences) != 1 {
-				return nil, fmt.Errorf("unexpected OwnerReferences in %v: %+v", pod.Name, pod.OwnerReferences)
-			}
-
-			if pod.OwnerReferences[0].Name == daemonSet.Name && pod.Spec.NodeName != "" {
-				filteredPods = append(filteredPods, pod)
-			}
-		}
-
 		// Get the latest agent DaemonSet status
 		daemonSet, err = m.k8s.GetDaemonSetBy(daemonSet.Namespace, daemonSet.Name)
 		if err != nil {
@@ -503,10 +767,31 @@ func (m *SupportBundleManager) getAgentPodsCreatedBy(daemonSet *appsv1.DaemonSet
 	return nil, fmt.Errorf("unexpected error: stopped waiting for creating DaemonSet Pod or timing out")
 }
 
+// listAgentPods lists pods created by the agent DaemonSet with a target node
+func (m *SupportBundleManager) listAgentPods(daemonSet *appsv1.DaemonSet) ([]v1.Pod, error) {
+	pods, err := m.k8s.GetPodsListByLabels(m.PodNamespace, fmt.Sprintf("app=%s", types.SupportBundleAgent))
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter out pods not created by the current agent DaemonSet or without target node names
+	filteredPods := make([]v1.Pod, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if len(pod.OwnerReferences) != 1 {
+			return nil, fmt.Errorf("unexpected OwnerReferences in %v: %+v", pod.Name, pod.OwnerReferences)
+		}
+
+		if pod.OwnerReferences[0].Name == daemonSet.Name && getAgentPodNodeName(&pod) != "" {
+			filteredPods = append(filteredPods, pod)
+		}
+	}
+	return filteredPods, nil
+}
+
 func (m *SupportBundleManager) getAgentNodesIn(podList *v1.PodList) ([]*v1.Node, error) {
 	var nodes []*v1.Node
 	for _, pod := range podList.Items {
-		node, err := m.k8s.GetNodeBy(pod.Spec.NodeName)
+		node, err := m.k8s.GetNodeBy(getAgentPodNodeName(&pod))
 		if err != nil {
 			return nil, err
 		}
@@ -516,7 +801,7 @@ func (m *SupportBundleManager) getAgentNodesIn(podList *v1.PodList) ([]*v1.Node,
 	return nodes, nil
 }
 
-func (m *SupportBundleManager) refreshNodes(agentDaemonSet *appsv1.DaemonSet) error {
+func (m *SupportBundleManager) refreshNodes(ctx context.Context, agentDaemonSet *appsv1.DaemonSet) error {
 	m.nodesLock.Lock()
 	defer m.nodesLock.Unlock()
 
@@ -534,29 +819,24 @@ func (m *SupportBundleManager) refreshNodes(agentDaemonSet *appsv1.DaemonSet) er
 		return errors.New("no nodes are found")
 	}
 
-	m.expectedNodes = make(map[string]string)
+	m.initNodeMaps()
 	defer logrus.Debugf("Expecting bundles from nodes: %+v", m.expectedNodes)
 
-NODE_LOOP:
 	for _, node := range nodes {
-		for _, cond := range node.Status.Conditions {
-			switch cond.Type {
-			case v1.NodeReady:
-				if cond.Status != v1.ConditionTrue {
-					continue NODE_LOOP
-				}
-			case v1.NodeNetworkUnavailable:
-				if cond.Status == v1.ConditionTrue {
-					continue NODE_LOOP
-				}
-			}
-		}
-		m.expectedNodes[node.Name] = ""
+		m.trackNode(ctx, node)
 	}
+	m.checkNodesCompleted()
 
 	return nil
 }
 
+// initNodeMaps resets node tracking. Must be called with nodesLock held.
+func (m *SupportBundleManager) initNodeMaps() {
+	m.expectedNodes = make(map[string]string)
+	m.failedNodes = make(map[string]string)
+	m.knownNodes = make(map[string]struct{})
+}
+
 func (m *SupportBundleManager) getNodeSelector() map[string]string {
 	nodeSelector := map[string]string{}
 	if m.NodeSelector != "" {
@@ -1,8 +1,11 @@
 package manager
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -76,92 +79,59 @@ func TestParseToleration(t *testing.T) {
 	}
 }
 
-func TestRunAllPhases(t *testing.T) {
+func TestRunPhases(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("phase error") }
+	newPhases := func(runs map[types.ManagerPhase]func(context.Context) error) []RunPhase {
+		phases := []RunPhase{
+			{Name: types.ManagerPhaseInit},
+			{Name: types.ManagerPhaseClusterBundle, DependsOn: []types.ManagerPhase{types.ManagerPhaseInit}},
+			{Name: types.ManagerPhasePrometheusBundle, DependsOn: []types.ManagerPhase{types.ManagerPhaseInit}, Soft: true},
+			{Name: types.ManagerPhasePackaging, DependsOn: []types.ManagerPhase{types.ManagerPhaseInit}},
+			{Name: types.ManagerPhaseDone, DependsOn: []types.ManagerPhase{types.ManagerPhasePackaging}},
+		}
+		for i := range phases {
+			phases[i].Run = ok
+			if run, found := runs[phases[i].Name]; found {
+				phases[i].Run = run
+			}
+		}
+		return phases
+	}
+
 	tests := []struct {
 		name             string
-		requiredPhases   []RunPhase
-		optionalPhases   []RunPhase
-		postPhases       []RunPhase
+		phases           []RunPhase
 		expectedError    bool
 		expectedProgress int
 	}{
 		{
-			name: "All pass",
-			requiredPhases: []RunPhase{
-				{Name: types.ManagerPhaseInit, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseClusterBundle, Run: func() error { return nil }},
-			},
-			optionalPhases: []RunPhase{
-				{Name: types.ManagerPhasePrometheusBundle, Run: func() error { return nil }},
-			},
-			postPhases: []RunPhase{
-				{Name: types.ManagerPhasePackaging, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseDone, Run: func() error { return nil }},
-			},
+			name:             "All pass",
+			phases:           newPhases(nil),
 			expectedError:    false,
 			expectedProgress: 100,
 		},
 		{
-			name: "First Required phase error",
-			requiredPhases: []RunPhase{
-				{Name: types.ManagerPhaseInit, Run: func() error { return errors.New("required phase error") }},
-				{Name: types.ManagerPhaseClusterBundle, Run: func() error { return nil }},
-			},
-			optionalPhases: []RunPhase{
-				{Name: types.ManagerPhasePrometheusBundle, Run: func() error { return nil }},
-			},
-			postPhases: []RunPhase{
-				{Name: types.ManagerPhasePackaging, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseDone, Run: func() error { return nil }},
-			},
+			name:             "First hard phase error",
+			phases:           newPhases(map[types.ManagerPhase]func(context.Context) error{types.ManagerPhaseInit: fail}),
 			expectedError:    true,
 			expectedProgress: 0,
 		},
 		{
-			name: "Second Required phase error",
-			requiredPhases: []RunPhase{
-				{Name: types.ManagerPhaseInit, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseClusterBundle, Run: func() error { return errors.New("required phase error") }},
-			},
-			optionalPhases: []RunPhase{
-				{Name: types.ManagerPhasePrometheusBundle, Run: func() error { return nil }},
-			},
-			postPhases: []RunPhase{
-				{Name: types.ManagerPhasePackaging, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseDone, Run: func() error { return nil }},
-			},
+			name:             "Second hard phase error",
+			phases:           newPhases(map[types.ManagerPhase]func(context.Context) error{types.ManagerPhaseClusterBundle: fail}),
 			expectedError:    true,
 			expectedProgress: 20,
 		},
 		{
-			name: "Optional phase error",
-			requiredPhases: []RunPhase{
-				{Name: types.ManagerPhaseInit, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseClusterBundle, Run: func() error { return nil }},
-			},
-			optionalPhases: []RunPhase{
-				{Name: types.ManagerPhasePrometheusBundle, Run: func() error { return errors.New("optional phase error") }},
-			},
-			postPhases: []RunPhase{
-				{Name: types.ManagerPhasePackaging, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseDone, Run: func() error { return nil }},
-			},
+			name:             "Soft phase error",
+			phases:           newPhases(map[types.ManagerPhase]func(context.Context) error{types.ManagerPhasePrometheusBundle: fail}),
 			expectedError:    false,
 			expectedProgress: 100,
 		},
 		{
-			name: "Final Post phase error",
-			requiredPhases: []RunPhase{
-				{Name: types.ManagerPhaseInit, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseClusterBundle, Run: func() error { return nil }},
-			},
-			optionalPhases: []RunPhase{
-				{Name: types.ManagerPhasePrometheusBundle, Run: func() error { return nil }},
-			},
-			postPhases: []RunPhase{
-				{Name: types.ManagerPhasePackaging, Run: func() error { return nil }},
-				{Name: types.ManagerPhaseDone, Run: func() error { return errors.New("post phase error") }},
-			},
+			name:             "Final phase error",
+			phases:           newPhases(map[types.ManagerPhase]func(context.Context) error{types.ManagerPhaseDone: fail}),
 			expectedError:    true,
 			expectedProgress: 80,
 		},
@@ -172,9 +142,33 @@ func TestRunAllPhases(t *testing.T) {
 			m := &SupportBundleManager{
 				status: ManagerStatus{},
 			}
-			m.runAllPhases(tt.requiredPhases, tt.optionalPhases, tt.postPhases)
+			m.runPhases(tt.phases)
 			assert.Equal(t, tt.expectedError, m.status.Error, "expected error %v, got %v")
 			assert.Equal(t, tt.expectedProgress, m.status.Progress, "expected progress %v, got %v")
 		})
 	}
 }
+
+func TestCompressBundleTimeout(t *testing.T) {
+	m := &SupportBundleManager{OutputDir: t.TempDir(), bundleFileName: "supportbundle_uuid.zip"}
+	assert.Nil(t, os.MkdirAll(m.getWorkingDir(), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(m.getWorkingDir(), "metadata.yaml"), []byte("bundlename: sample\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotNil(t, m.compressBundle(ctx))
+	// the working dir is back for a retry
+	assert.FileExists(t, filepath.Join(m.getWorkingDir(), "metadata.yaml"))
+	assert.NoDirExists(t, m.getPackagedDir())
+	assert.NoFileExists(t, m.getBundlefile())
+}
+
+func TestPhaseInitRetryAddsDefaultCollectorsOnce(t *testing.T) {
+	m := &SupportBundleManager{BundleCollectors: []string{"harvester"}}
+
+	// init fails without namespaces and is retried
+	for i := 0; i < 2; i++ {
+		assert.NotNil(t, m.phaseInit(context.Background()))
+	}
+	assert.Equal(t, []string{"harvester", "cluster", "default"}, m.BundleCollectors)
+}
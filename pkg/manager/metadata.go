@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+func (m *SupportBundleManager) newBundleMeta() (*BundleMeta, error) {
+	namespace, err := m.k8s.GetNamespace(m.PodNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get deployed namespace")
+	}
+	kubeVersion, err := m.k8s.GetKubernetesVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get kubernetes version")
+	}
+
+	// the collections of a service are named after their ID
+	bundleID := m.BundleName
+	if !m.embedded {
+		if bundleID, err = newBundleID(); err != nil {
+			return nil, errors.Wrap(err, "cannot generate bundle ID")
+		}
+	}
+
+	bundleMeta := &BundleMeta{
+		BundleName:           m.BundleName,
+		BundleID:             bundleID,
+		BundleVersion:        BundleVersion,
+		KubernetesVersion:    kubeVersion.GitVersion,
+		ProjectNamespaceUUID: string(namespace.UID),
+		BundleCreatedAt:      utils.Now(),
+		IssueURL:             m.IssueURL,
+		IssueDescription:     m.Description,
+		NodeCollectionMode:   m.NodeCollectionMode,
+		Trigger:              m.trigger,
+	}
+	// agents are only deployed in agent mode
+	if m.NodeCollectionMode == NodeCollectionModeAgent {
+		bundleMeta.AgentSecurityProfile = m.AgentSecurityProfile
+	}
+	return bundleMeta, nil
+}
+
+// getBundleFileNameFor names the bundle file after the custom bundle file name
+// if set, otherwise after the namespace UUID
+func (m *SupportBundleManager) getBundleFileNameFor(bundleMeta *BundleMeta) string {
+	bundleIdentifier := bundleMeta.ProjectNamespaceUUID
+	if m.CustomBundleFileName != "" {
+		bundleIdentifier = m.CustomBundleFileName
+	}
+
+	return fmt.Sprintf("supportbundle_%s_%s.zip",
+		bundleIdentifier,
+		strings.ReplaceAll(bundleMeta.BundleCreatedAt, ":", "-"))
+}
+
+// writeMetadata writes metadata.yaml with the outcomes of the phases run so far
+func (m *SupportBundleManager) writeMetadata() {
+	if m.bundleMeta == nil {
+		return
+	}
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to open bundle generation log")
+		return
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	m.bundleMeta.Phases = append([]PhaseResult(nil), m.phaseResults...)
+	encodeToYAMLFile(m.bundleMeta, filepath.Join(m.getWorkingDir(), "metadata.yaml"), errLog)
+}
@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	DefaultMetricsSamples  = 4
+	DefaultMetricsInterval = 15 * time.Second
+
+	metricsDir = "metrics"
+)
+
+// MetricsSnapshot is written to metrics/samples.json. CPU is in millicores
+// and memory in bytes.
+type MetricsSnapshot struct {
+	Interval string          `json:"interval"`
+	Samples  []MetricsSample `json:"samples"`
+}
+
+// MetricsSample is the usage of nodes and pods from metrics-server, with
+// the capacity of the nodes and the requests and limits of the pods at the
+// same time
+type MetricsSample struct {
+	Time  string        `json:"time"`
+	Nodes []NodeMetrics `json:"nodes"`
+	// pods of the bundle namespaces, the requests and limits of nodes
+	// include all pods
+	Pods []PodMetrics `json:"pods"`
+}
+
+type ResourceAmounts struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+type NodeMetrics struct {
+	Name string `json:"name"`
+	// Timestamp and Window of the usage reported by metrics-server
+	Timestamp   string           `json:"timestamp,omitempty"`
+	Window      string           `json:"window,omitempty"`
+	Usage       *ResourceAmounts `json:"usage,omitempty"`
+	Capacity    ResourceAmounts  `json:"capacity"`
+	Allocatable ResourceAmounts  `json:"allocatable"`
+	// sums of the running pods on the node
+	Requests ResourceAmounts `json:"requests"`
+	Limits   ResourceAmounts `json:"limits"`
+}
+
+type PodMetrics struct {
+	Namespace  string             `json:"namespace"`
+	Name       string             `json:"name"`
+	NodeName   string             `json:"nodeName,omitempty"`
+	Phase      corev1.PodPhase    `json:"phase"`
+	Timestamp  string             `json:"timestamp,omitempty"`
+	Window     string             `json:"window,omitempty"`
+	Containers []ContainerMetrics `json:"containers"`
+}
+
+type ContainerMetrics struct {
+	Name     string           `json:"name"`
+	Usage    *ResourceAmounts `json:"usage,omitempty"`
+	Requests ResourceAmounts  `json:"requests"`
+	Limits   ResourceAmounts  `json:"limits"`
+}
+
+func newResourceAmounts(resources corev1.ResourceList) ResourceAmounts {
+	return ResourceAmounts{
+		CPU:    resources.Cpu().MilliValue(),
+		Memory: resources.Memory().Value(),
+	}
+}
+
+func (a *ResourceAmounts) add(b ResourceAmounts) {
+	a.CPU += b.CPU
+	a.Memory += b.Memory
+}
+
+func (m *SupportBundleManager) phaseCollectMetricsBundle(ctx context.Context) error {
+	samples := m.MetricsSamples
+	if samples <= 0 {
+		samples = DefaultMetricsSamples
+	}
+	interval := m.MetricsInterval
+	if interval <= 0 {
+		interval = DefaultMetricsInterval
+	}
+
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	snapshot := MetricsSnapshot{Interval: interval.String()}
+	path := filepath.Join(m.getWorkingDir(), metricsDir, "samples.json")
+	for i := 0; i < samples; i++ {
+		if i > 0 && !sleepContext(ctx, interval) {
+			break
+		}
+		sample, err := m.sampleMetrics(ctx)
+		if apierrors.IsNotFound(err) && i == 0 {
+			logrus.Info("metrics-server not found")
+			return nil
+		}
+		if err != nil {
+			// keep the samples taken so far
+			if len(snapshot.Samples) > 0 {
+				writeJSONFile(path, snapshot, errLog)
+			}
+			return errors.Wrap(err, "failed to sample metrics")
+		}
+		snapshot.Samples = append(snapshot.Samples, *sample)
+		logrus.Debugf("Sampled metrics %d/%d", i+1, samples)
+	}
+	writeJSONFile(path, snapshot, errLog)
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (m *SupportBundleManager) sampleMetrics(ctx context.Context) (*MetricsSample, error) {
+	k8sMetrics, k8s := m.k8sMetrics.WithContext(ctx), m.k8s.WithContext(ctx)
+	nodeMetrics, err := k8sMetrics.GetAllNodeMetrics()
+	if err != nil {
+		return nil, err
+	}
+	podMetrics, err := k8sMetrics.GetAllPodMetrics(corev1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := k8s.GetAllNodesList()
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8s.GetAllPodsList(corev1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	return newMetricsSample(utils.Now(), nodes, pods, nodeMetrics, podMetrics, m.Namespaces)
+}
+
+// newMetricsSample joins the usage from metrics-server with the nodes and
+// pods listed at the same time
+func newMetricsSample(now string, nodeList, podList runtime.Object, nodeMetrics *metricsv1beta1.NodeMetricsList, podMetrics *metricsv1beta1.PodMetricsList, namespaces []string) (*MetricsSample, error) {
+	nodes, ok := nodeList.(*corev1.NodeList)
+	if !ok {
+		return nil, errors.Errorf("unexpected node list %T", nodeList)
+	}
+	pods, ok := podList.(*corev1.PodList)
+	if !ok {
+		return nil, errors.Errorf("unexpected pod list %T", podList)
+	}
+	inNamespaces := map[string]bool{}
+	for _, namespace := range namespaces {
+		inNamespaces[namespace] = true
+	}
+
+	nodeUsage := map[string]*metricsv1beta1.NodeMetrics{}
+	for i := range nodeMetrics.Items {
+		nodeUsage[nodeMetrics.Items[i].Name] = &nodeMetrics.Items[i]
+	}
+	podUsage := map[string]*metricsv1beta1.PodMetrics{}
+	for i := range podMetrics.Items {
+		pod := &podMetrics.Items[i]
+		podUsage[pod.Namespace+"/"+pod.Name] = pod
+	}
+
+	sample := &MetricsSample{Time: now}
+	nodeIndex := map[string]int{}
+	for _, node := range nodes.Items {
+		n := NodeMetrics{
+			Name:        node.Name,
+			Capacity:    newResourceAmounts(node.Status.Capacity),
+			Allocatable: newResourceAmounts(node.Status.Allocatable),
+		}
+		if usage, ok := nodeUsage[node.Name]; ok {
+			amounts := newResourceAmounts(usage.Usage)
+			n.Usage = &amounts
+			n.Timestamp = usage.Timestamp.UTC().Format(time.RFC3339)
+			n.Window = usage.Window.Duration.String()
+		}
+		nodeIndex[node.Name] = len(sample.Nodes)
+		sample.Nodes = append(sample.Nodes, n)
+	}
+
+	for _, pod := range pods.Items {
+		running := pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+		p := PodMetrics{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			NodeName:  pod.Spec.NodeName,
+			Phase:     pod.Status.Phase,
+		}
+		usage := podUsage[pod.Namespace+"/"+pod.Name]
+		if usage != nil {
+			p.Timestamp = usage.Timestamp.UTC().Format(time.RFC3339)
+			p.Window = usage.Window.Duration.String()
+		}
+		for _, container := range pod.Spec.Containers {
+			c := ContainerMetrics{
+				Name:     container.Name,
+				Requests: newResourceAmounts(container.Resources.Requests),
+				Limits:   newResourceAmounts(container.Resources.Limits),
+			}
+			if usage != nil {
+				for _, containerUsage := range usage.Containers {
+					if containerUsage.Name == container.Name {
+						amounts := newResourceAmounts(containerUsage.Usage)
+						c.Usage = &amounts
+					}
+				}
+			}
+			if i, ok := nodeIndex[pod.Spec.NodeName]; ok && running {
+				sample.Nodes[i].Requests.add(c.Requests)
+				sample.Nodes[i].Limits.add(c.Limits)
+			}
+			p.Containers = append(p.Containers, c)
+		}
+		if len(inNamespaces) == 0 || inNamespaces[pod.Namespace] {
+			sample.Pods = append(sample.Pods, p)
+		}
+	}
+
+	sort.Slice(sample.Nodes, func(i, j int) bool {
+		return sample.Nodes[i].Name < sample.Nodes[j].Name
+	})
+	sort.Slice(sample.Pods, func(i, j int) bool {
+		if sample.Pods[i].Namespace != sample.Pods[j].Namespace {
+			return sample.Pods[i].Namespace < sample.Pods[j].Namespace
+		}
+		return sample.Pods[i].Name < sample.Pods[j].Name
+	})
+	return sample, nil
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func newMetricsTestPod(namespace, name, node string, phase corev1.PodPhase, cpu, memory string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(cpu),
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestNewMetricsSample(t *testing.T) {
+	nodes := &corev1.NodeList{Items: []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("4"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("3800m"),
+					corev1.ResourceMemory: resource.MustParse("7Gi"),
+				},
+			},
+		},
+	}}
+	pods := &corev1.PodList{Items: []corev1.Pod{
+		newMetricsTestPod("longhorn-system", "manager", "node1", corev1.PodRunning, "250m", "256Mi"),
+		newMetricsTestPod("kube-system", "coredns", "node1", corev1.PodRunning, "100m", "64Mi"),
+		newMetricsTestPod("longhorn-system", "job", "node1", corev1.PodSucceeded, "1", "1Gi"),
+	}}
+	now := metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	nodeMetrics := &metricsv1beta1.NodeMetricsList{Items: []metricsv1beta1.NodeMetrics{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Timestamp:  now,
+		Window:     metav1.Duration{Duration: 20 * time.Second},
+		Usage: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1500m"),
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+	}}}
+	podMetrics := &metricsv1beta1.PodMetricsList{Items: []metricsv1beta1.PodMetrics{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "longhorn-system", Name: "manager"},
+		Timestamp:  now,
+		Window:     metav1.Duration{Duration: 20 * time.Second},
+		Containers: []metricsv1beta1.ContainerMetrics{{
+			Name: "main",
+			Usage: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("120m"),
+				corev1.ResourceMemory: resource.MustParse("100Mi"),
+			},
+		}},
+	}}}
+
+	sample, err := newMetricsSample("2024-01-02T03:04:10Z", nodes, pods, nodeMetrics, podMetrics, []string{"longhorn-system"})
+	assert.Nil(t, err)
+
+	assert.Len(t, sample.Nodes, 2)
+	node := sample.Nodes[0]
+	assert.Equal(t, "node1", node.Name)
+	assert.Equal(t, &ResourceAmounts{CPU: 1500, Memory: 2 << 30}, node.Usage)
+	assert.Equal(t, "2024-01-02T03:04:05Z", node.Timestamp)
+	assert.Equal(t, "20s", node.Window)
+	assert.Equal(t, ResourceAmounts{CPU: 4000, Memory: 8 << 30}, node.Capacity)
+	assert.Equal(t, ResourceAmounts{CPU: 3800, Memory: 7 << 30}, node.Allocatable)
+	// the succeeded pod doesn't count, the one out of the namespaces does
+	assert.Equal(t, ResourceAmounts{CPU: 350, Memory: 320 << 20}, node.Requests)
+	assert.Equal(t, ResourceAmounts{Memory: 320 << 20}, node.Limits)
+	assert.Equal(t, "node2", sample.Nodes[1].Name)
+	assert.Nil(t, sample.Nodes[1].Usage)
+
+	assert.Len(t, sample.Pods, 2)
+	assert.Equal(t, "job", sample.Pods[0].Name)
+	assert.Nil(t, sample.Pods[0].Containers[0].Usage)
+	pod := sample.Pods[1]
+	assert.Equal(t, "manager", pod.Name)
+	assert.Equal(t, "node1", pod.NodeName)
+	assert.Equal(t, &ResourceAmounts{CPU: 120, Memory: 100 << 20}, pod.Containers[0].Usage)
+	assert.Equal(t, ResourceAmounts{CPU: 250, Memory: 256 << 20}, pod.Containers[0].Requests)
+}
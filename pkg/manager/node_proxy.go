@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// keep the same limit as the agent collectors, logs on the host can be huge
+	nodeProxyLogMaxBytes    = 10 << 20
+	nodeProxyRequestTimeout = time.Minute
+	nodeProxyConcurrency    = 5
+	nodeProxyJournalLines   = 20000
+)
+
+var (
+	nodeProxyLogLinkRegexp = regexp.MustCompile(`href="([^"?]+)"`)
+
+	// binary or compressed files kubelet serves under /logs/
+	nodeProxySkippedLogSuffixes = []string{".gz", ".xz", ".bz2", ".zst", "lastlog", "wtmp", "btmp", "faillog"}
+
+	// kubelet endpoints and where they are stored in the node bundle. Files
+	// under configs/ are loaded as NodeConfig objects by the simulator.
+	nodeProxyEndpoints = []struct {
+		path string
+		file string
+	}{
+		{path: "configz", file: "configs/kubelet-configz.json"},
+		{path: "healthz", file: "kubelet/healthz"},
+		{path: "metrics", file: "kubelet/metrics"},
+		{path: "stats/summary", file: "kubelet/stats-summary.json"},
+	}
+
+	// services queried from the journal, only works when the kubelet has the
+	// NodeLogQuery feature enabled
+	nodeProxyJournalServices = []string{"kubelet", "containerd", "k3s", "k3s-agent", "rke2-server", "rke2-agent"}
+)
+
+// NodeProxyCollector collects a node bundle without an agent, only through
+// the API server and the kubelet proxy.
+type NodeProxyCollector struct {
+	ctx context.Context
+	sbm *SupportBundleManager
+}
+
+func NewNodeProxyCollector(ctx context.Context, sbm *SupportBundleManager) *NodeProxyCollector {
+	return &NodeProxyCollector{
+		ctx: ctx,
+		sbm: sbm,
+	}
+}
+
+// Collect writes a node bundle with the same layout agents upload
+func (c *NodeProxyCollector) Collect(node *corev1.Node, nodeBundle string) error {
+	logrus.Debugf("Collecting node bundle of %s through the API server", node.Name)
+
+	tmpDir, err := os.MkdirTemp(c.sbm.OutputDir, "node-proxy-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	bundleDir := filepath.Join(tmpDir, node.Name)
+	if err := os.MkdirAll(bundleDir, os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	errLog, err := os.Create(filepath.Join(bundleDir, "collectionError.log"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	c.collectNodeObject(node, bundleDir, errLog)
+	c.collectNodeEvents(node.Name, bundleDir, errLog)
+	c.collectKubeletEndpoints(node.Name, bundleDir, errLog)
+	c.collectKubeletLogs(node.Name, filepath.Join(bundleDir, "logs"), errLog)
+	c.collectJournalLogs(node.Name, filepath.Join(bundleDir, "logs"), errLog)
+
+	nodeBundle, err = filepath.Abs(nodeBundle)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(nodeBundle)
+	cmd := exec.Command("zip", "-r", nodeBundle, node.Name)
+	cmd.Dir = tmpDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "fail to compress node bundle: %s", string(out))
+	}
+	return c.sbm.verifyNodeBundle(nodeBundle)
+}
+
+func (c *NodeProxyCollector) collectNodeObject(node *corev1.Node, bundleDir string, errLog io.Writer) {
+	obj := node.DeepCopy()
+	obj.APIVersion = "v1"
+	obj.Kind = "Node"
+	obj.ManagedFields = nil
+	encodeToYAMLFile(obj, filepath.Join(bundleDir, "node.yaml"), errLog)
+}
+
+func (c *NodeProxyCollector) collectNodeEvents(nodeName string, bundleDir string, errLog io.Writer) {
+	events, err := c.sbm.k8s.WithContext(c.ctx).GetEventsByInvolvedObject(corev1.NamespaceAll, "Node", nodeName)
+	if err != nil {
+		_, _ = fmt.Fprintf(errLog, "Failed to get events of node %s: %v\n", nodeName, err)
+		return
+	}
+	events.APIVersion = "v1"
+	events.Kind = "List"
+	for i := range events.Items {
+		events.Items[i].APIVersion = "v1"
+		events.Items[i].Kind = "Event"
+		events.Items[i].ManagedFields = nil
+	}
+	encodeToYAMLFile(events, filepath.Join(bundleDir, "events.yaml"), errLog)
+}
+
+func (c *NodeProxyCollector) collectKubeletEndpoints(nodeName string, bundleDir string, errLog io.Writer) {
+	for _, endpoint := range nodeProxyEndpoints {
+		stream, err := c.sbm.k8s.GetNodeProxyRequest(nodeName, endpoint.path).Timeout(nodeProxyRequestTimeout).Stream(c.ctx)
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Failed to get kubelet %s of node %s: %v\n", endpoint.path, nodeName, err)
+			continue
+		}
+		streamLogToFile(io.NopCloser(io.LimitReader(stream, nodeProxyLogMaxBytes)), filepath.Join(bundleDir, endpoint.file), errLog)
+		_ = stream.Close()
+	}
+}
+
+// collectKubeletLogs downloads the top level files kubelet serves from the
+// node's /var/log through /logs/
+func (c *NodeProxyCollector) collectKubeletLogs(nodeName string, logsDir string, errLog io.Writer) {
+	listing, err := c.sbm.k8s.GetNodeProxyRequest(nodeName, "logs/").Timeout(nodeProxyRequestTimeout).DoRaw(c.ctx)
+	if err != nil {
+		_, _ = fmt.Fprintf(errLog, "Failed to list kubelet logs of node %s: %v\n", nodeName, err)
+		return
+	}
+
+	for _, name := range parseNodeLogListing(string(listing)) {
+		c.collectKubeletLog(nodeName, name, filepath.Join(logsDir, name), errLog)
+	}
+}
+
+func (c *NodeProxyCollector) collectKubeletLog(nodeName, name, path string, errLog io.Writer) {
+	stream, err := c.sbm.k8s.GetNodeProxyRequest(nodeName, "logs/"+name).
+		SetHeader("Range", fmt.Sprintf("bytes=-%d", nodeProxyLogMaxBytes)).
+		Timeout(nodeProxyRequestTimeout).
+		Stream(c.ctx)
+	if err != nil {
+		_, _ = fmt.Fprintf(errLog, "Failed to get kubelet log %s of node %s: %v\n", name, nodeName, err)
+		return
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	// kubelet honors the range header, the limit is only a safeguard
+	streamLogToFile(io.NopCloser(io.LimitReader(stream, nodeProxyLogMaxBytes)), path, errLog)
+}
+
+func (c *NodeProxyCollector) collectJournalLogs(nodeName string, logsDir string, errLog io.Writer) {
+	for _, service := range nodeProxyJournalServices {
+		b, err := c.sbm.k8s.GetNodeProxyRequest(nodeName, "logs/").
+			Param("query", service).
+			Param("tailLines", strconv.Itoa(nodeProxyJournalLines)).
+			Timeout(nodeProxyRequestTimeout).
+			DoRaw(c.ctx)
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Failed to query journal of %s on node %s: %v\n", service, nodeName, err)
+			continue
+		}
+		// an unknown unit returns an empty result, and kubelets without the
+		// feature ignore the query and return the directory listing
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 || bytes.HasPrefix(b, []byte("<pre>")) {
+			continue
+		}
+		streamLogToFile(io.NopCloser(bytes.NewReader(b)), filepath.Join(logsDir, "journal-"+service+".log"), errLog)
+	}
+}
+
+// parseNodeLogListing extracts regular text files from the directory listing
+// kubelet returns for /logs/
+func parseNodeLogListing(listing string) []string {
+	var names []string
+	for _, match := range nodeProxyLogLinkRegexp.FindAllStringSubmatch(listing, -1) {
+		name := match[1]
+		if name == "" || strings.HasSuffix(name, "/") || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+			continue
+		}
+		skip := false
+		for _, suffix := range nodeProxySkippedLogSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			names = append(names, name)
+		}
+	}
+	return names
+}
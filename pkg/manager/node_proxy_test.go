@@ -0,0 +1,22 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNodeLogListing(t *testing.T) {
+	listing := `<pre>
+<a href="apt/">apt/</a>
+<a href="containers/">containers/</a>
+<a href="dmesg">dmesg</a>
+<a href="kern.log">kern.log</a>
+<a href="syslog">syslog</a>
+<a href="syslog.2.gz">syslog.2.gz</a>
+<a href="wtmp">wtmp</a>
+<a href="../etc/shadow">../etc/shadow</a>
+</pre>`
+
+	assert.Equal(t, []string{"dmesg", "kern.log", "syslog"}, parseNodeLogListing(listing))
+}
@@ -0,0 +1,265 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+func getNodeCondition(node *v1.Node, conditionType v1.NodeConditionType) *v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == conditionType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func isNodeReady(node *v1.Node) bool {
+	cond := getNodeCondition(node, v1.NodeReady)
+	return cond != nil && cond.Status == v1.ConditionTrue
+}
+
+func isNodeNetworkUnavailable(node *v1.Node) bool {
+	cond := getNodeCondition(node, v1.NodeNetworkUnavailable)
+	return cond != nil && cond.Status == v1.ConditionTrue
+}
+
+// getNodeCollector returns how the bundle of a node should be collected.
+// Agents on nodes that are not ready or have no pod network are unlikely to
+// reach the manager, these nodes are collected through the API server instead.
+func getNodeCollector(node *v1.Node) string {
+	if isNodeReady(node) && !isNodeNetworkUnavailable(node) {
+		return NodeCollectionModeAgent
+	}
+	return NodeCollectionModeAPIServer
+}
+
+func (m *SupportBundleManager) getNodeCollector(node *v1.Node) string {
+	if m.NodeCollectionMode == NodeCollectionModeAPIServer {
+		return NodeCollectionModeAPIServer
+	}
+	return getNodeCollector(node)
+}
+
+// trackNode starts waiting for the bundle of a node that has not been seen
+// before. Must be called with nodesLock held.
+func (m *SupportBundleManager) trackNode(ctx context.Context, node *v1.Node) {
+	if _, ok := m.knownNodes[node.Name]; ok {
+		return
+	}
+	m.knownNodes[node.Name] = struct{}{}
+
+	if m.checkpoint.isNodeDone(node.Name) {
+		logrus.Debugf("Bundle of node %s was received before the manager restarted", node.Name)
+		return
+	}
+
+	if !isNodeReady(node) {
+		m.writeNodeSkipped(node)
+	}
+
+	collector := m.getNodeCollector(node)
+	logrus.Debugf("Expecting bundle from node %s (%s)", node.Name, collector)
+	m.expectedNodes[node.Name] = collector
+	if collector == NodeCollectionModeAPIServer {
+		m.startNodeViaAPIServer(ctx, node)
+	}
+}
+
+// getTargetNodeNames returns the nodes to collect. In agent mode these are the
+// nodes the DaemonSet has pods for, otherwise nodes matching the node selector.
+func (m *SupportBundleManager) getTargetNodeNames(daemonSet *appsv1.DaemonSet) ([]string, error) {
+	var names []string
+	if daemonSet == nil {
+		nodes, err := m.k8s.GetNodesListByLabels(labels.SelectorFromSet(m.getNodeSelector()).String())
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes.Items {
+			names = append(names, node.Name)
+		}
+		return names, nil
+	}
+
+	pods, err := m.listAgentPods(daemonSet)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		names = append(names, getAgentPodNodeName(&pod))
+	}
+	return names, nil
+}
+
+// syncNodes keeps the expected nodes in line with the cluster while waiting for
+// node bundles: new nodes are tracked, nodes that left are given up on, and
+// nodes that become unreachable fall back to the API server.
+func (m *SupportBundleManager) syncNodes(ctx context.Context, daemonSet *appsv1.DaemonSet) {
+	targets, err := m.getTargetNodeNames(daemonSet)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to get target nodes")
+		return
+	}
+	nodeList, err := m.k8s.GetNodesListByLabels("")
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list nodes")
+		return
+	}
+	nodes := make(map[string]*v1.Node, len(nodeList.Items))
+	for i := range nodeList.Items {
+		nodes[nodeList.Items[i].Name] = &nodeList.Items[i]
+	}
+
+	var removed []string
+	m.nodesLock.Lock()
+	if !m.done {
+		for _, name := range targets {
+			if node, ok := nodes[name]; ok {
+				m.trackNode(ctx, node)
+			}
+		}
+
+		for name, collector := range m.expectedNodes {
+			node, ok := nodes[name]
+			if !ok {
+				removed = append(removed, name)
+				continue
+			}
+			if collector == NodeCollectionModeAgent && m.getNodeCollector(node) == NodeCollectionModeAPIServer {
+				logrus.Infof("Node %s became unreachable, collecting it through the API server", name)
+				if !isNodeReady(node) {
+					m.writeNodeSkipped(node)
+				}
+				m.expectedNodes[name] = NodeCollectionModeAPIServer
+				m.startNodeViaAPIServer(ctx, node)
+			}
+		}
+	}
+	m.nodesLock.Unlock()
+
+	for _, name := range removed {
+		logrus.Warnf("Node %s left the cluster during collection", name)
+		m.writeNodeError(&NodeBundleError{
+			NodeName: name,
+			Reason:   "NodeRemoved",
+			Message:  "node left the cluster during collection",
+			FailedAt: utils.Now(),
+		})
+		m.removeExpectedNode(name, "NodeRemoved")
+	}
+}
+
+// startNodeViaAPIServer collects a node through the API server in the
+// background. The node phase waits for these collections before returning.
+func (m *SupportBundleManager) startNodeViaAPIServer(ctx context.Context, node *v1.Node) {
+	m.nodeCollectors.Add(1)
+	go func(node *v1.Node) {
+		defer m.nodeCollectors.Done()
+		m.collectNodeViaAPIServer(ctx, node)
+	}(node.DeepCopy())
+}
+
+// collectNodeViaAPIServer collects a node bundle without an agent. A bundle
+// uploaded by the agent in the meantime takes precedence.
+func (m *SupportBundleManager) collectNodeViaAPIServer(ctx context.Context, node *v1.Node) {
+	// limit the load on the API server and kubelets
+	select {
+	case m.nodeProxySlots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() {
+		<-m.nodeProxySlots
+	}()
+
+	tmpBundle := filepath.Join(m.OutputDir, fmt.Sprintf("%s.apiserver.zip", node.Name))
+	defer func() {
+		_ = os.Remove(tmpBundle)
+	}()
+
+	if err := NewNodeProxyCollector(ctx, m).Collect(node, tmpBundle); err != nil {
+		logrus.WithError(err).Warnf("Failed to collect node %s through the API server", node.Name)
+		if m.getExpectedNodeCollector(node.Name) != "" {
+			m.writeNodeError(&NodeBundleError{
+				NodeName: node.Name,
+				Reason:   "APIServerCollectionFailed",
+				Message:  err.Error(),
+				FailedAt: utils.Now(),
+			})
+			m.removeExpectedNode(node.Name, "APIServerCollectionFailed")
+		}
+		return
+	}
+
+	m.nodesLock.Lock()
+	_, ok := m.expectedNodes[node.Name]
+	if ok {
+		nodesDir := filepath.Join(m.getWorkingDir(), "nodes")
+		if err := os.MkdirAll(nodesDir, os.FileMode(0775)); err != nil {
+			logrus.WithError(err).Errorf("Failed to create directory %s", nodesDir)
+		} else if err := os.Rename(tmpBundle, filepath.Join(nodesDir, node.Name+".zip")); err != nil {
+			logrus.WithError(err).Errorf("Failed to move node bundle of %s", node.Name)
+		}
+	}
+	m.nodesLock.Unlock()
+
+	if ok {
+		m.completeNode(node.Name)
+	}
+}
+
+// writeNodeSkipped lists a node that is not ready in the bundle, together with
+// its conditions
+func (m *SupportBundleManager) writeNodeSkipped(node *v1.Node) {
+	logrus.Warnf("Node %s is not ready", node.Name)
+
+	skipped := &NodeBundleSkipped{
+		NodeName:  node.Name,
+		Reason:    "NotReady",
+		SkippedAt: utils.Now(),
+	}
+	for _, cond := range node.Status.Conditions {
+		skipped.Conditions = append(skipped.Conditions, NodeBundleCondition{
+			Type:               string(cond.Type),
+			Status:             string(cond.Status),
+			Reason:             cond.Reason,
+			Message:            cond.Message,
+			LastHeartbeatTime:  cond.LastHeartbeatTime.UTC().Format(time.RFC3339),
+			LastTransitionTime: cond.LastTransitionTime.UTC().Format(time.RFC3339),
+		})
+	}
+	m.writeNodeFile(node.Name+".skipped.yaml", skipped)
+}
+
+func (m *SupportBundleManager) writeNodeError(nodeErr *NodeBundleError) {
+	m.writeNodeFile(nodeErr.NodeName+".error.yaml", nodeErr)
+}
+
+func (m *SupportBundleManager) writeNodeFile(name string, obj interface{}) {
+	var errLog io.Writer = io.Discard
+	if f, err := m.openErrorLog(); err != nil {
+		logrus.WithError(err).Error("Failed to open bundle generation log")
+	} else {
+		defer func() {
+			_ = f.Close()
+		}()
+		errLog = f
+	}
+	encodeToYAMLFile(obj, filepath.Join(m.getWorkingDir(), "nodes", name), errLog)
+}
+
+// openErrorLog opens the bundle generation log for appending
+func (m *SupportBundleManager) openErrorLog() (*os.File, error) {
+	return os.OpenFile(filepath.Join(m.getWorkingDir(), "bundleGenerationError.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
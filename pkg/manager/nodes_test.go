@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetNodeCollector(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []corev1.NodeCondition
+		expected   string
+	}{
+		{
+			name: "ready node",
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				{Type: corev1.NodeNetworkUnavailable, Status: corev1.ConditionFalse},
+			},
+			expected: NodeCollectionModeAgent,
+		},
+		{
+			name: "not ready node",
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+			},
+			expected: NodeCollectionModeAPIServer,
+		},
+		{
+			name: "unknown node",
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionUnknown},
+			},
+			expected: NodeCollectionModeAPIServer,
+		},
+		{
+			name: "ready node without network",
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				{Type: corev1.NodeNetworkUnavailable, Status: corev1.ConditionTrue},
+			},
+			expected: NodeCollectionModeAPIServer,
+		},
+		{
+			name:     "node without conditions",
+			expected: NodeCollectionModeAPIServer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{Status: corev1.NodeStatus{Conditions: tt.conditions}}
+			assert.Equal(t, tt.expected, getNodeCollector(node))
+		})
+	}
+}
+
+func TestWaitNodesCompletedTimeout(t *testing.T) {
+	m := &SupportBundleManager{NodeTimeout: 10 * time.Millisecond}
+	m.ch = make(chan struct{})
+	m.initNodeMaps()
+	m.knownNodes["node1"] = struct{}{}
+	m.expectedNodes["node1"] = NodeCollectionModeAPIServer
+
+	m.waitNodesCompleted(context.Background(), nil)
+
+	assert.Empty(t, m.expectedNodes)
+	assert.Equal(t, "Timeout", m.failedNodes["node1"])
+	assert.True(t, m.done)
+	// a collection finishing late finds the node abandoned
+	assert.Equal(t, "", m.getExpectedNodeCollector("node1"))
+}
+
+func TestNodeCollectionsStopWithNodePhase(t *testing.T) {
+	m := &SupportBundleManager{
+		BundleName: "sample",
+		OutputDir:  t.TempDir(),
+	}
+	m.initNodeMaps()
+	// all slots are taken, the collection waits until the phase ends
+	m.nodeProxySlots = make(chan struct{}, 1)
+	m.nodeProxySlots <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.startNodeViaAPIServer(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	cancel()
+	m.nodeCollectors.Wait()
+
+	_, err := os.Stat(filepath.Join(m.getWorkingDir(), "nodes"))
+	assert.True(t, os.IsNotExist(err))
+}
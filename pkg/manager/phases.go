@@ -0,0 +1,351 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const defaultPhaseRetryInterval = 10 * time.Second
+
+type RunPhase struct {
+	Name types.ManagerPhase
+	Run  func(ctx context.Context) error
+
+	// phases that must succeed before this phase runs
+	DependsOn []types.ManagerPhase
+	// ctx of Run is cancelled after the timeout, zero means no timeout
+	Timeout time.Duration
+	// attempts after the first failure
+	Retries       int
+	RetryInterval time.Duration
+	// a soft failure is recorded in the bundle and the collection goes on,
+	// a hard failure fails the collection
+	Soft bool
+	// the phase can't be skipped
+	Mandatory bool
+	// the phase only runs when it's selected by name
+	OptIn bool
+	// the phase runs again when a collection is resumed
+	Rerun bool
+}
+
+// PhaseResult records how a phase went, it's written to metadata.yaml
+type PhaseResult struct {
+	Name       types.ManagerPhase `json:"name" yaml:"name"`
+	State      string             `json:"state" yaml:"state"`
+	StartedAt  string             `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	FinishedAt string             `json:"finishedAt,omitempty" yaml:"finishedAt,omitempty"`
+	Duration   string             `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Attempts   int                `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	Error      string             `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// PhaseRegistry holds the phases of a collection in registration order.
+// Dependencies must be registered first, so the order is always a valid run
+// order.
+type PhaseRegistry struct {
+	phases []RunPhase
+	index  map[types.ManagerPhase]int
+}
+
+func NewPhaseRegistry() *PhaseRegistry {
+	return &PhaseRegistry{
+		index: map[types.ManagerPhase]int{},
+	}
+}
+
+func (r *PhaseRegistry) Register(phase RunPhase) error {
+	if _, ok := r.index[phase.Name]; ok {
+		return fmt.Errorf("phase %s is already registered", phase.Name)
+	}
+	for _, dep := range phase.DependsOn {
+		if _, ok := r.index[dep]; !ok {
+			return fmt.Errorf("phase %s depends on unknown phase %s", phase.Name, dep)
+		}
+	}
+	r.index[phase.Name] = len(r.phases)
+	r.phases = append(r.phases, phase)
+	return nil
+}
+
+// Lookup finds a phase by name. Dashes and underscores can be used instead of
+// spaces, e.g. cluster-bundle.
+func (r *PhaseRegistry) Lookup(name string) (*RunPhase, error) {
+	normalized := strings.NewReplacer("-", " ", "_", " ").Replace(strings.TrimSpace(name))
+	i, ok := r.index[types.ManagerPhase(normalized)]
+	if !ok {
+		return nil, fmt.Errorf("unknown phase %s", name)
+	}
+	return &r.phases[i], nil
+}
+
+// Resolve returns the phases to run. Without include all phases but the
+// opt-in ones are selected, dependencies of included phases are pulled in. Skipping a phase another
+// selected phase depends on is an error.
+func (r *PhaseRegistry) Resolve(include []string, skip []string) ([]RunPhase, error) {
+	selected := map[types.ManagerPhase]bool{}
+	var addWithDeps func(phase *RunPhase)
+	addWithDeps = func(phase *RunPhase) {
+		selected[phase.Name] = true
+		for _, dep := range phase.DependsOn {
+			addWithDeps(&r.phases[r.index[dep]])
+		}
+	}
+
+	for i := range r.phases {
+		if (len(include) == 0 && !r.phases[i].OptIn) || r.phases[i].Mandatory {
+			addWithDeps(&r.phases[i])
+		}
+	}
+	for _, name := range include {
+		phase, err := r.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		addWithDeps(phase)
+	}
+
+	for _, name := range skip {
+		phase, err := r.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		if phase.Mandatory {
+			return nil, fmt.Errorf("phase %s can't be skipped", phase.Name)
+		}
+		delete(selected, phase.Name)
+	}
+
+	var phases []RunPhase
+	for _, phase := range r.phases {
+		if !selected[phase.Name] {
+			continue
+		}
+		for _, dep := range phase.DependsOn {
+			if !selected[dep] {
+				return nil, fmt.Errorf("phase %s depends on skipped phase %s", phase.Name, dep)
+			}
+		}
+		phases = append(phases, phase)
+	}
+	return phases, nil
+}
+
+// Configure applies phase options in the form
+// "<phase>:timeout=10m,retries=2,retryInterval=30s,failure=soft;<phase>:..."
+func (r *PhaseRegistry) Configure(options string) error {
+	for _, entry := range strings.Split(options, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, opts, ok := strings.Cut(entry, ":")
+		if !ok {
+			return fmt.Errorf("invalid phase options %s, expect <phase>:<key>=<value>,...", entry)
+		}
+		phase, err := r.Lookup(name)
+		if err != nil {
+			return err
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+			if !ok {
+				return fmt.Errorf("invalid option %s of phase %s", opt, phase.Name)
+			}
+			if err := setPhaseOption(phase, key, value); err != nil {
+				return errors.Wrapf(err, "invalid option %s of phase %s", opt, phase.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func setPhaseOption(phase *RunPhase, key, value string) error {
+	var err error
+	switch key {
+	case "timeout":
+		phase.Timeout, err = time.ParseDuration(value)
+	case "retries":
+		phase.Retries, err = strconv.Atoi(value)
+		if err == nil && phase.Retries < 0 {
+			err = errors.New("retries can't be negative")
+		}
+	case "retryInterval":
+		phase.RetryInterval, err = time.ParseDuration(value)
+	case "failure":
+		switch value {
+		case "soft":
+			phase.Soft = true
+		case "hard":
+			phase.Soft = false
+		default:
+			err = errors.New("failure is soft or hard")
+		}
+	default:
+		err = fmt.Errorf("unknown option %s", key)
+	}
+	return err
+}
+
+// runPhases runs the phases in order. Phases whose dependencies didn't
+// succeed are skipped, a hard failure stops the collection.
+func (m *SupportBundleManager) runPhases(phases []RunPhase) {
+	progressCount := 0
+	succeeded := map[types.ManagerPhase]bool{}
+
+	for _, phase := range phases {
+		if dep := firstFailedDependency(phase, succeeded); dep != "" {
+			logrus.Warnf("Skip phase %s, dependency %s didn't succeed", phase.Name, dep)
+			m.phaseResults = append(m.phaseResults, PhaseResult{
+				Name:  phase.Name,
+				State: types.ManagerPhaseStateSkipped,
+				Error: fmt.Sprintf("dependency %s didn't succeed", dep),
+			})
+			m.progress.publish(types.ManagerEvent{Type: types.ManagerEventPhase, Phase: phase.Name, State: types.ManagerPhaseStateSkipped})
+			progressCount++
+			m.status.SetProgress(100 * progressCount / len(phases))
+			continue
+		}
+
+		if result, ok := m.checkpoint.getSucceededPhase(phase.Name); ok && !phase.Rerun {
+			logrus.Infof("Phase %s succeeded before the manager restarted", phase.Name)
+			m.phaseResults = append(m.phaseResults, result)
+			succeeded[phase.Name] = true
+			progressCount++
+			progress := 100 * progressCount / len(phases)
+			m.status.SetProgress(progress)
+			m.progress.PhaseSucceeded(phase.Name, progress)
+			continue
+		}
+
+		err := m.runPhase(phase, &progressCount, len(phases))
+		if m.isCancelled() {
+			m.finishCancelled()
+			return
+		}
+		if err == nil {
+			succeeded[phase.Name] = true
+			continue
+		}
+		if !phase.Soft {
+			logrus.Errorf("Failed to run phase %s: %s", phase.Name, err.Error())
+			m.setState(types.SupportBundleStateError)
+			return
+		}
+		logrus.Errorf("Failed to run phase %s: %s, but the failure is soft", phase.Name, err.Error())
+	}
+}
+
+func firstFailedDependency(phase RunPhase, succeeded map[types.ManagerPhase]bool) types.ManagerPhase {
+	for _, dep := range phase.DependsOn {
+		if !succeeded[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+func (m *SupportBundleManager) runPhase(phase RunPhase, progressCount *int, maxProgressCount int) error {
+	logrus.Infof("Running phase %s", phase.Name)
+	m.status.SetPhase(phase.Name)
+	m.progress.PhaseStarted(phase.Name)
+
+	startedAt := time.Now()
+	result := PhaseResult{
+		Name:      phase.Name,
+		StartedAt: utils.Now(),
+	}
+	defer func() {
+		result.FinishedAt = utils.Now()
+		result.Duration = time.Since(startedAt).Round(time.Millisecond).String()
+		m.phaseResults = append(m.phaseResults, result)
+		m.checkpoint.recordPhase(result)
+	}()
+
+	var err error
+	for {
+		result.Attempts++
+		err = m.runPhaseAttempt(phase)
+		if err == nil || m.isCancelled() || result.Attempts > phase.Retries {
+			break
+		}
+		interval := phase.RetryInterval
+		if interval == 0 {
+			interval = defaultPhaseRetryInterval
+		}
+		logrus.Warnf("Phase %s failed: %s, retry in %s (%d/%d)", phase.Name, err.Error(), interval, result.Attempts, phase.Retries)
+		if !m.sleepCollection(interval) {
+			break
+		}
+	}
+
+	if m.isCancelled() {
+		logrus.Infof("Phase %s is cancelled", phase.Name)
+		result.State = types.ManagerPhaseStateCancelled
+		return errCollectionCancelled
+	}
+	if err != nil {
+		result.State = types.ManagerPhaseStateFailed
+		result.Error = err.Error()
+		m.progress.PhaseFailed(phase.Name, err.Error())
+		if !phase.Soft {
+			m.status.SetError(err.Error())
+			return err
+		}
+	}
+
+	*progressCount++
+	progress := 100 * (*progressCount) / maxProgressCount
+	m.status.SetProgress(progress)
+
+	if err == nil {
+		result.State = types.ManagerPhaseStateSucceeded
+		m.progress.PhaseSucceeded(phase.Name, progress)
+		logrus.Infof("Succeed to run phase %s. Progress (%d).", phase.Name, progress)
+	}
+	return err
+}
+
+func (m *SupportBundleManager) getCollectionContext() context.Context {
+	if m.collectionContext == nil {
+		return context.Background()
+	}
+	return m.collectionContext
+}
+
+func (m *SupportBundleManager) runPhaseAttempt(phase RunPhase) error {
+	ctx := m.getCollectionContext()
+	if phase.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, phase.Timeout)
+		defer cancel()
+	}
+
+	err := phase.Run(ctx)
+	// a phase may give up quietly when its context is done
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("phase %s timed out after %s", phase.Name, phase.Timeout)
+	}
+	return err
+}
+
+// sleepCollection waits unless the collection is cancelled in the meantime
+func (m *SupportBundleManager) sleepCollection(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-m.getCollectionContext().Done():
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func newTestPhaseRegistry(t *testing.T) *PhaseRegistry {
+	m := &SupportBundleManager{}
+	registry, err := m.newPhaseRegistry()
+	assert.Nil(t, err)
+	return registry
+}
+
+func phaseNames(phases []RunPhase) []types.ManagerPhase {
+	var names []types.ManagerPhase
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+	}
+	return names
+}
+
+func TestResolvePhases(t *testing.T) {
+	registry := newTestPhaseRegistry(t)
+
+	phases, err := registry.Resolve(nil, nil)
+	assert.Nil(t, err)
+	assert.Len(t, phases, 12)
+	assert.NotContains(t, phaseNames(phases), types.ManagerPhaseExternalBundle)
+
+	// the external bundle phase is opt-in
+	phases, err = registry.Resolve([]string{"external-bundle"}, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, phaseNames(phases), types.ManagerPhaseExternalBundle)
+	m := &SupportBundleManager{ExternalBundles: true}
+	optedIn, err := m.newPhaseRegistry()
+	assert.Nil(t, err)
+	phases, err = optedIn.Resolve(nil, nil)
+	assert.Nil(t, err)
+	assert.Len(t, phases, 13)
+
+	phases, err = registry.Resolve([]string{"cluster-bundle"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []types.ManagerPhase{
+		types.ManagerPhaseInit,
+		types.ManagerPhaseClusterBundle,
+		types.ManagerPhasePackaging,
+		types.ManagerPhaseDone,
+	}, phaseNames(phases))
+
+	phases, err = registry.Resolve(nil, []string{"node_bundle", "prometheus bundle"})
+	assert.Nil(t, err)
+	assert.NotContains(t, phaseNames(phases), types.ManagerPhaseNodeBundle)
+	assert.NotContains(t, phaseNames(phases), types.ManagerPhasePrometheusBundle)
+
+	_, err = registry.Resolve(nil, []string{"packaging"})
+	assert.NotNil(t, err)
+	_, err = registry.Resolve([]string{"unknown"}, nil)
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, registry.Register(RunPhase{Name: "extra", DependsOn: []types.ManagerPhase{"missing"}}))
+	assert.NotNil(t, registry.Register(RunPhase{Name: types.ManagerPhaseInit}))
+}
+
+func TestConfigurePhases(t *testing.T) {
+	registry := newTestPhaseRegistry(t)
+
+	assert.Nil(t, registry.Configure("node-bundle:timeout=10m,retries=2,retryInterval=30s;prometheus-bundle:failure=hard"))
+	phase, err := registry.Lookup("node-bundle")
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Minute, phase.Timeout)
+	assert.Equal(t, 2, phase.Retries)
+	assert.Equal(t, 30*time.Second, phase.RetryInterval)
+	phase, err = registry.Lookup("prometheus-bundle")
+	assert.Nil(t, err)
+	assert.False(t, phase.Soft)
+
+	assert.NotNil(t, registry.Configure("node-bundle"))
+	assert.NotNil(t, registry.Configure("node-bundle:retries=-1"))
+	assert.NotNil(t, registry.Configure("node-bundle:failure=maybe"))
+	assert.NotNil(t, registry.Configure("node-bundle:color=blue"))
+}
+
+func TestRunPhasesTimeoutAndRetry(t *testing.T) {
+	m := &SupportBundleManager{}
+	attempts := 0
+	m.runPhases([]RunPhase{
+		{
+			Name: types.ManagerPhaseInit,
+			Run: func(context.Context) error {
+				attempts++
+				if attempts < 3 {
+					return errors.New("flaky")
+				}
+				return nil
+			},
+			Retries:       2,
+			RetryInterval: time.Millisecond,
+		},
+		{
+			Name: types.ManagerPhaseNodeBundle,
+			Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseInit},
+			Timeout:   10 * time.Millisecond,
+			Soft:      true,
+		},
+		{
+			Name:      types.ManagerPhasePrometheusBundle,
+			Run:       func(context.Context) error { return nil },
+			DependsOn: []types.ManagerPhase{types.ManagerPhaseNodeBundle},
+		},
+	})
+
+	assert.False(t, m.status.Error)
+	assert.Equal(t, 100, m.status.Progress)
+	assert.Len(t, m.phaseResults, 3)
+	assert.Equal(t, types.ManagerPhaseStateSucceeded, m.phaseResults[0].State)
+	assert.Equal(t, 3, m.phaseResults[0].Attempts)
+	assert.Equal(t, types.ManagerPhaseStateFailed, m.phaseResults[1].State)
+	assert.Contains(t, m.phaseResults[1].Error, "timed out")
+	assert.Equal(t, types.ManagerPhaseStateSkipped, m.phaseResults[2].State)
+}
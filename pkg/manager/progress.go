@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	// events kept for consumers that connect late or reconnect
+	progressHistorySize = 1000
+	// events buffered for a subscriber before it's dropped
+	progressSubscriberBuffer = 256
+)
+
+// ProgressBroker fans out collection events to the subscribers of the event
+// stream. The zero value is ready to use.
+type ProgressBroker struct {
+	sync.Mutex
+
+	nextID      int64
+	phase       types.ManagerPhase
+	progress    int
+	counters    map[types.ManagerStep]int64
+	history     []types.ManagerEvent
+	subscribers map[chan types.ManagerEvent]struct{}
+}
+
+func (b *ProgressBroker) publish(event types.ManagerEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	event.Time = utils.Now()
+	if event.Phase == "" {
+		event.Phase = b.phase
+	}
+	event.Progress = b.progress
+
+	b.history = append(b.history, event)
+	if len(b.history) > progressHistorySize {
+		b.history = b.history[len(b.history)-progressHistorySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// a slow consumer is disconnected, it can resume with Last-Event-ID
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the events after lastID still in the history, and a
+// channel for the following events. The channel is closed by unsubscribe or
+// when the subscriber falls behind.
+func (b *ProgressBroker) Subscribe(lastID int64) ([]types.ManagerEvent, <-chan types.ManagerEvent, func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	var backlog []types.ManagerEvent
+	for _, event := range b.history {
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+
+	if b.subscribers == nil {
+		b.subscribers = map[chan types.ManagerEvent]struct{}{}
+	}
+	ch := make(chan types.ManagerEvent, progressSubscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.Lock()
+		defer b.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, unsubscribe
+}
+
+func (b *ProgressBroker) PhaseStarted(phase types.ManagerPhase) {
+	b.Lock()
+	b.phase = phase
+	b.Unlock()
+	b.publish(types.ManagerEvent{Type: types.ManagerEventPhase, State: types.ManagerPhaseStateStarted})
+}
+
+func (b *ProgressBroker) PhaseSucceeded(phase types.ManagerPhase, progress int) {
+	b.Lock()
+	b.progress = progress
+	b.Unlock()
+	b.publish(types.ManagerEvent{Type: types.ManagerEventPhase, Phase: phase, State: types.ManagerPhaseStateSucceeded})
+}
+
+func (b *ProgressBroker) PhaseFailed(phase types.ManagerPhase, message string) {
+	b.publish(types.ManagerEvent{Type: types.ManagerEventPhase, Phase: phase, State: types.ManagerPhaseStateFailed, Message: message})
+}
+
+// AddStep counts items done by a sub-step
+func (b *ProgressBroker) AddStep(step types.ManagerStep, delta int64) {
+	b.Lock()
+	if b.counters == nil {
+		b.counters = map[types.ManagerStep]int64{}
+	}
+	b.counters[step] += delta
+	count := b.counters[step]
+	b.Unlock()
+	b.publish(types.ManagerEvent{Type: types.ManagerEventProgress, Step: step, Count: count})
+}
+
+// SetStep reports the absolute progress of a sub-step
+func (b *ProgressBroker) SetStep(step types.ManagerStep, count int64, total int64) {
+	b.Lock()
+	if b.counters == nil {
+		b.counters = map[types.ManagerStep]int64{}
+	}
+	b.counters[step] = count
+	b.Unlock()
+	b.publish(types.ManagerEvent{Type: types.ManagerEventProgress, Step: step, Count: count, Total: total})
+}
+
+func (b *ProgressBroker) Warning(item string, message string) {
+	b.publish(types.ManagerEvent{Type: types.ManagerEventWarning, Item: item, Message: message})
+}
+
+// WarningWriter returns a writer for bundle generation logs that also streams
+// each message as a warning
+func (b *ProgressBroker) WarningWriter(w io.Writer) io.Writer {
+	return &warningWriter{broker: b, w: w}
+}
+
+type warningWriter struct {
+	broker *ProgressBroker
+	w      io.Writer
+}
+
+func (w *warningWriter) Write(p []byte) (int, error) {
+	if message := strings.TrimSpace(string(p)); message != "" {
+		w.broker.Warning("", message)
+	}
+	return w.w.Write(p)
+}
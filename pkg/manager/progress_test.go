@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func TestProgressBrokerResume(t *testing.T) {
+	var b ProgressBroker
+	b.PhaseStarted(types.ManagerPhaseClusterBundle)
+	b.AddStep(types.ManagerStepResources, 1)
+	b.AddStep(types.ManagerStepResources, 1)
+	b.Warning("", "failed to get logs")
+
+	backlog, events, unsubscribe := b.Subscribe(2)
+	defer unsubscribe()
+	assert.Len(t, backlog, 2)
+	assert.Equal(t, int64(3), backlog[0].ID)
+	assert.Equal(t, int64(2), backlog[0].Count)
+	assert.Equal(t, types.ManagerPhaseClusterBundle, backlog[0].Phase)
+	assert.Equal(t, types.ManagerEventWarning, backlog[1].Type)
+
+	b.PhaseSucceeded(types.ManagerPhaseClusterBundle, 40)
+	event := <-events
+	assert.Equal(t, types.ManagerEventPhase, event.Type)
+	assert.Equal(t, types.ManagerPhaseStateSucceeded, event.State)
+	assert.Equal(t, 40, event.Progress)
+}
+
+func TestProgressBrokerSlowSubscriber(t *testing.T) {
+	var b ProgressBroker
+	_, events, unsubscribe := b.Subscribe(0)
+	defer unsubscribe()
+
+	for i := 0; i < progressSubscriberBuffer+1; i++ {
+		b.AddStep(types.ManagerStepLogs, 1)
+	}
+	count := 0
+	for range events {
+		count++
+	}
+	assert.Equal(t, progressSubscriberBuffer, count)
+}
+
+func TestProgressWarningWriter(t *testing.T) {
+	var b ProgressBroker
+	var buf bytes.Buffer
+	w := b.WarningWriter(&buf)
+	_, err := w.Write([]byte("Support Bundle: failed to generate a.yaml: denied\n"))
+	assert.Nil(t, err)
+
+	backlog, _, unsubscribe := b.Subscribe(0)
+	defer unsubscribe()
+	assert.Len(t, backlog, 1)
+	assert.Equal(t, "Support Bundle: failed to generate a.yaml: denied", backlog[0].Message)
+	assert.Equal(t, "Support Bundle: failed to generate a.yaml: denied\n", buf.String())
+}
+
+func TestStreamEvents(t *testing.T) {
+	m := &SupportBundleManager{}
+	m.progress.PhaseStarted(types.ManagerPhaseInit)
+	m.progress.PhaseSucceeded(types.ManagerPhaseInit, 20)
+	s := &HttpServer{context: context.Background(), manager: m}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	s.streamEvents(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.True(t, strings.HasPrefix(body, "id: 2\nevent: phase\ndata: {"), body)
+	assert.NotContains(t, body, "id: 1\n")
+}
@@ -0,0 +1,279 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	DefaultPrometheusNamespace = "cattle-monitoring-system"
+	DefaultPrometheusSelector  = "app.kubernetes.io/name=prometheus"
+	DefaultPrometheusRange     = 6 * time.Hour
+
+	prometheusDir = "prometheus"
+	// range queries return about this many samples per series
+	prometheusRangeSamples = 240
+	prometheusMinStep      = 30 * time.Second
+)
+
+// defaultPrometheusQueries are collected over the range unless replaced by
+// the queries file
+var defaultPrometheusQueries = map[string]string{
+	"node-cpu-usage":    `1 - avg by (instance) (rate(node_cpu_seconds_total{mode="idle"}[5m]))`,
+	"node-memory-usage": `1 - node_memory_MemAvailable_bytes / node_memory_MemTotal_bytes`,
+	"node-disk-usage":   `1 - node_filesystem_avail_bytes{fstype!~"tmpfs|overlay|squashfs"} / node_filesystem_size_bytes{fstype!~"tmpfs|overlay|squashfs"}`,
+	"pod-restarts":      `sum by (namespace, pod) (increase(kube_pod_container_status_restarts_total[10m])) > 0`,
+}
+
+// PrometheusOptions tells where the Prometheus is and what to collect from
+// it. Empty fields take the defaults.
+type PrometheusOptions struct {
+	// URL of the Prometheus, e.g. https://prometheus.example.com, instead of
+	// finding it in the cluster
+	URL       string
+	Namespace string
+	// Service is <name> or <name>:<port> of a Service in Namespace to use
+	// instead of the pods
+	Service string
+	// Selector of the Prometheus pods, the replicas are tried in turn
+	Selector string
+	Port     int
+
+	TLS                bool
+	CAFile             string
+	InsecureSkipVerify bool
+
+	// Range of the range queries, back from the collection
+	Range time.Duration
+	// QueriesFile is a YAML file of names and PromQL queries replacing the
+	// default range queries
+	QueriesFile string
+}
+
+// prometheusQueryResult is written to prometheus/queries/<name>.json
+type prometheusQueryResult struct {
+	Query    string      `json:"query"`
+	Start    time.Time   `json:"start"`
+	End      time.Time   `json:"end"`
+	Step     string      `json:"step"`
+	Warnings []string    `json:"warnings,omitempty"`
+	Result   interface{} `json:"result"`
+}
+
+func (o PrometheusOptions) withDefaults() PrometheusOptions {
+	if o.Namespace == "" {
+		o.Namespace = DefaultPrometheusNamespace
+	}
+	if o.Selector == "" {
+		o.Selector = DefaultPrometheusSelector
+	}
+	if o.Port == 0 {
+		o.Port = utils.PrometheusPort
+	}
+	if o.Range == 0 {
+		o.Range = DefaultPrometheusRange
+	}
+	return o
+}
+
+func (o PrometheusOptions) getScheme() string {
+	if o.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+func (o PrometheusOptions) getTLSConfig() (*tls.Config, error) {
+	if !o.TLS && !strings.HasPrefix(o.URL, "https://") {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		caPEM, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to read prometheus CA")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CAFile)
+		}
+	}
+	return config, nil
+}
+
+func (o PrometheusOptions) getQueries() (map[string]string, error) {
+	if o.QueriesFile == "" {
+		return defaultPrometheusQueries, nil
+	}
+	b, err := os.ReadFile(o.QueriesFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read prometheus queries")
+	}
+	queries := map[string]string{}
+	if err := yaml.Unmarshal(b, &queries); err != nil {
+		return nil, errors.Wrap(err, "fail to parse prometheus queries")
+	}
+	for name := range queries {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid prometheus query name %q", name)
+		}
+	}
+	return queries, nil
+}
+
+// getPrometheusAddresses returns the addresses of the Prometheus, an HA
+// deployment has one per replica
+func (m *SupportBundleManager) getPrometheusAddresses(o PrometheusOptions) ([]string, error) {
+	if o.URL != "" {
+		return []string{o.URL}, nil
+	}
+	if o.Service != "" {
+		name, port, ok := strings.Cut(o.Service, ":")
+		if !ok {
+			port = strconv.Itoa(o.Port)
+		}
+		host := fmt.Sprintf("%s.%s.svc", name, o.Namespace)
+		return []string{fmt.Sprintf("%s://%s", o.getScheme(), net.JoinHostPort(host, port))}, nil
+	}
+
+	pods, err := m.k8s.GetPodsListByLabels(o.Namespace, o.Selector)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get prometheus pods")
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	var addresses []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		addresses = append(addresses, fmt.Sprintf("%s://%s", o.getScheme(), net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(o.Port))))
+	}
+	return addresses, nil
+}
+
+func (m *SupportBundleManager) phaseCollectPrometheusBundle(ctx context.Context) error {
+	o := m.Prometheus.withDefaults()
+	queries, err := o.getQueries()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := o.getTLSConfig()
+	if err != nil {
+		return err
+	}
+	addresses, err := m.getPrometheusAddresses(o)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		logrus.Info("prometheus pods not found")
+		return nil
+	}
+
+	errLog, err := m.openErrorLog()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = errLog.Close()
+	}()
+
+	// replicas have the same data, the first one answering is collected
+	var errs []string
+	for _, address := range addresses {
+		p, err := utils.NewPrometheus(address, tlsConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to new prometheus")
+		}
+		alerts, err := p.GetAlerts(ctx)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to get prometheus alerts from %s", address)
+			errs = append(errs, fmt.Sprintf("%s: %v", address, err))
+			continue
+		}
+		logrus.Infof("Collecting prometheus bundle from %s", address)
+		dir := filepath.Join(m.getWorkingDir(), prometheusDir)
+		writeJSONFile(filepath.Join(dir, "alerts.json"), alerts, errLog)
+		// where the alerts were before, for the tools reading bundles
+		writeJSONFile(filepath.Join(m.getWorkingDir(), "prometheus-alerts.json"), alerts, errLog)
+		collectPrometheus(ctx, p, o, queries, dir, errLog)
+		return nil
+	}
+	return fmt.Errorf("failed to get prometheus alerts: %s", strings.Join(errs, "; "))
+}
+
+// collectPrometheus collects what it can, failures are written to the error
+// log of the bundle
+func collectPrometheus(ctx context.Context, p *utils.Prometheus, o PrometheusOptions, queries map[string]string, dir string, errLog io.Writer) {
+	if rules, err := p.GetRules(ctx); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get prometheus rules: %v\n", err)
+	} else {
+		writeJSONFile(filepath.Join(dir, "rules.json"), rules, errLog)
+	}
+	if targets, err := p.GetTargets(ctx); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get prometheus targets: %v\n", err)
+	} else {
+		writeJSONFile(filepath.Join(dir, "targets.json"), targets, errLog)
+	}
+	if tsdb, err := p.GetTSDBStatus(ctx); err != nil {
+		_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to get prometheus TSDB status: %v\n", err)
+	} else {
+		writeJSONFile(filepath.Join(dir, "tsdb.json"), tsdb, errLog)
+	}
+
+	end := time.Now().UTC().Truncate(time.Second)
+	r := v1.Range{
+		Start: end.Add(-o.Range),
+		End:   end,
+		Step:  getPrometheusStep(o.Range),
+	}
+	for name, query := range queries {
+		value, warnings, err := p.QueryRange(ctx, query, r)
+		if err != nil {
+			_, _ = fmt.Fprintf(errLog, "Support Bundle: failed to query prometheus %s: %v\n", name, err)
+			continue
+		}
+		writeJSONFile(filepath.Join(dir, "queries", name+".json"), prometheusQueryResult{
+			Query:    query,
+			Start:    r.Start,
+			End:      r.End,
+			Step:     r.Step.String(),
+			Warnings: warnings,
+			Result:   value,
+		}, errLog)
+	}
+}
+
+func getPrometheusStep(r time.Duration) time.Duration {
+	step := (r / prometheusRangeSamples).Truncate(time.Second)
+	if step < prometheusMinStep {
+		return prometheusMinStep
+	}
+	return step
+}
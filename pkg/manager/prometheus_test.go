@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakePrometheus(t *testing.T) *httptest.Server {
+	data := map[string]string{
+		"/api/v1/alerts":      `{"alerts": [{"labels": {"alertname": "KubePodCrashLooping"}, "state": "firing", "value": "1"}]}`,
+		"/api/v1/rules":       `{"groups": []}`,
+		"/api/v1/targets":     `{"activeTargets": [], "droppedTargets": []}`,
+		"/api/v1/query_range": `{"resultType": "matrix", "result": [{"metric": {"instance": "node1"}, "values": [[1700000000, "0.5"]]}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d, ok := data[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"status": "error", "errorType": "not_found", "error": "not found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "success", "data": ` + d + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCollectPrometheusBundle(t *testing.T) {
+	server := newFakePrometheus(t)
+	queriesFile := filepath.Join(t.TempDir(), "queries.yaml")
+	assert.Nil(t, os.WriteFile(queriesFile, []byte("node-load: node_load1\n"), 0644))
+
+	m := &SupportBundleManager{
+		OutputDir: t.TempDir(),
+		Prometheus: PrometheusOptions{
+			URL:         server.URL,
+			Range:       time.Hour,
+			QueriesFile: queriesFile,
+		},
+	}
+	assert.Nil(t, os.MkdirAll(m.getWorkingDir(), 0755))
+	assert.Nil(t, m.phaseCollectPrometheusBundle(context.Background()))
+
+	dir := filepath.Join(m.getWorkingDir(), prometheusDir)
+	for _, name := range []string{"alerts.json", "rules.json", "targets.json", "queries/node-load.json"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "queries", "node-cpu-usage.json"))
+	// the alerts stay where bundles of earlier releases have them
+	assert.FileExists(t, filepath.Join(m.getWorkingDir(), "prometheus-alerts.json"))
+
+	b, err := os.ReadFile(filepath.Join(dir, "queries", "node-load.json"))
+	assert.Nil(t, err)
+	result := prometheusQueryResult{}
+	assert.Nil(t, json.Unmarshal(b, &result))
+	assert.Equal(t, "node_load1", result.Query)
+	assert.Equal(t, time.Hour, result.End.Sub(result.Start))
+	assert.Equal(t, "30s", result.Step)
+
+	// the TSDB status isn't served, the failure is logged in the bundle
+	assert.NoFileExists(t, filepath.Join(dir, "tsdb.json"))
+	errLog, err := os.ReadFile(filepath.Join(m.getWorkingDir(), "bundleGenerationError.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(errLog), "failed to get prometheus TSDB status")
+}
+
+func TestCollectPrometheusBundleUnreachable(t *testing.T) {
+	server := newFakePrometheus(t)
+	server.Close()
+
+	m := &SupportBundleManager{
+		OutputDir:  t.TempDir(),
+		Prometheus: PrometheusOptions{URL: server.URL},
+	}
+	assert.Nil(t, os.MkdirAll(m.getWorkingDir(), 0755))
+	assert.NotNil(t, m.phaseCollectPrometheusBundle(context.Background()))
+}
+
+func TestPrometheusAddresses(t *testing.T) {
+	m := &SupportBundleManager{}
+
+	addresses, err := m.getPrometheusAddresses(PrometheusOptions{Service: "rancher-monitoring-prometheus", TLS: true}.withDefaults())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://rancher-monitoring-prometheus.cattle-monitoring-system.svc:9090"}, addresses)
+
+	addresses, err = m.getPrometheusAddresses(PrometheusOptions{Namespace: "monitoring", Service: "prometheus:8080"}.withDefaults())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"http://prometheus.monitoring.svc:8080"}, addresses)
+
+	assert.Equal(t, 90*time.Second, getPrometheusStep(6*time.Hour))
+	assert.Equal(t, prometheusMinStep, getPrometheusStep(10*time.Minute))
+}
@@ -0,0 +1,656 @@
+package manager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/rancher/wrangler/pkg/signals"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/manager/collectors"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	serviceBundleFile = "bundle.json"
+
+	retentionCheckInterval = 10 * time.Minute
+)
+
+var (
+	errBundleNotFound = errors.New("bundle is not found")
+	errBundleRunning  = errors.New("bundle is still collecting, cancel it first")
+
+	errInvalidBundleRequest = errors.New("invalid bundle request")
+)
+
+// Service is a long-running manager. It accepts bundle requests over its API
+// and runs each collection in its own directory, at most MaxConcurrent at a
+// time, the others are queued.
+type Service struct {
+	// Defaults holds the settings of the collections, BundleName names the
+	// service
+	Defaults      *SupportBundleManager
+	MaxConcurrent int
+	// Schedule is a cron expression, e.g. "0 2 * * *", to request bundles
+	// periodically
+	Schedule string
+	// RetentionCount and RetentionDays limit the finished collections kept,
+	// zero keeps them all
+	RetentionCount int
+	RetentionDays  int
+	// Triggers request bundles on conditions in the cluster
+	Triggers []*Trigger
+
+	context   context.Context
+	auth      *apiAuth
+	state     *LocalStore
+	slots     chan struct{}
+	cron      *cron.Cron
+	cronEntry cron.EntryID
+	// collect runs a collection, replaced in tests
+	collect func(m *SupportBundleManager) error
+
+	lock    sync.RWMutex
+	bundles map[string]*serviceBundle
+}
+
+// serviceBundle is a collection of the service, persisted in its directory to
+// be picked up again after a restart
+type serviceBundle struct {
+	ID        string              `json:"id"`
+	CreatedAt string              `json:"createdAt"`
+	Request   apiv1.BundleRequest `json:"request"`
+	Scheduled bool                `json:"scheduled,omitempty"`
+	Trigger   *apiv1.Trigger      `json:"trigger,omitempty"`
+
+	manager  *SupportBundleManager
+	queued   bool
+	finished bool
+}
+
+func NewService(defaults *SupportBundleManager, maxConcurrent int) *Service {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Service{
+		Defaults:      defaults,
+		MaxConcurrent: maxConcurrent,
+		collect:       (*SupportBundleManager).Collect,
+		slots:         make(chan struct{}, maxConcurrent),
+		bundles:       map[string]*serviceBundle{},
+	}
+}
+
+func (s *Service) Run() error {
+	d := s.Defaults
+	if d.BundleName == "" {
+		return errors.New("service name is not specified")
+	}
+	if err := s.initSchedule(); err != nil {
+		return err
+	}
+	s.context = signals.SetupSignalContext()
+	d.context = s.context
+	d.PodNamespace = utils.PodNamespace()
+	s.state = NewLocalStore(d.PodNamespace, d.BundleName)
+	if err := os.MkdirAll(s.getBundlesDir(), 0755); err != nil {
+		return err
+	}
+
+	if d.SecureAPI || len(s.Triggers) > 0 {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		if d.k8s, err = client.NewKubernetesClient(s.context, restConfig); err != nil {
+			return err
+		}
+	}
+	if d.SecureAPI {
+		var err error
+		if s.auth, err = newAPIAuth(d.ManagerPodIP); err != nil {
+			return errors.Wrap(err, "fail to generate manager API credentials")
+		}
+		d.auth = s.auth
+		if err := d.createAuthSecret(s.context); err != nil {
+			return errors.Wrap(err, "fail to create manager API credentials secret")
+		}
+	}
+
+	if err := s.restore(); err != nil {
+		return err
+	}
+	if s.cron != nil {
+		s.cron.Start()
+		defer s.cron.Stop()
+		logrus.Infof("Support bundles are requested on schedule %s", s.Schedule)
+	}
+	if s.RetentionDays > 0 {
+		go s.enforceRetention()
+	}
+	if len(s.Triggers) > 0 {
+		w := newTriggerWatcher(s.Triggers, d.Namespaces, s.requestTriggered)
+		if err := w.Run(d.k8s.NewInformerFactory(triggerInformerResync), s.context.Done()); err != nil {
+			return errors.Wrap(err, "fail to watch triggers")
+		}
+		logrus.Infof("Support bundles are requested by %d triggers", len(s.Triggers))
+	}
+
+	logrus.Infof("Support bundle manager service %s is running, up to %d collections at a time", d.BundleName, s.MaxConcurrent)
+	if err := serveAPI(s.newRouter(), s.auth); err != nil {
+		return errors.Wrap(err, "fail to serve the service API")
+	}
+	<-s.context.Done()
+	return nil
+}
+
+// getBundlesDir returns the directory holding a directory per collection
+func (s *Service) getBundlesDir() string {
+	d := s.Defaults
+	if d.StateDir != "" {
+		return d.StateDir
+	}
+	if d.OutputDir != "" {
+		return d.OutputDir
+	}
+	return filepath.Join(os.TempDir(), "support-bundle-kit")
+}
+
+func newBundleID() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(b)), nil
+}
+
+// newCollection sets up the manager of a collection from the defaults of the
+// service and the request
+func (s *Service) newCollection(id string, req apiv1.BundleRequest) *SupportBundleManager {
+	d := s.Defaults
+	dir := filepath.Join(s.getBundlesDir(), id)
+	m := &SupportBundleManager{}
+	copySettings(m, d)
+	m.Namespaces = append([]string(nil), d.Namespaces...)
+	m.ExcludeResourceList = append([]string(nil), d.ExcludeResourceList...)
+	m.BundleCollectors = append([]string(nil), d.BundleCollectors...)
+	m.Phases = append([]string(nil), d.Phases...)
+	m.SkipPhases = append([]string(nil), d.SkipPhases...)
+	m.BundleName = id
+	m.OutputDir = dir
+	m.IssueURL = req.IssueURL
+	m.Description = req.Description
+
+	m.context = s.context
+	m.state = s.state
+	m.auth = s.auth
+	m.embedded = true
+	m.nodeUploadPath = apiv1.BundlePrefix(id) + "/nodes"
+	m.authSecretName = d.getAuthSecretName()
+	m.managerBundleName = d.getManagerBundleName()
+	if d.StateDir != "" {
+		m.StateDir = dir
+	}
+	if len(req.Namespaces) > 0 {
+		m.Namespaces = req.Namespaces
+	}
+	m.BundleCollectors = append(m.BundleCollectors, req.ExtraCollectors...)
+	m.ExcludeResourceList = append(m.ExcludeResourceList, req.ExcludeResources...)
+	if req.NodeCollectionMode != "" {
+		m.NodeCollectionMode = req.NodeCollectionMode
+	}
+	if len(req.Phases) > 0 {
+		m.Phases = req.Phases
+	}
+	if len(req.SkipPhases) > 0 {
+		m.SkipPhases = req.SkipPhases
+	}
+	return m
+}
+
+// copySettings copies the exported fields, which are the settings of a
+// manager, and leaves the state of dst alone. The locks of the state keep the
+// manager from being copied by value.
+func copySettings(dst, src *SupportBundleManager) {
+	dv, sv := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		if sv.Type().Field(i).IsExported() {
+			dv.Field(i).Set(sv.Field(i))
+		}
+	}
+}
+
+// Submit queues a new collection
+func (s *Service) Submit(req apiv1.BundleRequest) (*serviceBundle, error) {
+	return s.submit(req, false, nil)
+}
+
+func (s *Service) submit(req apiv1.BundleRequest, scheduled bool, trigger *apiv1.Trigger) (*serviceBundle, error) {
+	id, err := newBundleID()
+	if err != nil {
+		return nil, err
+	}
+	b := &serviceBundle{
+		ID: id,
+		// collections of the same second are kept apart by the retention
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Request:   req,
+		Scheduled: scheduled,
+		Trigger:   trigger,
+		manager:   s.newCollection(id, req),
+	}
+	if err := b.manager.validateRequest(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBundleRequest, err)
+	}
+	if err := s.save(b); err != nil {
+		return nil, err
+	}
+	s.start(b)
+	logrus.Infof("Support bundle %s is requested", id)
+	return b, nil
+}
+
+// validateRequest checks the settings a request can change before the
+// collection is queued
+func (m *SupportBundleManager) validateRequest(req apiv1.BundleRequest) error {
+	switch m.NodeCollectionMode {
+	case "", NodeCollectionModeAgent, NodeCollectionModeAPIServer:
+	default:
+		return fmt.Errorf("invalid node collection mode %s", m.NodeCollectionMode)
+	}
+	// spec files are only taken from the settings of the service, a request
+	// can't have the manager read its files
+	for _, moduleName := range req.ExtraCollectors {
+		if err := collectors.CheckBuiltinCollector(moduleName); err != nil {
+			return err
+		}
+	}
+	for _, moduleName := range m.BundleCollectors {
+		if err := collectors.CheckModuleCollector(moduleName); err != nil {
+			return err
+		}
+	}
+	_, err := m.resolvePhases()
+	return err
+}
+
+func (s *Service) save(b *serviceBundle) error {
+	dir := filepath.Join(s.getBundlesDir(), b.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, serviceBundleFile), data, 0644)
+}
+
+// restore picks up the collections of a previous service pod, unfinished ones
+// resume from their checkpoint
+func (s *Service) restore() error {
+	if s.Defaults.StateDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(s.getBundlesDir())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(s.getBundlesDir(), entry.Name(), serviceBundleFile))
+		if err != nil {
+			continue
+		}
+		b := &serviceBundle{}
+		if err := json.Unmarshal(data, b); err != nil || b.ID != entry.Name() {
+			logrus.Warnf("Ignore invalid bundle %s", entry.Name())
+			continue
+		}
+		logrus.Infof("Restore support bundle %s", b.ID)
+		s.start(b)
+	}
+	return nil
+}
+
+func (s *Service) start(b *serviceBundle) {
+	if b.manager == nil {
+		b.manager = s.newCollection(b.ID, b.Request)
+	}
+	b.manager.trigger = b.Trigger
+	b.queued = true
+	s.state.AddSupportBundle(b.manager.PodNamespace, b.ID)
+
+	s.lock.Lock()
+	s.bundles[b.ID] = b
+	s.lock.Unlock()
+
+	go s.run(b)
+}
+
+func (s *Service) run(b *serviceBundle) {
+	s.slots <- struct{}{}
+	defer func() {
+		<-s.slots
+	}()
+
+	s.lock.Lock()
+	b.queued = false
+	_, exists := s.bundles[b.ID]
+	s.lock.Unlock()
+
+	// deleted or cancelled while queued
+	if !exists {
+		return
+	}
+	if b.manager.isCancelled() {
+		b.manager.finishCancelled()
+	} else if err := s.collect(b.manager); err != nil {
+		logrus.WithError(err).Errorf("Failed to collect support bundle %s", b.ID)
+		b.manager.status.SetError(err.Error())
+		b.manager.setState(types.SupportBundleStateError)
+	}
+
+	s.lock.Lock()
+	b.finished = true
+	s.lock.Unlock()
+	s.collectGarbage()
+}
+
+// initSchedule sets up the cron requesting bundles on the schedule
+func (s *Service) initSchedule() error {
+	if s.Schedule == "" {
+		return nil
+	}
+	s.cron = cron.New()
+	id, err := s.cron.AddFunc(s.Schedule, s.requestScheduled)
+	if err != nil {
+		return errors.Wrapf(err, "invalid schedule %s", s.Schedule)
+	}
+	s.cronEntry = id
+	return nil
+}
+
+// requestScheduled requests a bundle with the settings of the service, unless
+// the previous scheduled bundle is still collecting
+func (s *Service) requestScheduled() {
+	s.lock.RLock()
+	busy := ""
+	for _, b := range s.bundles {
+		if b.Scheduled && !b.finished {
+			busy = b.ID
+		}
+	}
+	s.lock.RUnlock()
+	if busy != "" {
+		logrus.Warnf("Skip scheduled support bundle, %s is still collecting", busy)
+		return
+	}
+
+	d := s.Defaults
+	b, err := s.submit(apiv1.BundleRequest{Description: d.Description, IssueURL: d.IssueURL}, true, nil)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to request scheduled support bundle")
+		return
+	}
+	logrus.Infof("Support bundle %s is requested on schedule", b.ID)
+}
+
+// requestTriggered requests a bundle with the settings of the service when a
+// trigger fires
+func (s *Service) requestTriggered(trigger *apiv1.Trigger) {
+	d := s.Defaults
+	req := apiv1.BundleRequest{Description: d.Description, IssueURL: d.IssueURL}
+	if req.Description == "" {
+		req.Description = fmt.Sprintf("%s: %s", trigger.Object, trigger.Reason)
+	}
+	b, err := s.submit(req, false, trigger)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to request support bundle for trigger %s", trigger.Name)
+		return
+	}
+	logrus.Infof("Support bundle %s is requested by trigger %s", b.ID, trigger.Name)
+}
+
+func (s *Service) enforceRetention() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.collectGarbage()
+		case <-s.context.Done():
+			return
+		}
+	}
+}
+
+// collectGarbage deletes the finished collections beyond the retention, the
+// newest are kept
+func (s *Service) collectGarbage() {
+	if s.RetentionCount <= 0 && s.RetentionDays <= 0 {
+		return
+	}
+	s.lock.RLock()
+	var finished []*serviceBundle
+	for _, b := range s.bundles {
+		if b.finished {
+			finished = append(finished, b)
+		}
+	}
+	s.lock.RUnlock()
+	sortBundles(finished)
+
+	maxAge := time.Duration(s.RetentionDays) * 24 * time.Hour
+	for i, b := range finished {
+		kept := len(finished) - i
+		expired := s.RetentionCount > 0 && kept > s.RetentionCount
+		if maxAge > 0 && time.Since(b.getCreatedAt()) > maxAge {
+			expired = true
+		}
+		if !expired {
+			continue
+		}
+		logrus.Infof("Support bundle %s is beyond the retention", b.ID)
+		if err := s.Delete(b.ID); err != nil && err != errBundleNotFound {
+			logrus.WithError(err).Errorf("Failed to delete support bundle %s", b.ID)
+		}
+	}
+}
+
+// getCreatedAt returns the zero time if the creation time is invalid, the
+// collection is then the oldest
+func (b *serviceBundle) getCreatedAt() time.Time {
+	createdAt, _ := time.Parse(time.RFC3339Nano, b.CreatedAt)
+	return createdAt
+}
+
+// sortBundles sorts the collections oldest first
+func sortBundles(bundles []*serviceBundle) {
+	sort.Slice(bundles, func(i, j int) bool {
+		ti, tj := bundles[i].getCreatedAt(), bundles[j].getCreatedAt()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return bundles[i].ID < bundles[j].ID
+	})
+}
+
+func (s *Service) getBundle(id string) (*serviceBundle, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	b, ok := s.bundles[id]
+	return b, ok
+}
+
+func (s *Service) toAPIBundle(b *serviceBundle) apiv1.Bundle {
+	s.lock.RLock()
+	queued := b.queued
+	s.lock.RUnlock()
+
+	state, err := s.state.GetState(b.manager.PodNamespace, b.ID)
+	if err != nil {
+		state = types.SupportBundleStateNone
+	}
+	b.manager.status.RLock()
+	status := apiv1.NewStatus(b.manager.status.ManagerStatus)
+	b.manager.status.RUnlock()
+	return apiv1.Bundle{
+		ID:        b.ID,
+		CreatedAt: b.CreatedAt,
+		State:     state,
+		Queued:    queued,
+		Scheduled: b.Scheduled,
+		Trigger:   b.Trigger,
+		Request:   b.Request,
+		Status:    status,
+	}
+}
+
+// Delete removes a finished or queued collection and its files
+func (s *Service) Delete(id string) error {
+	b, ok := s.getBundle(id)
+	if !ok {
+		return errBundleNotFound
+	}
+	// a queued collection is removed before run dequeues it, run skips it
+	// then
+	s.lock.Lock()
+	if b.queued {
+		if err := b.manager.Cancel(false); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+	} else if !b.finished {
+		s.lock.Unlock()
+		return errBundleRunning
+	}
+	delete(s.bundles, id)
+	s.lock.Unlock()
+	s.state.RemoveSupportBundle(b.manager.PodNamespace, id)
+	logrus.Infof("Delete support bundle %s", id)
+	return os.RemoveAll(filepath.Join(s.getBundlesDir(), id))
+}
+
+func (s *Service) createBundle(w http.ResponseWriter, req *http.Request) {
+	bundleReq := apiv1.BundleRequest{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&bundleReq); err != nil {
+			utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("invalid bundle request: %v", err))
+			return
+		}
+	}
+	b, err := s.Submit(bundleReq)
+	if errors.Is(err, errInvalidBundleRequest) {
+		utils.HttpResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", apiv1.BundlePrefix(b.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(s.toAPIBundle(b))
+}
+
+func (s *Service) listBundles(w http.ResponseWriter, req *http.Request) {
+	s.lock.RLock()
+	bundles := make([]*serviceBundle, 0, len(s.bundles))
+	for _, b := range s.bundles {
+		bundles = append(bundles, b)
+	}
+	s.lock.RUnlock()
+	sortBundles(bundles)
+
+	list := apiv1.BundleList{Bundles: []apiv1.Bundle{}}
+	for _, b := range bundles {
+		list.Bundles = append(list.Bundles, s.toAPIBundle(b))
+	}
+	utils.HttpResponseOKWithBody(w, list)
+}
+
+func (s *Service) getSchedule(w http.ResponseWriter, req *http.Request) {
+	schedule := apiv1.Schedule{
+		Schedule:       s.Schedule,
+		RetentionCount: s.RetentionCount,
+		RetentionDays:  s.RetentionDays,
+	}
+	if s.cron != nil {
+		if next := s.cron.Entry(s.cronEntry).Next; !next.IsZero() {
+			schedule.NextRun = next.UTC().Format(time.RFC3339)
+		}
+	}
+	utils.HttpResponseOKWithBody(w, schedule)
+}
+
+func (s *Service) getBundleInfo(w http.ResponseWriter, req *http.Request) {
+	b, ok := s.getBundle(mux.Vars(req)["bundleID"])
+	if !ok {
+		utils.HttpResponseError(w, http.StatusNotFound, errBundleNotFound)
+		return
+	}
+	utils.HttpResponseOKWithBody(w, s.toAPIBundle(b))
+}
+
+func (s *Service) deleteBundle(w http.ResponseWriter, req *http.Request) {
+	err := s.Delete(mux.Vars(req)["bundleID"])
+	switch err {
+	case nil:
+		utils.HttpResponseStatus(w, http.StatusNoContent)
+	case errBundleNotFound:
+		utils.HttpResponseError(w, http.StatusNotFound, err)
+	case errBundleRunning, errCancelNotAllowed:
+		utils.HttpResponseError(w, http.StatusConflict, err)
+	default:
+		utils.HttpResponseError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// withBundle serves an endpoint of a single collection
+func (s *Service) withBundle(handler func(*HttpServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		b, ok := s.getBundle(mux.Vars(req)["bundleID"])
+		if !ok {
+			utils.HttpResponseError(w, http.StatusNotFound, errBundleNotFound)
+			return
+		}
+		handler(&HttpServer{context: s.context, manager: b.manager}, w, req)
+	}
+}
+
+func (s *Service) newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.UseEncodedPath()
+	auth := s.auth
+
+	bundlePath := apiv1.BundlesPath + "/{bundleID}"
+	r.Path(apiv1.BundlesPath).Methods("POST").HandlerFunc(auth.require(apiRoleConsumer, s.createBundle))
+	r.Path(apiv1.BundlesPath).Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.listBundles))
+	r.Path(apiv1.SchedulePath).Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.getSchedule))
+	r.Path(bundlePath).Methods("GET").HandlerFunc(auth.require(apiRoleConsumer, s.getBundleInfo))
+	r.Path(bundlePath).Methods("DELETE").HandlerFunc(auth.require(apiRoleConsumer, s.deleteBundle))
+	for _, route := range bundleRoutes {
+		r.Path(bundlePath + route.path).Methods(route.method).HandlerFunc(auth.require(route.role, s.withBundle(route.handler)))
+	}
+	r.Path(apiv1.OpenAPIPath).Methods("GET").HandlerFunc(getOpenAPI)
+	return r
+}
@@ -0,0 +1,277 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func newTestService(t *testing.T, collect func(m *SupportBundleManager) error) (*Service, *apiv1.Client) {
+	auth, err := newAPIAuth("127.0.0.1")
+	assert.Nil(t, err)
+
+	s := NewService(&SupportBundleManager{
+		BundleName: "service",
+		OutputDir:  t.TempDir(),
+		Namespaces: []string{"harvester-system"},
+	}, 1)
+	s.context = context.Background()
+	s.auth = auth
+	s.state = NewLocalStore("harvester-system", "service")
+	s.collect = collect
+
+	server := httptest.NewUnstartedServer(s.newRouter())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{*auth.cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	c, err := apiv1.NewClient(server.URL, apiv1.WithCA(auth.caPEM), apiv1.WithToken(auth.consumerToken))
+	assert.Nil(t, err)
+	return s, c
+}
+
+func waitBundleState(t *testing.T, c *apiv1.Client, id string, state types.SupportBundleState) *apiv1.Bundle {
+	var bundle *apiv1.Bundle
+	assert.Eventually(t, func() bool {
+		var err error
+		bundle, err = c.GetBundle(context.Background(), id)
+		return err == nil && bundle.State == state
+	}, 5*time.Second, 10*time.Millisecond)
+	return bundle
+}
+
+func TestServiceBundles(t *testing.T) {
+	release := make(chan struct{})
+	s, c := newTestService(t, func(m *SupportBundleManager) error {
+		<-release
+		assert.Nil(t, os.MkdirAll(m.OutputDir, 0755))
+		m.bundleFileName = "supportbundle_" + m.BundleName + ".zip"
+		assert.Nil(t, os.WriteFile(m.getBundlefile(), []byte(m.Description), 0644))
+		m.status.SetFileinfo(m.bundleFileName, int64(len(m.Description)))
+		m.status.SetProgress(100)
+		m.setState(types.SupportBundleStateReady)
+		return nil
+	})
+	ctx := context.Background()
+
+	first, err := c.CreateBundle(ctx, apiv1.BundleRequest{Description: "first", Namespaces: []string{"longhorn-system"}})
+	assert.Nil(t, err)
+	second, err := c.CreateBundle(ctx, apiv1.BundleRequest{Description: "second"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// one collection at a time
+	assert.Eventually(t, func() bool {
+		b, err := c.GetBundle(ctx, second.ID)
+		return err == nil && b.Queued
+	}, 5*time.Second, 10*time.Millisecond)
+
+	b, _ := s.getBundle(first.ID)
+	assert.Equal(t, []string{"longhorn-system"}, b.manager.Namespaces)
+	assert.Equal(t, filepath.Join(s.getBundlesDir(), first.ID), b.manager.OutputDir)
+	assert.Equal(t, "/v1/bundles/"+first.ID+"/nodes", b.manager.getNodeUploadPath())
+
+	// the queued collection is cancelled on deletion
+	assert.Nil(t, c.DeleteBundle(ctx, second.ID))
+	_, err = c.GetBundle(ctx, second.ID)
+	assert.Equal(t, http.StatusNotFound, err.(*apiv1.APIError).StatusCode)
+
+	// a running collection can't be deleted
+	err = c.DeleteBundle(ctx, first.ID)
+	assert.Equal(t, http.StatusConflict, err.(*apiv1.APIError).StatusCode)
+
+	close(release)
+	waitBundleState(t, c, first.ID, types.SupportBundleStateReady)
+
+	list, err := c.ListBundles(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, list.Bundles, 1)
+
+	status, err := c.Bundle(first.ID).Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, status.Progress)
+
+	var buf bytes.Buffer
+	fileName, _, err := c.Bundle(first.ID).Download(ctx, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "supportbundle_"+first.ID+".zip", fileName)
+	assert.Equal(t, "first", buf.String())
+
+	_, err = c.Bundle("missing").Status(ctx)
+	assert.Equal(t, http.StatusNotFound, err.(*apiv1.APIError).StatusCode)
+
+	assert.Nil(t, c.DeleteBundle(ctx, first.ID))
+	assert.NoDirExists(t, filepath.Join(s.getBundlesDir(), first.ID))
+}
+
+func TestServiceInvalidRequest(t *testing.T) {
+	_, c := newTestService(t, func(m *SupportBundleManager) error { return nil })
+
+	_, err := c.CreateBundle(context.Background(), apiv1.BundleRequest{SkipPhases: []string{"packaging"}})
+	assert.Equal(t, http.StatusBadRequest, err.(*apiv1.APIError).StatusCode)
+	_, err = c.CreateBundle(context.Background(), apiv1.BundleRequest{NodeCollectionMode: "ssh"})
+	assert.Equal(t, http.StatusBadRequest, err.(*apiv1.APIError).StatusCode)
+	_, err = c.CreateBundle(context.Background(), apiv1.BundleRequest{ExtraCollectors: []string{"unknown"}})
+	assert.Equal(t, http.StatusBadRequest, err.(*apiv1.APIError).StatusCode)
+	// spec files are never read for a request
+	_, err = c.CreateBundle(context.Background(), apiv1.BundleRequest{ExtraCollectors: []string{"/etc/passwd"}})
+	assert.Equal(t, http.StatusBadRequest, err.(*apiv1.APIError).StatusCode)
+	assert.NotContains(t, err.Error(), "root")
+}
+
+func TestServiceNewCollection(t *testing.T) {
+	s, _ := newTestService(t, func(m *SupportBundleManager) error { return nil })
+	s.Defaults.MetricsSamples = 3
+	s.Defaults.SecureAPI = true
+	s.Defaults.BundleCollectors = []string{"harvester"}
+
+	m := s.newCollection("abc", apiv1.BundleRequest{Description: "nightly", ExtraCollectors: []string{"longhorn"}})
+	assert.Equal(t, "abc", m.BundleName)
+	assert.Equal(t, "nightly", m.Description)
+	assert.Equal(t, 3, m.MetricsSamples)
+	assert.True(t, m.SecureAPI)
+	assert.True(t, m.embedded)
+	assert.Equal(t, []string{"harvester", "longhorn"}, m.BundleCollectors)
+	assert.Equal(t, []string{"harvester"}, s.Defaults.BundleCollectors)
+	assert.Empty(t, m.StateDir)
+}
+
+func TestServiceAgentDaemonSet(t *testing.T) {
+	s, _ := newTestService(t, func(m *SupportBundleManager) error { return nil })
+	s.Defaults.NodeCollectionMode = NodeCollectionModeAgent
+	s.Defaults.AgentSecurityProfile = AgentSecurityProfileFull
+	s.Defaults.PodNamespace = "harvester-system"
+	// the pod of the service is labeled with the name of the service
+	clientSet := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "harvester-system",
+			Name:      "supportbundle-manager-service",
+			UID:       "uid",
+			Labels: map[string]string{
+				"app":                       types.SupportBundleManager,
+				types.SupportBundleLabelKey: "service",
+			},
+		},
+	})
+
+	m := s.newCollection("abc", apiv1.BundleRequest{})
+	m.k8s = client.NewKubernetesClientFor(context.Background(), clientSet)
+	agents := &AgentDaemonSet{sbm: m}
+	daemonSet, err := agents.Create("rancher/support-bundle-kit:master", "http://10.0.0.1:8080")
+	assert.Nil(t, err)
+	assert.Equal(t, "supportbundle-agent-abc", daemonSet.Name)
+	assert.Equal(t, "abc", daemonSet.Spec.Template.Labels[types.SupportBundleLabelKey])
+	assert.Equal(t, "supportbundle-manager-service", daemonSet.OwnerReferences[0].Name)
+}
+
+func TestServiceRestore(t *testing.T) {
+	s, _ := newTestService(t, func(m *SupportBundleManager) error { return nil })
+	s.Defaults.StateDir = s.Defaults.OutputDir
+
+	b, err := s.Submit(apiv1.BundleRequest{Description: "nightly"})
+	assert.Nil(t, err)
+	assert.FileExists(t, filepath.Join(s.getBundlesDir(), b.ID, serviceBundleFile))
+
+	restarted, _ := newTestService(t, func(m *SupportBundleManager) error { return nil })
+	restarted.Defaults.StateDir = s.Defaults.OutputDir
+	assert.Nil(t, restarted.restore())
+	restored, ok := restarted.getBundle(b.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "nightly", restored.manager.Description)
+	assert.Equal(t, filepath.Join(s.getBundlesDir(), b.ID), restored.manager.StateDir)
+}
+
+func TestServiceRetention(t *testing.T) {
+	s, c := newTestService(t, func(m *SupportBundleManager) error {
+		m.setState(types.SupportBundleStateReady)
+		return nil
+	})
+	s.RetentionCount = 2
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		b, err := s.Submit(apiv1.BundleRequest{})
+		assert.Nil(t, err)
+		ids = append(ids, b.ID)
+	}
+	assert.Eventually(t, func() bool {
+		list, err := c.ListBundles(ctx)
+		return err == nil && len(list.Bundles) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	_, ok := s.getBundle(ids[0])
+	assert.False(t, ok)
+	assert.NoDirExists(t, filepath.Join(s.getBundlesDir(), ids[0]))
+
+	s, _ = newTestService(t, func(m *SupportBundleManager) error { return nil })
+	s.RetentionDays = 7
+	for id, age := range map[string]time.Duration{"old": 8 * 24 * time.Hour, "recent": time.Hour} {
+		s.bundles[id] = &serviceBundle{
+			ID:        id,
+			CreatedAt: time.Now().Add(-age).UTC().Format(time.RFC3339),
+			manager:   s.newCollection(id, apiv1.BundleRequest{}),
+			finished:  true,
+		}
+	}
+	s.collectGarbage()
+	_, ok = s.getBundle("old")
+	assert.False(t, ok)
+	_, ok = s.getBundle("recent")
+	assert.True(t, ok)
+}
+
+func TestServiceSchedule(t *testing.T) {
+	release := make(chan struct{})
+	s, c := newTestService(t, func(m *SupportBundleManager) error {
+		<-release
+		m.setState(types.SupportBundleStateReady)
+		return nil
+	})
+	s.Defaults.Description = "nightly"
+	s.Schedule = "@daily"
+	s.RetentionCount = 7
+	assert.Nil(t, s.initSchedule())
+	s.cron.Start()
+	defer s.cron.Stop()
+	ctx := context.Background()
+
+	schedule, err := c.GetSchedule(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "@daily", schedule.Schedule)
+	assert.Equal(t, 7, schedule.RetentionCount)
+	assert.NotEmpty(t, schedule.NextRun)
+
+	// the next run is skipped while the previous scheduled bundle collects
+	s.requestScheduled()
+	s.requestScheduled()
+	list, err := c.ListBundles(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, list.Bundles, 1)
+	assert.True(t, list.Bundles[0].Scheduled)
+	assert.Equal(t, "nightly", list.Bundles[0].Request.Description)
+
+	close(release)
+	waitBundleState(t, c, list.Bundles[0].ID, types.SupportBundleStateReady)
+	s.requestScheduled()
+	list, err = c.ListBundles(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, list.Bundles, 2)
+
+	s.Schedule = "every day"
+	assert.NotNil(t, s.initSchedule())
+}
@@ -36,3 +36,10 @@ func (s *ManagerStatus) SetFileinfo(filename string, filesize int64) {
 	s.FileName = filename
 	s.FileSize = filesize
 }
+
+func (s *ManagerStatus) SetCancelled(partial bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.Cancelled = true
+	s.Partial = partial
+}
@@ -0,0 +1,336 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	// TriggerPodRestarts fires when the restarts of a container reach the
+	// threshold
+	TriggerPodRestarts = "pod-restarts"
+	// TriggerNodeNotReady fires when a node is NotReady for the duration
+	TriggerNodeNotReady = "node-not-ready"
+	// TriggerWarningEvent fires on Warning events whose reason or message
+	// match the pattern
+	TriggerWarningEvent = "warning-event"
+
+	defaultTriggerCooldown  = 30 * time.Minute
+	defaultRestartThreshold = 5
+	defaultNotReadyDuration = 5 * time.Minute
+	triggerInformerResync   = 30 * time.Second
+)
+
+// Trigger is a condition in the cluster that requests a bundle
+type Trigger struct {
+	Name string
+	// restarts of a container, for pod-restarts
+	Threshold int32
+	// how long a node is NotReady, for node-not-ready
+	Duration time.Duration
+	// matched against the reason and message, for warning-event
+	Pattern *regexp.Regexp
+	// the trigger doesn't request another bundle within the cooldown
+	Cooldown time.Duration
+
+	lastFired time.Time
+}
+
+// ParseTriggers parses triggers in the form
+// "<trigger>:<key>=<value>,...;<trigger>:...", e.g.
+// "pod-restarts:threshold=10;node-not-ready:duration=10m,cooldown=1h"
+func ParseTriggers(spec string) ([]*Trigger, error) {
+	var triggers []*Trigger
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, opts, _ := strings.Cut(entry, ":")
+		trigger := &Trigger{
+			Name:     name,
+			Cooldown: defaultTriggerCooldown,
+		}
+		switch name {
+		case TriggerPodRestarts:
+			trigger.Threshold = defaultRestartThreshold
+		case TriggerNodeNotReady:
+			trigger.Duration = defaultNotReadyDuration
+		case TriggerWarningEvent:
+		default:
+			return nil, fmt.Errorf("unknown trigger %s", name)
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			if strings.TrimSpace(opt) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid option %s of trigger %s", opt, name)
+			}
+			if err := setTriggerOption(trigger, key, value); err != nil {
+				return nil, errors.Wrapf(err, "invalid option %s of trigger %s", opt, name)
+			}
+		}
+		if trigger.Name == TriggerWarningEvent && trigger.Pattern == nil {
+			return nil, fmt.Errorf("trigger %s needs a pattern", name)
+		}
+		triggers = append(triggers, trigger)
+	}
+	return triggers, nil
+}
+
+func setTriggerOption(trigger *Trigger, key, value string) error {
+	var err error
+	switch {
+	case key == "cooldown":
+		trigger.Cooldown, err = time.ParseDuration(value)
+	case key == "threshold" && trigger.Name == TriggerPodRestarts:
+		var threshold int64
+		threshold, err = strconv.ParseInt(value, 10, 32)
+		if err == nil && threshold < 1 {
+			err = errors.New("threshold must be positive")
+		}
+		trigger.Threshold = int32(threshold)
+	case key == "duration" && trigger.Name == TriggerNodeNotReady:
+		trigger.Duration, err = time.ParseDuration(value)
+	case key == "pattern" && trigger.Name == TriggerWarningEvent:
+		trigger.Pattern, err = regexp.Compile(value)
+	default:
+		err = fmt.Errorf("unknown option %s", key)
+	}
+	return err
+}
+
+// triggerWatcher watches pods, nodes and events and requests a bundle when a
+// trigger fires
+type triggerWatcher struct {
+	triggers []*Trigger
+	// pods and events outside of the namespaces are ignored, all are watched
+	// without namespaces
+	namespaces map[string]bool
+	startedAt  time.Time
+	// request is called when a trigger fires
+	request func(trigger *apiv1.Trigger)
+
+	lock sync.Mutex
+	// the NotReady transitions a bundle was requested for, by node
+	notReadySince map[string]time.Time
+}
+
+func newTriggerWatcher(triggers []*Trigger, namespaces []string, request func(trigger *apiv1.Trigger)) *triggerWatcher {
+	w := &triggerWatcher{
+		triggers:      triggers,
+		namespaces:    map[string]bool{},
+		startedAt:     time.Now(),
+		request:       request,
+		notReadySince: map[string]time.Time{},
+	}
+	for _, namespace := range namespaces {
+		w.namespaces[namespace] = true
+	}
+	return w
+}
+
+func (w *triggerWatcher) has(name string) bool {
+	for _, trigger := range w.triggers {
+		if trigger.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *triggerWatcher) inNamespace(namespace string) bool {
+	return len(w.namespaces) == 0 || w.namespaces[namespace]
+}
+
+// Run starts the informers of the triggers, until stopCh is closed
+func (w *triggerWatcher) Run(factory informers.SharedInformerFactory, stopCh <-chan struct{}) error {
+	if w.has(TriggerPodRestarts) {
+		_, err := factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, ok1 := oldObj.(*corev1.Pod)
+				newPod, ok2 := newObj.(*corev1.Pod)
+				if ok1 && ok2 {
+					w.onPodUpdate(oldPod, newPod)
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if w.has(TriggerNodeNotReady) {
+		// resyncs check NotReady nodes again, they may not be updated for a while
+		_, err := factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if node, ok := obj.(*corev1.Node); ok {
+					w.onNode(node)
+				}
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				if node, ok := obj.(*corev1.Node); ok {
+					w.onNode(node)
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if w.has(TriggerWarningEvent) {
+		onEvent := func(obj interface{}) {
+			if event, ok := obj.(*corev1.Event); ok {
+				w.onEvent(event)
+			}
+		}
+		_, err := factory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: onEvent,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldEvent, ok1 := oldObj.(*corev1.Event)
+				newEvent, ok2 := newObj.(*corev1.Event)
+				// an event series is updated when it happens again
+				if ok1 && ok2 && oldEvent.Count != newEvent.Count {
+					onEvent(newEvent)
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	factory.Start(stopCh)
+	for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("fail to sync informer of %v", informerType)
+		}
+	}
+	return nil
+}
+
+// fire requests a bundle unless the trigger is cooling down, it tells if a
+// bundle is requested
+func (w *triggerWatcher) fire(trigger *Trigger, object, reason string) bool {
+	w.lock.Lock()
+	if !trigger.lastFired.IsZero() && time.Since(trigger.lastFired) < trigger.Cooldown {
+		w.lock.Unlock()
+		logrus.Debugf("Trigger %s is cooling down, ignore %s: %s", trigger.Name, object, reason)
+		return false
+	}
+	trigger.lastFired = time.Now()
+	w.lock.Unlock()
+
+	logrus.Infof("Trigger %s fired by %s: %s", trigger.Name, object, reason)
+	w.request(&apiv1.Trigger{
+		Name:   trigger.Name,
+		Object: object,
+		Reason: reason,
+		Time:   utils.Now(),
+	})
+	return true
+}
+
+// onPodUpdate fires when the restarts of a container reach the threshold
+func (w *triggerWatcher) onPodUpdate(oldPod, newPod *corev1.Pod) {
+	if !w.inNamespace(newPod.Namespace) {
+		return
+	}
+	oldRestarts := map[string]int32{}
+	for _, status := range oldPod.Status.ContainerStatuses {
+		oldRestarts[status.Name] = status.RestartCount
+	}
+	for _, trigger := range w.triggers {
+		if trigger.Name != TriggerPodRestarts {
+			continue
+		}
+		for _, status := range newPod.Status.ContainerStatuses {
+			if oldRestarts[status.Name] < trigger.Threshold && status.RestartCount >= trigger.Threshold {
+				w.fire(trigger, fmt.Sprintf("Pod %s/%s", newPod.Namespace, newPod.Name),
+					fmt.Sprintf("container %s restarted %d times", status.Name, status.RestartCount))
+				break
+			}
+		}
+	}
+}
+
+// onNode fires once per NotReady period of a node that's longer than the
+// duration
+func (w *triggerWatcher) onNode(node *corev1.Node) {
+	var ready *corev1.NodeCondition
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == corev1.NodeReady {
+			ready = &node.Status.Conditions[i]
+		}
+	}
+	if ready == nil || ready.Status == corev1.ConditionTrue {
+		return
+	}
+	since := ready.LastTransitionTime.Time
+	for _, trigger := range w.triggers {
+		if trigger.Name != TriggerNodeNotReady || time.Since(since) < trigger.Duration {
+			continue
+		}
+		w.lock.Lock()
+		fired := w.notReadySince[node.Name].Equal(since)
+		w.lock.Unlock()
+		if fired {
+			return
+		}
+		// a period suppressed by the cooldown fires on a later update
+		if w.fire(trigger, fmt.Sprintf("Node %s", node.Name),
+			fmt.Sprintf("NotReady for %s: %s", time.Since(since).Round(time.Second), ready.Message)) {
+			w.lock.Lock()
+			w.notReadySince[node.Name] = since
+			w.lock.Unlock()
+		}
+		return
+	}
+}
+
+// onEvent fires on Warning events that happened after the watcher started
+func (w *triggerWatcher) onEvent(event *corev1.Event) {
+	if event.Type != corev1.EventTypeWarning || !w.inNamespace(event.Namespace) {
+		return
+	}
+	if getEventTime(event).Before(w.startedAt) {
+		return
+	}
+	for _, trigger := range w.triggers {
+		if trigger.Name != TriggerWarningEvent {
+			continue
+		}
+		if trigger.Pattern.MatchString(event.Reason) || trigger.Pattern.MatchString(event.Message) {
+			involved := event.InvolvedObject
+			object := fmt.Sprintf("%s %s", involved.Kind, involved.Name)
+			if involved.Namespace != "" {
+				object = fmt.Sprintf("%s %s/%s", involved.Kind, involved.Namespace, involved.Name)
+			}
+			w.fire(trigger, object, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+		}
+	}
+}
+
+func getEventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+)
+
+type firedTriggers struct {
+	sync.Mutex
+	triggers []*apiv1.Trigger
+}
+
+func (f *firedTriggers) request(trigger *apiv1.Trigger) {
+	f.Lock()
+	defer f.Unlock()
+	f.triggers = append(f.triggers, trigger)
+}
+
+func (f *firedTriggers) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.triggers)
+}
+
+func newTestTriggerWatcher(t *testing.T, spec string) (*triggerWatcher, *firedTriggers) {
+	triggers, err := ParseTriggers(spec)
+	assert.Nil(t, err)
+	fired := &firedTriggers{}
+	return newTriggerWatcher(triggers, []string{"harvester-system"}, fired.request), fired
+}
+
+func TestParseTriggers(t *testing.T) {
+	triggers, err := ParseTriggers("pod-restarts; node-not-ready:duration=10m,cooldown=1h; warning-event:pattern=OOM|Evicted")
+	assert.Nil(t, err)
+	assert.Len(t, triggers, 3)
+	assert.Equal(t, int32(defaultRestartThreshold), triggers[0].Threshold)
+	assert.Equal(t, defaultTriggerCooldown, triggers[0].Cooldown)
+	assert.Equal(t, 10*time.Minute, triggers[1].Duration)
+	assert.Equal(t, time.Hour, triggers[1].Cooldown)
+	assert.True(t, triggers[2].Pattern.MatchString("Evicted"))
+
+	for _, spec := range []string{
+		"crash",
+		"pod-restarts:threshold=0",
+		"pod-restarts:duration=1m",
+		"warning-event",
+		"warning-event:pattern=(",
+		"node-not-ready:cooldown",
+	} {
+		_, err := ParseTriggers(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func newTestPod(namespace string, restarts int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "virt-api"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "virt-api", RestartCount: restarts}},
+		},
+	}
+}
+
+func TestTriggerPodRestarts(t *testing.T) {
+	w, fired := newTestTriggerWatcher(t, "pod-restarts:threshold=3")
+
+	w.onPodUpdate(newTestPod("harvester-system", 1), newTestPod("harvester-system", 2))
+	w.onPodUpdate(newTestPod("kube-system", 2), newTestPod("kube-system", 3))
+	assert.Equal(t, 0, fired.count())
+
+	w.onPodUpdate(newTestPod("harvester-system", 2), newTestPod("harvester-system", 3))
+	assert.Equal(t, 1, fired.count())
+	assert.Equal(t, &apiv1.Trigger{
+		Name:   TriggerPodRestarts,
+		Object: "Pod harvester-system/virt-api",
+		Reason: "container virt-api restarted 3 times",
+		Time:   fired.triggers[0].Time,
+	}, fired.triggers[0])
+
+	// crossing the threshold again is within the cooldown
+	w.triggers[0].Cooldown = time.Hour
+	w.onPodUpdate(newTestPod("harvester-system", 0), newTestPod("harvester-system", 3))
+	assert.Equal(t, 1, fired.count())
+
+	w.triggers[0].lastFired = time.Now().Add(-2 * time.Hour)
+	w.onPodUpdate(newTestPod("harvester-system", 0), newTestPod("harvester-system", 3))
+	assert.Equal(t, 2, fired.count())
+}
+
+func TestTriggerNodeNotReady(t *testing.T) {
+	w, fired := newTestTriggerWatcher(t, "node-not-ready:duration=5m,cooldown=0s")
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionUnknown,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+				Message:            "Kubelet stopped posting node status.",
+			}},
+		},
+	}
+
+	w.onNode(node)
+	assert.Equal(t, 0, fired.count())
+
+	node.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-10 * time.Minute))
+	w.onNode(node)
+	w.onNode(node)
+	assert.Equal(t, 1, fired.count())
+	assert.Equal(t, "Node node1", fired.triggers[0].Object)
+
+	// another NotReady period
+	node.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-6 * time.Minute))
+	w.onNode(node)
+	assert.Equal(t, 2, fired.count())
+
+	// a period suppressed by the cooldown fires once the cooldown is over
+	w.triggers[0].Cooldown = time.Hour
+	node.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-7 * time.Minute))
+	w.onNode(node)
+	assert.Equal(t, 2, fired.count())
+	w.triggers[0].lastFired = time.Now().Add(-2 * time.Hour)
+	w.onNode(node)
+	assert.Equal(t, 3, fired.count())
+
+	node.Status.Conditions[0].Status = corev1.ConditionTrue
+	node.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+	w.onNode(node)
+	assert.Equal(t, 3, fired.count())
+}
+
+func TestTriggerWarningEvent(t *testing.T) {
+	w, fired := newTestTriggerWatcher(t, "warning-event:pattern=FailedMount")
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "harvester-system", Name: "virt-api.1"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "harvester-system", Name: "virt-api"},
+		Type:           corev1.EventTypeWarning,
+		Reason:         "FailedMount",
+		Message:        "MountVolume.SetUp failed",
+		LastTimestamp:  metav1.NewTime(time.Now().Add(-time.Hour)),
+	}
+
+	// events before the watcher started are history
+	w.onEvent(event)
+	assert.Equal(t, 0, fired.count())
+
+	event.LastTimestamp = metav1.Now()
+	event.Type = corev1.EventTypeNormal
+	w.onEvent(event)
+	assert.Equal(t, 0, fired.count())
+
+	event.Type = corev1.EventTypeWarning
+	w.onEvent(event)
+	assert.Equal(t, 1, fired.count())
+	assert.Equal(t, "Pod harvester-system/virt-api", fired.triggers[0].Object)
+	assert.Equal(t, "FailedMount: MountVolume.SetUp failed", fired.triggers[0].Reason)
+}
+
+func TestTriggerWatcherInformers(t *testing.T) {
+	clientSet := fake.NewClientset(newTestPod("harvester-system", 0))
+	w, fired := newTestTriggerWatcher(t, "pod-restarts:threshold=1")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.Nil(t, w.Run(informers.NewSharedInformerFactory(clientSet, 0), stopCh))
+
+	_, err := clientSet.CoreV1().Pods("harvester-system").UpdateStatus(context.Background(), newTestPod("harvester-system", 1), metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return fired.count() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServiceTriggeredBundle(t *testing.T) {
+	s, c := newTestService(t, func(m *SupportBundleManager) error { return nil })
+	trigger := &apiv1.Trigger{Name: TriggerNodeNotReady, Object: "Node node1", Reason: "NotReady for 5m0s"}
+	s.requestTriggered(trigger)
+
+	list, err := c.ListBundles(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, list.Bundles, 1)
+	assert.Equal(t, trigger, list.Bundles[0].Trigger)
+	assert.Equal(t, "Node node1: NotReady for 5m0s", list.Bundles[0].Request.Description)
+	b, _ := s.getBundle(list.Bundles[0].ID)
+	assert.Equal(t, trigger, b.manager.trigger)
+}
@@ -1,6 +1,9 @@
 package manager
 
-import "github.com/rancher/support-bundle-kit/pkg/types"
+import (
+	apiv1 "github.com/rancher/support-bundle-kit/pkg/api/v1"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
 
 const (
 	PhaseInit          = "start"
@@ -12,18 +15,80 @@ const (
 	BundleVersion = "0.1.0"
 
 	ManagerPort = "8080"
+
+	// NodeCollectionModeAgent collects node bundles with a privileged agent DaemonSet
+	NodeCollectionModeAgent = "agent"
+	// NodeCollectionModeAPIServer collects node bundles through the API server's node proxy
+	NodeCollectionModeAPIServer = "apiserver"
 )
 
 type BundleMeta struct {
 	BundleName           string `json:"projectName"`
+	BundleID             string `json:"bundleID"`
 	BundleVersion        string `json:"bundleVersion"`
 	KubernetesVersion    string `json:"kubernetesVersion"`
 	ProjectNamespaceUUID string `json:"projectNamspaceUUID"`
 	BundleCreatedAt      string `json:"bundleCreatedAt"`
 	IssueURL             string `json:"issueURL"`
 	IssueDescription     string `json:"issueDescription"`
+	NodeCollectionMode   string `json:"nodeCollectionMode"`
+	AgentSecurityProfile string `json:"agentSecurityProfile,omitempty"`
+	// Trigger is the condition that requested the bundle, if any
+	Trigger *apiv1.Trigger `json:"trigger,omitempty" yaml:"trigger,omitempty"`
+	// Phases are the phases run before packaging
+	Phases []PhaseResult `json:"phases,omitempty"`
+	// ExternalBundles are the outcomes of the bundles of other products
+	ExternalBundles []ExternalBundleResult `json:"externalBundles,omitempty" yaml:"externalBundles,omitempty"`
 }
 
 type StateStoreInterface interface {
 	GetState(namespace, supportbundle string) (types.SupportBundleState, error)
+	SetState(namespace, supportbundle string, state types.SupportBundleState) error
+}
+
+// NodeBundleError is written to nodes/<node>.error.yaml when a node can't
+// deliver its bundle
+type NodeBundleError struct {
+	NodeName    string                 `yaml:"nodeName"`
+	PodName     string                 `yaml:"podName"`
+	Reason      string                 `yaml:"reason"`
+	Message     string                 `yaml:"message,omitempty"`
+	FailedAt    string                 `yaml:"failedAt"`
+	Events      []NodeBundleErrorEvent `yaml:"events,omitempty"`
+	EventsError string                 `yaml:"eventsError,omitempty"`
+	Logs        []NodeBundleErrorLog   `yaml:"logs,omitempty"`
+}
+
+type NodeBundleErrorEvent struct {
+	Type           string `yaml:"type"`
+	Reason         string `yaml:"reason"`
+	Message        string `yaml:"message"`
+	Count          int32  `yaml:"count"`
+	FirstTimestamp string `yaml:"firstTimestamp"`
+	LastTimestamp  string `yaml:"lastTimestamp"`
+}
+
+type NodeBundleErrorLog struct {
+	Container string `yaml:"container"`
+	Previous  bool   `yaml:"previous"`
+	Content   string `yaml:"content,omitempty"`
+	Error     string `yaml:"error,omitempty"`
+}
+
+// NodeBundleSkipped is written to nodes/<node>.skipped.yaml when a node is not
+// ready and no agent is expected to run there
+type NodeBundleSkipped struct {
+	NodeName   string                `yaml:"nodeName"`
+	Reason     string                `yaml:"reason"`
+	SkippedAt  string                `yaml:"skippedAt"`
+	Conditions []NodeBundleCondition `yaml:"conditions,omitempty"`
+}
+
+type NodeBundleCondition struct {
+	Type               string `yaml:"type"`
+	Status             string `yaml:"status"`
+	Reason             string `yaml:"reason,omitempty"`
+	Message            string `yaml:"message,omitempty"`
+	LastHeartbeatTime  string `yaml:"lastHeartbeatTime"`
+	LastTransitionTime string `yaml:"lastTransitionTime"`
 }
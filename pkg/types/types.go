@@ -2,6 +2,7 @@
 package types
 
 import (
+	"fmt"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -14,6 +15,7 @@ const (
 	SupportBundleStateGenerating = SupportBundleState("generating")
 	SupportBundleStateReady      = SupportBundleState("ready")
 	SupportBundleStateError      = SupportBundleState("error")
+	SupportBundleStateCancelled  = SupportBundleState("cancelled")
 
 	// labels
 	SupportBundleLabelKey = "rancher/supportbundle"
@@ -24,17 +26,36 @@ const (
 
 	PodCreationTimeout      = 5 * time.Minute
 	PodCreationWaitInterval = time.Second
+	NodeSyncInterval        = 30 * time.Second
+
+	// keys of the Secret holding the manager API credentials
+	SupportBundleAuthCAKey            = "ca.crt"
+	SupportBundleAuthAgentTokenKey    = "agent-token"
+	SupportBundleAuthConsumerTokenKey = "consumer-token"
 )
 
+// SupportBundleAuthSecretName returns the name of the Secret the manager of a
+// support bundle generates for its API credentials
+func SupportBundleAuthSecretName(bundleName string) string {
+	return fmt.Sprintf("supportbundle-manager-%s-auth", bundleName)
+}
+
 type ManagerPhase string
 
 const (
-	ManagerPhaseInit             = ManagerPhase("init")
-	ManagerPhaseClusterBundle    = ManagerPhase("cluster bundle")
-	ManagerPhasePrometheusBundle = ManagerPhase("prometheus bundle")
-	ManagerPhaseNodeBundle       = ManagerPhase("node bundle")
-	ManagerPhasePackaging        = ManagerPhase("package")
-	ManagerPhaseDone             = ManagerPhase("done")
+	ManagerPhaseInit               = ManagerPhase("init")
+	ManagerPhaseClusterBundle      = ManagerPhase("cluster bundle")
+	ManagerPhasePrometheusBundle   = ManagerPhase("prometheus bundle")
+	ManagerPhaseMetricsBundle      = ManagerPhase("metrics bundle")
+	ManagerPhaseEventsBundle       = ManagerPhase("events bundle")
+	ManagerPhaseDescribeBundle     = ManagerPhase("describe bundle")
+	ManagerPhaseControlPlaneBundle = ManagerPhase("control plane bundle")
+	ManagerPhaseHelmBundle         = ManagerPhase("helm bundle")
+	ManagerPhaseCertificatesBundle = ManagerPhase("certificates bundle")
+	ManagerPhaseExternalBundle     = ManagerPhase("external bundle")
+	ManagerPhaseNodeBundle         = ManagerPhase("node bundle")
+	ManagerPhasePackaging          = ManagerPhase("package")
+	ManagerPhaseDone               = ManagerPhase("done")
 )
 
 type ManagerStatus struct {
@@ -44,6 +65,58 @@ type ManagerStatus struct {
 	Progress     int
 	FileName     string
 	FileSize     int64
+	Cancelled    bool
+	// the bundle only has what was collected before the cancellation
+	Partial bool
+}
+
+type ManagerEventType string
+
+const (
+	// a phase started, succeeded or failed
+	ManagerEventPhase = ManagerEventType("phase")
+	// a sub-step of a phase made progress
+	ManagerEventProgress = ManagerEventType("progress")
+	// an item could not be collected, the collection goes on
+	ManagerEventWarning = ManagerEventType("warning")
+)
+
+type ManagerStep string
+
+const (
+	ManagerStepResources = ManagerStep("resources")
+	ManagerStepLogs      = ManagerStep("logs")
+	ManagerStepNodes     = ManagerStep("nodes")
+	ManagerStepBytes     = ManagerStep("bytes")
+)
+
+const (
+	ManagerPhaseStateStarted   = "started"
+	ManagerPhaseStateSucceeded = "succeeded"
+	ManagerPhaseStateFailed    = "failed"
+	ManagerPhaseStateCancelled = "cancelled"
+	ManagerPhaseStateSkipped   = "skipped"
+)
+
+// ManagerEvent is streamed by the manager as the collection goes on
+type ManagerEvent struct {
+	ID       int64            `json:"id"`
+	Type     ManagerEventType `json:"type"`
+	Time     string           `json:"time"`
+	Phase    ManagerPhase     `json:"phase"`
+	Progress int              `json:"progress"`
+
+	// phase events
+	State string `json:"state,omitempty"`
+
+	// progress events, total is zero when unknown
+	Step  ManagerStep `json:"step,omitempty"`
+	Count int64       `json:"count,omitempty"`
+	Total int64       `json:"total,omitempty"`
+
+	// warning and failed phase events
+	Item    string `json:"item,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 type SupportBundle struct {
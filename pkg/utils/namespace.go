@@ -19,3 +19,17 @@ func PodNamespace() string {
 
 	return "default"
 }
+
+// BundleNamespaces returns the namespaces a bundle always covers followed by
+// the extra ones, without duplicates
+func BundleNamespaces(extra []string) []string {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, ns := range append([]string{"default", "kube-system", "cattle-system"}, extra...) {
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
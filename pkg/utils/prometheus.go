@@ -2,10 +2,12 @@ package utils
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"net/http"
 
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 )
 
 const PrometheusPort = 9090
@@ -14,10 +16,18 @@ type Prometheus struct {
 	api v1.API
 }
 
-func NewPrometheus(host string) (*Prometheus, error) {
-	client, err := api.NewClient(api.Config{
-		Address: fmt.Sprintf("http://%s:%d", host, PrometheusPort),
-	})
+// NewPrometheus returns a client of the Prometheus at the address, e.g.
+// http://10.52.0.12:9090. tlsConfig is used for https addresses.
+func NewPrometheus(address string, tlsConfig *tls.Config) (*Prometheus, error) {
+	config := api.Config{
+		Address: address,
+	}
+	if tlsConfig != nil {
+		transport := api.DefaultRoundTripper.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		config.RoundTripper = transport
+	}
+	client, err := api.NewClient(config)
 
 	if err != nil {
 		return nil, err
@@ -35,3 +45,19 @@ func (p *Prometheus) GetAlerts(ctx context.Context) ([]v1.Alert, error) {
 
 	return result.Alerts, nil
 }
+
+func (p *Prometheus) GetRules(ctx context.Context) (v1.RulesResult, error) {
+	return p.api.Rules(ctx)
+}
+
+func (p *Prometheus) GetTargets(ctx context.Context) (v1.TargetsResult, error) {
+	return p.api.Targets(ctx)
+}
+
+func (p *Prometheus) GetTSDBStatus(ctx context.Context) (v1.TSDBResult, error) {
+	return p.api.TSDB(ctx)
+}
+
+func (p *Prometheus) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return p.api.QueryRange(ctx, query, r)
+}